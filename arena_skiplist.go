@@ -0,0 +1,281 @@
+package go_tsmm
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/breeze-go-rust/go-tsmm/internal/common"
+)
+
+const (
+	arenaMinBlock  = 4 << 10 // 第一块的大小，之后每块翻倍，子树的小批次不占用整块内存
+	arenaBlockSize = 1 << 20 // key、value 所在块的最大大小，更大的写入单独占一块
+	arenaNil       = 0       // 链表末尾，头节点不会是任何节点的后继
+	arenaNilValue  = ^uint32(0)
+)
+
+// ArenaSkipList 写入批次，语义与 SkipList 相同。节点保存在不含指针的数组中，
+// 以下标代替指针相连，key 与 value 复制进按块分配的 arena，
+// 写入与 Dump 的分配次数与 key 的个数无关，GC 也不需要扫描节点。
+// 已写入的字节不会移动或改写，Dump 返回的切片直接引用 arena
+type ArenaSkipList struct {
+	lock    sync.RWMutex // 保护以下所有字段，randSrc 只在写锁下使用
+	blocks  [][]byte     // key、value 所在的块
+	cur     int          // 继续追加的块
+	nodes   []arenaNode  // nodes[0] 为头节点
+	links   []uint32     // 各节点每层的后继，节点 i 占 links[nodes[i].tower:][:nodes[i].height]
+	level   int          // 当前最大层数
+	length  int          // 节点数量
+	bytes   int64        // arena、节点与各层后继占用的内存
+	randSrc *rand.Rand
+}
+
+// arenaRef arena 中的一段字节
+type arenaRef struct {
+	block uint32
+	off   uint32
+	len   uint32 // 为 arenaNilValue 时表示 nil
+}
+
+type arenaNode struct {
+	key    arenaRef
+	value  arenaRef
+	tower  uint32
+	height uint32
+}
+
+func NewArenaSkipList() *ArenaSkipList {
+	s := &ArenaSkipList{
+		level:   1,
+		randSrc: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	s.nodes = append(s.nodes, arenaNode{height: maxLevel})
+	s.links = make([]uint32, maxLevel)
+	return s
+}
+
+func (s *ArenaSkipList) Put(key []byte, value []byte) error {
+	s.put(key, value)
+	return nil
+}
+
+// put 写入 key 并返回占用内存的变化量。被覆盖的 value 仍留在 arena 中，计入内存
+func (s *ArenaSkipList) put(key []byte, value []byte) int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var update [maxLevel]uint32
+	current := s.findPath(key, &update)
+	before := s.bytes
+	if next := s.next(current, 0); next != arenaNil && bytes.Equal(s.ref(s.nodes[next].key), key) {
+		s.nodes[next].value = s.alloc(value)
+		return s.bytes - before
+	}
+
+	height := s.randomLevel()
+	if height > s.level {
+		for i := s.level; i < height; i++ {
+			update[i] = 0
+		}
+		s.level = height
+	}
+	id := uint32(len(s.nodes))
+	tower := uint32(len(s.links))
+	s.nodes = append(s.nodes, arenaNode{
+		key:    s.alloc(key),
+		value:  s.alloc(value),
+		tower:  tower,
+		height: uint32(height),
+	})
+	for i := 0; i < height; i++ {
+		prev := s.nodes[update[i]].tower + uint32(i)
+		s.links = append(s.links, s.links[prev])
+		s.links[prev] = id
+	}
+	s.length++
+	s.bytes += int64(unsafe.Sizeof(arenaNode{})) + int64(height)*4
+	return s.bytes - before
+}
+
+func (s *ArenaSkipList) Get(key []byte) ([]byte, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	current := uint32(0)
+	for i := s.level - 1; i >= 0; i-- {
+		for next := s.next(current, i); next != arenaNil && bytes.Compare(s.ref(s.nodes[next].key), key) < 0; next = s.next(current, i) {
+			current = next
+		}
+	}
+	if next := s.next(current, 0); next != arenaNil && bytes.Equal(s.ref(s.nodes[next].key), key) {
+		return s.ref(s.nodes[next].value), nil
+	}
+	return nil, ErrorKeyNotFound
+}
+
+// Delete 把节点从各层摘除，节点与其 key、value 占用的 arena 不回收，
+// 内存估算中只减去 key 与 value
+func (s *ArenaSkipList) Delete(key []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.del(key); !ok {
+		return ErrorKeyNotFound
+	}
+	return nil
+}
+
+// deleteRange 删除 [start, limit) 内的所有 key，limit 为 nil 时不设上界，返回占用内存的变化量
+func (s *ArenaSkipList) deleteRange(start, limit []byte) int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var (
+		update [maxLevel]uint32
+		keys   [][]byte
+	)
+	for current := s.next(s.findPath(start, &update), 0); current != arenaNil; current = s.next(current, 0) {
+		key := s.ref(s.nodes[current].key)
+		if limit != nil && bytes.Compare(key, limit) >= 0 {
+			break
+		}
+		keys = append(keys, key)
+	}
+	var delta int64
+	for _, key := range keys {
+		d, _ := s.del(key)
+		delta += d
+	}
+	return delta
+}
+
+func (s *ArenaSkipList) del(key []byte) (int64, bool) {
+	var update [maxLevel]uint32
+	current := s.next(s.findPath(key, &update), 0)
+	if current == arenaNil || !bytes.Equal(s.ref(s.nodes[current].key), key) {
+		return 0, false
+	}
+	for i := 0; i < s.level; i++ {
+		prev := s.nodes[update[i]].tower + uint32(i)
+		if s.links[prev] != current {
+			break
+		}
+		s.links[prev] = s.next(current, i)
+	}
+	for s.level > 1 && s.next(0, s.level-1) == arenaNil {
+		s.level--
+	}
+	s.length--
+	n := s.nodes[current]
+	delta := -int64(refLen(n.key) + refLen(n.value))
+	s.bytes += delta
+	return delta, true
+}
+
+func (s *ArenaSkipList) Size() int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.length
+}
+
+// Bytes 批次占用内存的估算，包含 arena、节点与各层后继
+func (s *ArenaSkipList) Bytes() int64 {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.bytes
+}
+
+// Dump 按 key 升序返回批次内容。所有 Inode 一次分配，key 与 value 引用 arena 不复制，
+// 调用方不能修改返回的 key 与 value
+func (s *ArenaSkipList) Dump() common.Inodes {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	inodes := make([]common.Inode, s.length)
+	kvs := make(common.Inodes, s.length)
+	i := 0
+	for current := s.next(0, 0); current != arenaNil; current = s.next(current, 0) {
+		n := &s.nodes[current]
+		inodes[i].SetKey(s.ref(n.key))
+		inodes[i].SetValue(s.ref(n.value))
+		kvs[i] = &inodes[i]
+		i++
+	}
+	return kvs
+}
+
+// encode 以批次格式写出全部内容，prefix 为外部 key 的前缀
+func (s *ArenaSkipList) encode(w io.Writer, prefix []byte) error {
+	e := NewBatchEncoder(w)
+	key := append([]byte(nil), prefix...)
+	for _, in := range s.Dump() {
+		key = append(key[:len(prefix)], in.Key()...)
+		if err := e.Put(key, in.Value()); err != nil {
+			return err
+		}
+	}
+	return e.Close()
+}
+
+// findPath 查找 key 的插入位置，update 记录每层最后一个小于 key 的节点，返回最底层的该节点
+func (s *ArenaSkipList) findPath(key []byte, update *[maxLevel]uint32) uint32 {
+	current := uint32(0)
+	for i := s.level - 1; i >= 0; i-- {
+		for next := s.next(current, i); next != arenaNil && bytes.Compare(s.ref(s.nodes[next].key), key) < 0; next = s.next(current, i) {
+			current = next
+		}
+		update[i] = current
+	}
+	return current
+}
+
+func (s *ArenaSkipList) next(id uint32, level int) uint32 {
+	return s.links[s.nodes[id].tower+uint32(level)]
+}
+
+// alloc 把 b 复制进 arena，b 为 nil 时不占用空间
+func (s *ArenaSkipList) alloc(b []byte) arenaRef {
+	if b == nil {
+		return arenaRef{len: arenaNilValue}
+	}
+	s.bytes += int64(len(b))
+	if len(b) > arenaBlockSize/4 {
+		// 大的写入单独占一块，不浪费当前块的剩余空间
+		s.blocks = append(s.blocks, append(make([]byte, 0, len(b)), b...))
+		return arenaRef{block: uint32(len(s.blocks) - 1), len: uint32(len(b))}
+	}
+	if s.blocks == nil || len(s.blocks[s.cur])+len(b) > cap(s.blocks[s.cur]) {
+		size := arenaMinBlock
+		if s.blocks != nil {
+			size = min(2*cap(s.blocks[s.cur]), arenaBlockSize)
+		}
+		s.blocks = append(s.blocks, make([]byte, 0, max(size, len(b))))
+		s.cur = len(s.blocks) - 1
+	}
+	off := len(s.blocks[s.cur])
+	s.blocks[s.cur] = append(s.blocks[s.cur], b...)
+	return arenaRef{block: uint32(s.cur), off: uint32(off), len: uint32(len(b))}
+}
+
+// ref 返回 arena 中的一段字节，容量截断到长度，调用方 append 时不会改写 arena
+func (s *ArenaSkipList) ref(r arenaRef) []byte {
+	if r.len == arenaNilValue {
+		return nil
+	}
+	end := r.off + r.len
+	return s.blocks[r.block][r.off:end:end]
+}
+
+func refLen(r arenaRef) int {
+	if r.len == arenaNilValue {
+		return 0
+	}
+	return int(r.len)
+}
+
+func (s *ArenaSkipList) randomLevel() int {
+	level := 1
+	for s.randSrc.Float64() < probability && level < maxLevel {
+		level++
+	}
+	return level
+}
@@ -0,0 +1,125 @@
+package go_tsmm
+
+import (
+	"bytes"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/breeze-go-rust/go-tsmm/internal/common"
+)
+
+// memTable 批次的两种实现共有的操作
+type memTable interface {
+	Put(key, value []byte) error
+	Get(key []byte) ([]byte, error)
+	Delete(key []byte) error
+	Dump() common.Inodes
+}
+
+var memTables = []struct {
+	name string
+	new  func() memTable
+}{
+	{"skiplist", func() memTable { return NewSkipList() }},
+	{"arena", func() memTable { return NewArenaSkipList() }},
+}
+
+// TestArenaSkipListMatchesModel 随机写入、覆盖与删除后两种实现的 Get、Dump 都与 map 一致
+func TestArenaSkipListMatchesModel(t *testing.T) {
+	for _, impl := range memTables {
+		rnd := rand.New(rand.NewSource(1))
+		list := impl.new()
+		model := make(map[string][]byte)
+		for i := 0; i < 20000; i++ {
+			key := []byte{byte(rnd.Intn(4)), byte(rnd.Intn(256)), byte(rnd.Intn(256))}
+			switch rnd.Intn(4) {
+			case 0:
+				err := list.Delete(key)
+				if _, ok := model[string(key)]; ok != (err == nil) {
+					t.Fatalf("%s: Delete(%x) = %v, key present %v", impl.name, key, err, ok)
+				}
+				delete(model, string(key))
+			default:
+				value := make([]byte, rnd.Intn(32))
+				rnd.Read(value)
+				if err := list.Put(key, value); err != nil {
+					t.Fatal(err)
+				}
+				model[string(key)] = value
+			}
+		}
+		keys := make([]string, 0, len(model))
+		for key, value := range model {
+			keys = append(keys, key)
+			got, err := list.Get([]byte(key))
+			if err != nil || !bytes.Equal(got, value) {
+				t.Fatalf("%s: Get(%x) = %x, %v; want %x", impl.name, key, got, err, value)
+			}
+		}
+		sort.Strings(keys)
+		kvs := list.Dump()
+		if len(kvs) != len(keys) {
+			t.Fatalf("%s: dumped %d keys, want %d", impl.name, len(kvs), len(keys))
+		}
+		for i, in := range kvs {
+			if string(in.Key()) != keys[i] || !bytes.Equal(in.Value(), model[keys[i]]) {
+				t.Fatalf("%s: dump entry %d = %x, want %x", impl.name, i, in.Key(), keys[i])
+			}
+		}
+	}
+}
+
+// benchBatchData 固定种子，两种实现写入相同的数据
+func benchBatchData(n, valueSize int) [][2][]byte {
+	rnd := rand.New(rand.NewSource(1))
+	data := make([][2][]byte, n)
+	for i := range data {
+		key := make([]byte, 40)
+		value := make([]byte, valueSize)
+		rnd.Read(key)
+		rnd.Read(value)
+		data[i] = [2][]byte{key, value}
+	}
+	return data
+}
+
+const benchBatchKeys = 100000
+
+// BenchmarkBatchPut 把同一组随机 key 写入空批次，比较指针跳表与 arena 跳表的耗时与分配
+func BenchmarkBatchPut(b *testing.B) {
+	data := benchBatchData(benchBatchKeys, 64)
+	for _, impl := range memTables {
+		b.Run(impl.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				list := impl.new()
+				for _, kv := range data {
+					_ = list.Put(kv[0], kv[1])
+				}
+			}
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*len(data)), "ns/key")
+		})
+	}
+}
+
+// BenchmarkBatchDump 按 key 升序导出整个批次
+func BenchmarkBatchDump(b *testing.B) {
+	data := benchBatchData(benchBatchKeys, 64)
+	for _, impl := range memTables {
+		b.Run(impl.name, func(b *testing.B) {
+			list := impl.new()
+			for _, kv := range data {
+				_ = list.Put(kv[0], kv[1])
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if kvs := list.Dump(); len(kvs) != len(data) {
+					b.Fatalf("dumped %d keys, want %d", len(kvs), len(data))
+				}
+			}
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*len(data)), "ns/key")
+		})
+	}
+}
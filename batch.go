@@ -0,0 +1,111 @@
+package go_tsmm
+
+import (
+	"github.com/breeze-go-rust/go-tsmm/errors"
+	"github.com/breeze-go-rust/go-tsmm/internal/common"
+)
+
+var _ Batch = (*WriteBatch)(nil)
+
+// WriteBatch 由调用方独立构建的写入批次，key 为带前缀的外部 key。
+// 批次在 Apply 之前不影响任何树，执行失败时直接丢弃即可；可被多个 goroutine 并发写入
+type WriteBatch struct {
+	list *ArenaSkipList
+}
+
+// NewBatch 创建一个空的写入批次
+func NewBatch() *WriteBatch {
+	return &WriteBatch{list: NewArenaSkipList()}
+}
+
+// Put 写入 key，value 为 nil 表示删除。key 与 value 均被复制，调用方可以复用其缓冲区
+func (wb *WriteBatch) Put(key []byte, value []byte) error {
+	if _, _, err := splitKey(key); err != nil {
+		return err
+	}
+	return wb.list.Put(key, value)
+}
+
+// Get 返回批次中 key 的 value，key 被删除时返回 nil，不在批次中时返回 ErrorKeyNotFound
+func (wb *WriteBatch) Get(key []byte) ([]byte, error) {
+	return wb.list.Get(key)
+}
+
+// Delete 在批次中记录 key 的删除，Apply 后删除树中的 key
+func (wb *WriteBatch) Delete(key []byte) error {
+	return wb.Put(key, nil)
+}
+
+// Size 批次中 key 的个数，删除也计算在内
+func (wb *WriteBatch) Size() int {
+	return wb.list.Size()
+}
+
+// Bytes 批次占用内存的估算
+func (wb *WriteBatch) Bytes() int64 {
+	return wb.list.Bytes()
+}
+
+// Dump 按 key 升序返回批次内容，value 为 nil 的元素表示删除
+func (wb *WriteBatch) Dump() common.Inodes {
+	return wb.list.Dump()
+}
+
+// Merge 将 other 合并进本批次，同一个 key 以 other 中的为准
+func (wb *WriteBatch) Merge(other Batch) error {
+	for _, in := range other.Dump() {
+		if err := wb.list.Put(in.Key(), in.Value()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reset 清空批次，不能与其他方法并发调用
+func (wb *WriteBatch) Reset() {
+	wb.list = NewArenaSkipList()
+}
+
+// Apply 将 batch 中的全部写入放入树的批次，随下一次 Commit 提交。
+// 先解析所有 key 并加载涉及的子树，任何一个失败时树保持不变
+func (b *BTree) Apply(batch Batch) error {
+	tree := b.parent()
+	if tree.isReadOnly {
+		return errors.ErrDatabaseReadOnly
+	}
+	type write struct {
+		target *BTree
+		key    []byte
+		value  []byte
+	}
+	kvs := batch.Dump()
+	writes := make([]write, 0, len(kvs))
+	// 整个批次在两次 spill 或提交之间写入，超出内存预算时写完后再 spill
+	tree.spillLock.RLock()
+	for _, in := range kvs {
+		name, realKey, err := splitKey(in.Key())
+		if err != nil {
+			tree.spillLock.RUnlock()
+			return err
+		}
+		target := tree
+		if name != nil {
+			if target, err = tree.createIfNotExists(string(name)); err != nil {
+				tree.spillLock.RUnlock()
+				return err
+			}
+		}
+		writes = append(writes, write{target: target, key: realKey, value: in.Value()})
+	}
+	for _, w := range writes {
+		if w.target != tree {
+			tree.markDirty(w.target)
+		}
+	}
+	var delta int64
+	for _, w := range writes {
+		delta += w.target.batch.put(w.key, w.value)
+	}
+	tree.spillLock.RUnlock()
+	return tree.addBatchBytes(delta)
+}
@@ -0,0 +1,327 @@
+package go_tsmm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/breeze-go-rust/go-tsmm/errors"
+	"github.com/breeze-go-rust/go-tsmm/util"
+)
+
+// 批次序列化格式：
+//
+//	magic "TSMB"(4B) | version(1B) | record... | end record
+//	record:  crc(4B) | length(4B) | payload，crc 覆盖 length 与 payload
+//	payload: type(1B) | ...
+//	  group: nameLen(uvarint) | name | entry...，nameLen 为 0 表示账户树
+//	  entry: op(1B) | keyLen(uvarint) | key | [valueLen(uvarint) | value]，删除没有 value
+//	  end:   entries(uvarint)，全部 entry 的个数，用于发现截断
+//
+// 同一子树的写入连续存放在一个或多个 group 中，key 为树内 key，不带前缀与子树名。
+// 每个 record 不超过 batchRecordSize（单个 entry 超过时独占一个 record），
+// 编解码时只需在内存中保留一个 record
+const (
+	batchMagic        = "TSMB"
+	batchVersion      = 1
+	batchRecordHeader = 8
+	batchRecordSize   = 1 << 20
+	maxBatchRecord    = 1 << 30
+
+	batchRecordGroup = 1
+	batchRecordEnd   = 2
+
+	batchOpPut    = 1
+	batchOpDelete = 2
+)
+
+// BatchEncoder 将写入流式编码为批次格式，写入顺序即解码顺序
+type BatchEncoder struct {
+	w       io.Writer
+	n       int64
+	err     error
+	started bool
+	open    bool   // payload 中是否有未写出的 group
+	name    []byte // 当前 group 的子树名
+	payload []byte
+	total   uint64
+}
+
+// NewBatchEncoder 创建写入 w 的编码器，写完后必须调用 Close
+func NewBatchEncoder(w io.Writer) *BatchEncoder {
+	return &BatchEncoder{w: w}
+}
+
+// Put 编码一次写入，value 为 nil 表示删除，key 为带前缀的外部 key
+func (e *BatchEncoder) Put(key, value []byte) error {
+	if e.err != nil {
+		return e.err
+	}
+	name, realKey, err := splitKey(key)
+	if err != nil {
+		return err
+	}
+	if e.open && (!bytes.Equal(name, e.name) || len(e.payload) >= batchRecordSize) {
+		e.flush()
+	}
+	if !e.open {
+		e.name = append(e.name[:0], name...)
+		e.payload = append(e.payload[:0], batchRecordGroup)
+		e.payload = binary.AppendUvarint(e.payload, uint64(len(name)))
+		e.payload = append(e.payload, name...)
+		e.open = true
+	}
+	if value == nil {
+		e.payload = append(e.payload, batchOpDelete)
+	} else {
+		e.payload = append(e.payload, batchOpPut)
+	}
+	e.payload = binary.AppendUvarint(e.payload, uint64(len(realKey)))
+	e.payload = append(e.payload, realKey...)
+	if value != nil {
+		e.payload = binary.AppendUvarint(e.payload, uint64(len(value)))
+		e.payload = append(e.payload, value...)
+	}
+	e.total++
+	return e.err
+}
+
+// Delete 编码一次删除
+func (e *BatchEncoder) Delete(key []byte) error {
+	return e.Put(key, nil)
+}
+
+// Close 写出剩余的 group 与结束 record，不关闭底层的 io.Writer
+func (e *BatchEncoder) Close() error {
+	if e.open {
+		e.flush()
+	}
+	e.payload = append(e.payload[:0], batchRecordEnd)
+	e.payload = binary.AppendUvarint(e.payload, e.total)
+	e.writeRecord()
+	return e.err
+}
+
+// Written 已写入底层 io.Writer 的字节数
+func (e *BatchEncoder) Written() int64 {
+	return e.n
+}
+
+func (e *BatchEncoder) flush() {
+	e.writeRecord()
+	e.open = false
+}
+
+func (e *BatchEncoder) writeRecord() {
+	if e.err != nil {
+		return
+	}
+	if !e.started {
+		e.write(append([]byte(batchMagic), batchVersion))
+		e.started = true
+	}
+	var hdr [batchRecordHeader]byte
+	binary.LittleEndian.PutUint32(hdr[4:8], uint32(len(e.payload)))
+	binary.LittleEndian.PutUint32(hdr[0:4], util.NewCRC(hdr[4:8]).Update(e.payload).Value())
+	e.write(hdr[:])
+	e.write(e.payload)
+}
+
+func (e *BatchEncoder) write(b []byte) {
+	if e.err != nil {
+		return
+	}
+	n, err := e.w.Write(b)
+	e.n += int64(n)
+	e.err = err
+}
+
+// BatchDecoder 逐条解码批次格式的写入，内存中只保留当前 record
+type BatchDecoder struct {
+	r       io.Reader
+	n       int64
+	started bool
+	done    bool
+	payload []byte
+	off     int
+	prefix  []byte // 当前 group 的外部 key 前缀
+	total   uint64
+}
+
+// NewBatchDecoder 创建从 r 读取的解码器，只读取到结束 record 为止
+func NewBatchDecoder(r io.Reader) *BatchDecoder {
+	return &BatchDecoder{r: r}
+}
+
+// Next 返回下一次写入的外部 key 与 value，value 为 nil 表示删除；
+// 全部读完后返回 io.EOF。返回的切片在之后的调用中保持有效
+func (d *BatchDecoder) Next() (key, value []byte, err error) {
+	if d.done {
+		return nil, nil, io.EOF
+	}
+	for d.off >= len(d.payload) {
+		if err := d.readRecord(); err != nil {
+			return nil, nil, err
+		}
+		if d.done {
+			return nil, nil, io.EOF
+		}
+	}
+	op := d.payload[d.off]
+	d.off++
+	if op != batchOpPut && op != batchOpDelete {
+		return nil, nil, fmt.Errorf("%w: unknown op %d", errors.ErrBatchCorrupted, op)
+	}
+	realKey, err := d.bytes()
+	if err != nil {
+		return nil, nil, err
+	}
+	key = make([]byte, 0, len(d.prefix)+len(realKey))
+	key = append(append(key, d.prefix...), realKey...)
+	if op == batchOpPut {
+		if value, err = d.bytes(); err != nil {
+			return nil, nil, err
+		}
+	}
+	d.total++
+	return key, value, nil
+}
+
+// Consumed 已从底层 io.Reader 读取的字节数
+func (d *BatchDecoder) Consumed() int64 {
+	return d.n
+}
+
+func (d *BatchDecoder) readRecord() error {
+	if !d.started {
+		var hdr [len(batchMagic) + 1]byte
+		if err := d.readFull(hdr[:]); err != nil {
+			return err
+		}
+		if string(hdr[:len(batchMagic)]) != batchMagic {
+			return fmt.Errorf("%w: bad magic", errors.ErrBatchCorrupted)
+		}
+		if hdr[len(batchMagic)] != batchVersion {
+			return fmt.Errorf("%w: %d", errors.ErrBatchVersion, hdr[len(batchMagic)])
+		}
+		d.started = true
+	}
+	var hdr [batchRecordHeader]byte
+	if err := d.readFull(hdr[:]); err != nil {
+		return err
+	}
+	length := binary.LittleEndian.Uint32(hdr[4:8])
+	if length == 0 || length > maxBatchRecord {
+		return fmt.Errorf("%w: record length %d", errors.ErrBatchCorrupted, length)
+	}
+	// 每个 record 单独分配，已返回的 key、value 可以直接引用
+	d.payload = make([]byte, length)
+	d.off = 0
+	if err := d.readFull(d.payload); err != nil {
+		return err
+	}
+	if util.NewCRC(hdr[4:8]).Update(d.payload).Value() != binary.LittleEndian.Uint32(hdr[0:4]) {
+		return fmt.Errorf("%w: checksum mismatch", errors.ErrBatchCorrupted)
+	}
+	typ := d.payload[0]
+	d.off = 1
+	switch typ {
+	case batchRecordGroup:
+		name, err := d.bytes()
+		if err != nil {
+			return err
+		}
+		switch len(name) {
+		case 0:
+			d.prefix = util.AccountPrefix()
+		case util.SubTreeNameLen:
+			d.prefix = append(util.StoragePrefix(), name...)
+		default:
+			return fmt.Errorf("%w: sub tree name length %d", errors.ErrBatchCorrupted, len(name))
+		}
+	case batchRecordEnd:
+		total, n := binary.Uvarint(d.payload[d.off:])
+		if n <= 0 || d.off+n != len(d.payload) || total != d.total {
+			return fmt.Errorf("%w: decoded %d entries, end record mismatch", errors.ErrBatchCorrupted, d.total)
+		}
+		d.done = true
+	default:
+		return fmt.Errorf("%w: unknown record type %d", errors.ErrBatchCorrupted, typ)
+	}
+	return nil
+}
+
+// bytes 读取 payload 中长度前缀的一段，结果引用 payload
+func (d *BatchDecoder) bytes() ([]byte, error) {
+	l, n := binary.Uvarint(d.payload[d.off:])
+	if n <= 0 || l > uint64(len(d.payload)-d.off-n) {
+		return nil, fmt.Errorf("%w: truncated record", errors.ErrBatchCorrupted)
+	}
+	d.off += n
+	b := d.payload[d.off : d.off+int(l) : d.off+int(l)]
+	d.off += int(l)
+	return b, nil
+}
+
+func (d *BatchDecoder) readFull(b []byte) error {
+	n, err := io.ReadFull(d.r, b)
+	d.n += int64(n)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("%w: unexpected end of data", errors.ErrBatchCorrupted)
+	}
+	return err
+}
+
+// WriteTo 将批次按 key 升序编码写入 w
+func (wb *WriteBatch) WriteTo(w io.Writer) (int64, error) {
+	e := NewBatchEncoder(w)
+	for _, in := range wb.Dump() {
+		if err := e.Put(in.Key(), in.Value()); err != nil {
+			return e.Written(), err
+		}
+	}
+	err := e.Close()
+	return e.Written(), err
+}
+
+// ReadFrom 从 r 解码一个批次并合并进本批次，同一个 key 以解码出的为准。
+// 只读取到批次的结束 record，r 中之后的数据保持不动
+func (wb *WriteBatch) ReadFrom(r io.Reader) (int64, error) {
+	d := NewBatchDecoder(r)
+	for {
+		key, value, err := d.Next()
+		if err == io.EOF {
+			return d.Consumed(), nil
+		}
+		if err != nil {
+			return d.Consumed(), err
+		}
+		if err := wb.list.Put(key, value); err != nil {
+			return d.Consumed(), err
+		}
+	}
+}
+
+// MarshalBinary 实现 encoding.BinaryMarshaler
+func (wb *WriteBatch) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := wb.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary 实现 encoding.BinaryUnmarshaler，原有内容被替换
+func (wb *WriteBatch) UnmarshalBinary(data []byte) error {
+	nb := NewBatch()
+	r := bytes.NewReader(data)
+	if _, err := nb.ReadFrom(r); err != nil {
+		return err
+	}
+	if r.Len() != 0 {
+		return fmt.Errorf("%w: %d trailing bytes", errors.ErrBatchCorrupted, r.Len())
+	}
+	wb.list = nb.list
+	return nil
+}
@@ -0,0 +1,132 @@
+package go_tsmm
+
+import (
+	"bytes"
+	stderrors "errors"
+	"fmt"
+	"testing"
+
+	"github.com/breeze-go-rust/go-tsmm/errors"
+)
+
+// testCodecBatch 账户与多棵子树的写入、删除，以及一个独占 record 的大 value
+func testCodecBatch(t *testing.T) *WriteBatch {
+	t.Helper()
+	wb := NewBatch()
+	put := func(key, value []byte) {
+		if err := wb.Put(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 2000; i++ {
+		value := []byte(fmt.Sprintf("account-%d", i))
+		if i%5 == 0 {
+			value = nil
+		}
+		put(benchAccountKey(uint64(i)), value)
+	}
+	for s := 0; s < 10; s++ {
+		for i := 0; i < 300; i++ {
+			value := []byte(fmt.Sprintf("slot-%d-%d", s, i))
+			if i%7 == 0 {
+				value = nil
+			}
+			put(benchStorageKey(uint64(s), uint64(i)), value)
+		}
+	}
+	put(benchAccountKey(1<<40), bytes.Repeat([]byte{'x'}, batchRecordSize+100))
+	return wb
+}
+
+// applyCodecBatch 在已有数据的新存储上 Apply 批次并提交，返回根哈希
+func applyCodecBatch(t *testing.T, wb *WriteBatch) []byte {
+	t.Helper()
+	tree, err := NewBTree(false, false, true, t.TempDir(), "", 3, 0, "", 0, 0, &Options{PageSize: 4096})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	for i := 0; i < 2000; i += 2 {
+		if err := tree.Put(benchAccountKey(uint64(i)), []byte("base")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for s := 0; s < 10; s += 2 {
+		for i := 0; i < 300; i += 3 {
+			if err := tree.Put(benchStorageKey(uint64(s), uint64(i)), []byte("base")); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tree.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Apply(wb); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	root, err := tree.RootHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+// TestBatchCodecRootHash decode(encode(batch)) 与原批次提交后的根哈希相同
+func TestBatchCodecRootHash(t *testing.T) {
+	wb := testCodecBatch(t)
+	var buf bytes.Buffer
+	n, err := wb.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) {
+		t.Fatalf("WriteTo reported %d bytes, wrote %d", n, buf.Len())
+	}
+	decoded := NewBatch()
+	if m, err := decoded.ReadFrom(&buf); err != nil || m != n {
+		t.Fatalf("ReadFrom = %d, %v; want %d bytes", m, err, n)
+	}
+	want, got := wb.Dump(), decoded.Dump()
+	if len(got) != len(want) {
+		t.Fatalf("decoded %d entries, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if !bytes.Equal(got[i].Key(), want[i].Key()) || !bytes.Equal(got[i].Value(), want[i].Value()) ||
+			(got[i].Value() == nil) != (want[i].Value() == nil) {
+			t.Fatalf("entry %d: decoded %q differs from %q", i, got[i].Key(), want[i].Key())
+		}
+	}
+	if a, b := applyCodecBatch(t, wb), applyCodecBatch(t, decoded); !bytes.Equal(a, b) {
+		t.Fatalf("root hash of decoded batch %x, want %x", b, a)
+	}
+}
+
+// TestBatchCodecCorrupt 任意位置的单字节损坏与截断都被发现
+func TestBatchCodecCorrupt(t *testing.T) {
+	wb := NewBatch()
+	for i := 0; i < 50; i++ {
+		if err := wb.Put(benchAccountKey(uint64(i)), []byte(fmt.Sprintf("v%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := wb.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range data {
+		bad := append([]byte{}, data...)
+		bad[i] ^= 0x40
+		if _, err := NewBatch().ReadFrom(bytes.NewReader(bad)); err == nil {
+			t.Fatalf("corruption at byte %d not detected", i)
+		}
+	}
+	for n := 0; n < len(data); n++ {
+		_, err := NewBatch().ReadFrom(bytes.NewReader(data[:n]))
+		if !stderrors.Is(err, errors.ErrBatchCorrupted) {
+			t.Fatalf("truncation at %d: got %v, want ErrBatchCorrupted", n, err)
+		}
+	}
+}
@@ -2,16 +2,25 @@ package go_tsmm
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"github.com/breeze-go-rust/go-tsmm/cache"
+	"github.com/breeze-go-rust/go-tsmm/errors"
+	"github.com/breeze-go-rust/go-tsmm/file"
+	"github.com/breeze-go-rust/go-tsmm/filter"
 	"github.com/breeze-go-rust/go-tsmm/internal/common"
 	"github.com/breeze-go-rust/go-tsmm/internal/compress"
 	"github.com/breeze-go-rust/go-tsmm/internal/freelist"
 	"github.com/breeze-go-rust/go-tsmm/util"
+	"github.com/breeze-go-rust/go-tsmm/util/hasher"
 	"github.com/breeze-go-rust/go-tsmm/vexodb"
 	"github.com/panjf2000/ants/v2"
+	"io"
 	"path/filepath"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 	"unsafe"
 )
 
@@ -21,156 +30,571 @@ const (
 )
 
 // DefaultFillPercent is the percentage that split pages are filled.
-// This value can be changed by setting Bucket.FillPercent.
+// This value can be changed by setting Options.FillPercent.
 const DefaultFillPercent = 0.5
 
+// DefaultBulkFillPercent BulkLoad 装填叶子页与分支页的默认比例，可由 Options.BulkFillPercent 修改
+const DefaultBulkFillPercent = 0.9
+
 type BTree struct {
-	header         *common.InBTree
-	versionNum     uint32
-	isSubBTree     bool
-	isReadOnly     bool
-	parentBTree    *BTree
-	rootPage       *common.Page // root's page
-	bTrees         map[string]*BTree
-	dirtyBTrees    map[string]*BTree
-	dirtyNodeCache cache.Cache
-	rootNode       *node
-	batch          *SkipList
-	freelist       freelist.Interface
-	ctx            *context
-	metas          []*common.Meta
-	pageMgr        *PageMgr
-	metaMgr        *MetaMgr
-	fillPercent    float64
-	leafNodePool   *ants.MultiPoolWithFunc
-	branchNodePool *ants.MultiPoolWithFunc
-	subBTreePool   *ants.MultiPoolWithFunc
-	pSize          uint32
-	compressor     compress.Compressor
-	compressEnable bool
-
-	dataBufferPool *sync.Pool
+	header               *common.InBTree
+	versionNum           uint32
+	isSubBTree           bool
+	isReadOnly           bool
+	parentBTree          *BTree
+	rootPage             *common.Page // root's page
+	treeLock             sync.RWMutex // 保护 bTrees 与 dirtyBTrees
+	bTrees               map[string]*BTree
+	dirtyBTrees          map[string]*BTree
+	nodeCache            *cache.Cache           // 主树与子树共享的干净节点缓存
+	pageCache            *cache.NamespaceGetter // 本树在 nodeCache 中的命名空间
+	handleLock           sync.Mutex             // 保护 handles
+	handles              []*cache.Handle        // 本次提交期间固定的缓存句柄
+	rootNode             *node
+	rootHash             []byte // 最近一次更新后的根哈希，子树折叠进主树时使用
+	batch                *ArenaSkipList
+	runs                 []spillRun       // 本树批次已写入 spill 文件的部分，按写出顺序
+	ranges               []rangeTombstone // 本树尚未提交的范围删除，按记录顺序
+	spillLock            sync.RWMutex     // Put 持读锁写批次，spill 与提交持写锁替换批次
+	batchBudget          int64            // 批次内存预算，0 表示不限制
+	batchBytes           atomic.Int64     // 所有批次当前占用的内存
+	spillSeq             int
+	spillFiles           []*spillFile
+	spillDir             string
+	fs                   file.FS
+	freelist             freelist.Interface
+	ctx                  *context
+	pageMgr              *PageMgr
+	metaMgr              *MetaMgr
+	dirLock              io.Closer
+	allocLock            sync.Mutex
+	writerLock           sync.Mutex                   // 同一时刻只有一个写者：写事务、Commit 与 BulkLoad
+	metaLock             sync.RWMutex                 // 保护 ctx.meta 的替换，只读事务开始时持读锁取快照
+	txView               bool                         // 只读事务的快照视图，不经过页缓存也不查过滤器
+	hwm                  common.Pgid                  // 本次提交的页高水位
+	dirtyPages           map[common.Pgid]*common.Page // 本次提交新分配、尚未写入页文件的页
+	txPages              map[common.Pgid]struct{}     // 本次提交分配的页
+	txFree               []pageSpan                   // 本次提交分配后又释放的页，优先复用
+	writtenPages         int                          // 提交前已写入页文件的页数
+	freelistSpan         pageSpan                     // 最新已提交的空闲列表页，count 为 0 表示没有
+	pinned               map[common.TxID]struct{}     // 在 freelist 中登记为只读事务的保留版本
+	statsLock            sync.Mutex                   // 保护 stats
+	stats                statsState
+	filter               filter.Filter // 叶子页过滤器，nil 表示不生成
+	filterNegatives      uint64        // 过滤器判定不存在、跳过叶子页的次数
+	filterFalsePositives uint64        // 过滤器判定可能存在、但叶子页中没有的次数
+	fillPercent          float64
+	bulkFillPercent      float64
+	leafNodePool         *ants.MultiPoolWithFunc
+	branchNodePool       *ants.MultiPoolWithFunc
+	subBTreePool         *ants.MultiPoolWithFunc
+	pSize                uint32
+	hashType             hasher.HashType // 默克尔哈希函数，取自 meta
+	compressor           compress.Compressor
+	compressEnable       bool
+	dictMode             bool        // compressType 为 CompressZSTDDict
+	dictPage             common.Pgid // 最新的字典页，提交时写入 meta
+	dicts                [][]byte    // 已加载的字典，由新到旧
+	committedDicts       int         // dicts 中已提交的字典数
+
 	hashBufferPool *sync.Pool
 	vlog           *vexodb.ValueLog
 }
 
 const (
 	BTreePageFileIndex = "index"
+	BTreeValueLogDir   = "vlog"
 )
 
 func NewBTree(isReadOnly bool, isSubBTree bool, noSync bool,
 	baseBTreePath string,
 	compressType string,
 	activateMetaVersion int,
-	seq uint64, name string, pgId common.Pgid, overflow uint32) (*BTree, error) {
+	seq uint64, name string, pgId common.Pgid, overflow uint32, opts *Options) (*BTree, error) {
+	if opts == nil {
+		opts = DefaultOptions
+	}
 
 	pageFilePath := filepath.Join(baseBTreePath, BTreePageFileIndex)
 	metaFilePath := filepath.Join(baseBTreePath, "versions")
+	vlogPath := filepath.Join(baseBTreePath, BTreeValueLogDir)
+	fileOpts := &file.Options{DirectIO: opts.DirectIO, ReadOnly: isReadOnly}
+	fs := opts.FS
+	if fs == nil {
+		fs = file.OS
+	}
 
 	var err error
 	bTree := &BTree{
-		batch:      NewSkipList(),
+		batch:      NewArenaSkipList(),
 		isSubBTree: isSubBTree,
 		isReadOnly: isReadOnly,
 		header:     common.NewInBTree(pgId, overflow, name, seq),
 		versionNum: uint32(activateMetaVersion),
 		compressor: compress.NewCompressor(compressType),
+		// 每页记录自己的编码方式，更换 compressType 后旧页仍按原编码读取
+		compressEnable: compressType != "" && compressType != "direct",
+		dictMode:       compressType == CompressZSTDDict,
 	}
 	if !isSubBTree {
+		// 整个目录只加一把锁：读写模式排他，只读模式共享
+		bTree.dirLock, err = file.LockDir(fs, baseBTreePath, isReadOnly, opts.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("bTree: lock %s failed: %w", baseBTreePath, err)
+		}
 		bTree.bTrees = make(map[string]*BTree)
 		bTree.dirtyBTrees = make(map[string]*BTree)
+		bTree.fs = fs
+		bTree.spillDir = filepath.Join(baseBTreePath, BTreeSpillDir)
+		bTree.batchBudget = resolveBatchMemory(opts.BatchMemory)
+		bTree.fillPercent = resolveFillPercent(opts.FillPercent, DefaultFillPercent)
+		bTree.bulkFillPercent = resolveFillPercent(opts.BulkFillPercent, DefaultBulkFillPercent)
+		bTree.txPages = make(map[common.Pgid]struct{})
+		if !isReadOnly {
+			if err := bTree.removeSpillFiles(); err != nil {
+				_ = bTree.Close()
+				return nil, fmt.Errorf("bTree: %w", err)
+			}
+		}
 		bTree.freelist = freelist.NewHashMapFreelist()
-		bTree.metas = make([]*common.Meta, 40)
-		bTree.leafNodePool, err = ants.NewMultiPoolWithFunc(40, ants.DefaultAntsPoolSize, func(a any) {}, ants.RoundRobin)
-		common.Assert(err != nil, "bTree: create leaf node multi pool failed.")
-		bTree.branchNodePool, err = ants.NewMultiPoolWithFunc(40, ants.DefaultAntsPoolSize, func(a any) {}, ants.RoundRobin)
-		common.Assert(err != nil, "bTree: create branch node multi pool failed.")
-		bTree.subBTreePool, err = ants.NewMultiPoolWithFunc(40, ants.DefaultAntsPoolSize, func(a any) {}, ants.RoundRobin)
-		common.Assert(err != nil, "bTree: create bTree node multi pool failed.")
-		bTree.pageMgr, err = NewPageMgr(pageFilePath, noSync)
-		common.Assert(err != nil, "bTree: create page manager failed.")
-		bTree.metaMgr, err = NewMetaMgr(metaFilePath, activateMetaVersion, noSync)
-		common.Assert(err != nil, "bTree: create meta manager failed.")
+		bTree.filter = opts.Filter
+		bTree.nodeCache, err = newPageCache(opts.CacheSize, opts.CachePolicy)
+		if err != nil {
+			_ = bTree.Close()
+			return nil, fmt.Errorf("bTree: create page cache failed: %w", err)
+		}
+		if bTree.nodeCache != nil {
+			bTree.pageCache = &cache.NamespaceGetter{Cache: bTree.nodeCache}
+		}
+		bTree.hashBufferPool = &sync.Pool{New: func() any { return new(bytes.Buffer) }}
+		bTree.leafNodePool, err = ants.NewMultiPoolWithFunc(40, ants.DefaultAntsPoolSize, func(a any) {
+			a.(*task).leafNodePoolTask()
+		}, ants.RoundRobin)
+		if err != nil {
+			_ = bTree.Close()
+			return nil, fmt.Errorf("bTree: create leaf node multi pool failed: %w", err)
+		}
+		bTree.branchNodePool, err = ants.NewMultiPoolWithFunc(40, ants.DefaultAntsPoolSize, func(a any) {
+			a.(*task).branchNodePoolTask()
+		}, ants.RoundRobin)
+		if err != nil {
+			_ = bTree.Close()
+			return nil, fmt.Errorf("bTree: create branch node multi pool failed: %w", err)
+		}
+		bTree.subBTreePool, err = ants.NewMultiPoolWithFunc(40, ants.DefaultAntsPoolSize, func(a any) {
+			a.(*subTreeTask).subBTreePoolTask()
+		}, ants.RoundRobin)
+		if err != nil {
+			_ = bTree.Close()
+			return nil, fmt.Errorf("bTree: create bTree node multi pool failed: %w", err)
+		}
+		bTree.metaMgr, err = NewMetaMgr(fs, metaFilePath, activateMetaVersion, noSync, fileOpts)
+		if err != nil {
+			_ = bTree.Close()
+			return nil, fmt.Errorf("bTree: create meta manager failed: %w", err)
+		}
+		bTree.pSize, err = resolvePageSize(opts.PageSize, bTree.metaMgr.Latest())
+		if err != nil {
+			_ = bTree.Close()
+			return nil, err
+		}
+		bTree.hashType, err = resolveHashType(opts.HashType, bTree.metaMgr.Latest())
+		if err != nil {
+			_ = bTree.Close()
+			return nil, err
+		}
+		bTree.pageMgr, err = NewPageMgr(fs, pageFilePath, bTree.pSize, noSync, fileOpts)
+		if err != nil {
+			_ = bTree.Close()
+			return nil, fmt.Errorf("bTree: create page manager failed: %w", err)
+		}
+		bTree.vlog, err = vexodb.Open(fs, vlogPath, noSync, fileOpts)
+		if err != nil {
+			_ = bTree.Close()
+			return nil, fmt.Errorf("bTree: open value log failed: %w", err)
+		}
 	}
-	if err := bTree.init(); err != nil {
-
+	if !isSubBTree {
+		if err := bTree.init(); err != nil {
+			_ = bTree.Close()
+			return nil, err
+		}
 	}
 	return bTree, nil
 }
 
+// resolvePageSize 新建时使用 want（为 0 时取操作系统页大小），
+// 已有数据时使用 meta 中记录的页大小，want 与之不一致则拒绝打开
+func resolvePageSize(want int, latest *common.Meta) (uint32, error) {
+	if latest != nil {
+		if !common.ValidPageSize(latest.PageSize()) {
+			return 0, fmt.Errorf("meta page size %d: %w", latest.PageSize(), errors.ErrInvalidPageSize)
+		}
+		if want != 0 && uint32(want) != latest.PageSize() {
+			return 0, fmt.Errorf("want %d, got %d: %w", want, latest.PageSize(), errors.ErrPageSizeMismatch)
+		}
+		return latest.PageSize(), nil
+	}
+	if want == 0 {
+		want = common.DefaultPageSize
+	}
+	if want < 0 || want > common.MaxPageSize || !common.ValidPageSize(uint32(want)) {
+		return 0, fmt.Errorf("page size %d: %w", want, errors.ErrInvalidPageSize)
+	}
+	return uint32(want), nil
+}
+
+// resolveHashType 与 resolvePageSize 相同：新建时使用 want（为 0 时取默认值），
+// 已有数据时使用 meta 中记录的哈希函数，want 与之不一致则拒绝打开
+func resolveHashType(want hasher.HashType, latest *common.Meta) (hasher.HashType, error) {
+	if latest != nil {
+		ht := hasher.HashType(latest.HashType())
+		if !ht.Valid() {
+			return 0, fmt.Errorf("meta hash type %v: %w", ht, errors.ErrInvalidHashType)
+		}
+		if want != 0 && want != ht {
+			return 0, fmt.Errorf("want %v, got %v: %w", want, ht, errors.ErrHashTypeMismatch)
+		}
+		return ht, nil
+	}
+	if want == 0 {
+		want = hasher.DefaultHashType
+	}
+	if !want.Valid() {
+		return 0, fmt.Errorf("hash type %v: %w", want, errors.ErrInvalidHashType)
+	}
+	return want, nil
+}
+
+// resolveFillPercent 将 Options 中的填充比例限制在 minFillPercent 到 maxFillPercent 之间，为 0 时取 def
+func resolveFillPercent(want, def float64) float64 {
+	switch {
+	case want == 0:
+		return def
+	case want < minFillPercent:
+		return minFillPercent
+	case want > maxFillPercent:
+		return maxFillPercent
+	}
+	return want
+}
+
+// init 从 meta 环中恢复最新的版本，环为空时初始化一棵新树
 func (b *BTree) init() error {
-	// 对当前活跃的 40 版本的 调整
+	meta := b.metaMgr.Latest()
+	if meta == nil {
+		// 页 0、1 保留，新树从页 2 开始分配
+		meta = &common.Meta{}
+		meta.SetMagic(common.Magic)
+		meta.SetVersion(common.Version)
+		meta.SetPageSize(b.pSize)
+		meta.SetHashType(uint32(b.hashType))
+		meta.SetFreelist(common.PgidNoFreelist)
+		meta.SetPgid(2)
+		meta.SetRootBucket(*b.header)
+	} else {
+		root := meta.RootBucket()
+		b.header.SetRootPage(root.RootPage())
+		b.header.SetOverflow(root.Overflow())
+		b.header.SetInSequence(root.InSequence())
+	}
+	b.ctx = &context{meta: meta}
+	b.hwm = meta.Pgid()
+	b.dictPage = meta.Dict()
+	if err := b.loadDicts(); err != nil {
+		return err
+	}
+	if err := b.readFreelist(meta); err != nil {
+		return err
+	}
+	b.dirtyPages = make(map[common.Pgid]*common.Page)
+	b.pinned = make(map[common.TxID]struct{})
+	b.syncPins()
+	b.updateStats(nil)
 	return nil
 }
 
+// Close 关闭页文件、meta 文件与 value log，最后释放目录锁
+func (b *BTree) Close() error {
+	if b.isSubBTree {
+		return nil
+	}
+	var err error
+	closeFn := func(f func() error) {
+		if cerr := f(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	b.releaseHandles()
+	if len(b.spillFiles) > 0 {
+		closeFn(b.releaseSpill)
+	}
+	for _, pool := range []*ants.MultiPoolWithFunc{b.leafNodePool, b.branchNodePool, b.subBTreePool} {
+		if pool != nil {
+			_ = pool.ReleaseTimeout(time.Second)
+		}
+	}
+	if b.nodeCache != nil {
+		b.nodeCache.Close(false)
+	}
+	if b.vlog != nil {
+		closeFn(b.vlog.Close)
+	}
+	if b.metaMgr != nil {
+		closeFn(b.metaMgr.Close)
+	}
+	if b.pageMgr != nil {
+		closeFn(b.pageMgr.Close)
+	}
+	if b.dirLock != nil {
+		closeFn(b.dirLock.Close)
+	}
+	return err
+}
+
+// Put 将 key 写入批次，value 为 nil 表示删除，提交后生效。key 与 value 被复制进批次，
+// 调用方可以复用其缓冲区。
+// 多个 goroutine 可以并发调用 Put、Delete 与 Get；与 Commit 并发时等待提交结束，写入下一个批次
 func (b *BTree) Put(key, value []byte) error {
-	// 对 Key 进行解析
-	prefix, name, realKey := util.ParseKey(key)
-	if name == nil { // 不存在 子树
-		if prefix == nil || bytes.Compare(prefix, util.AccountPrefix()) != 0 {
-			return fmt.Errorf("invalid prefix")
+	if b.parent().isReadOnly {
+		return errors.ErrDatabaseReadOnly
+	}
+	name, realKey, err := splitKey(key)
+	if err != nil {
+		return err
+	}
+	tree := b.parent()
+	// 提交持 spillLock 的写锁，加载、登记子树与写入批次都不会与提交交错
+	tree.spillLock.RLock()
+	target := tree
+	if name != nil {
+		if target, err = tree.createIfNotExists(string(name)); err != nil {
+			tree.spillLock.RUnlock()
+			return err
 		}
-		return b.batch.Put(realKey, value)
+		tree.markDirty(target)
 	}
-	tree := b.createIfNotExists(string(name))
-	return tree.batch.Put(realKey, value)
+	delta := target.batch.put(realKey, value)
+	tree.spillLock.RUnlock()
+	return tree.addBatchBytes(delta)
 }
 
 func (b *BTree) Delete(key []byte) error {
 	return b.Put(key, nil)
 }
 
-func (b *BTree) createIfNotExists(name string) *BTree {
-	if tree, ok := b.bTrees[name]; ok {
-		return tree
+// Get 读取最后一个已提交版本中 key 的 value，不存在时返回 nil；
+// 批次中尚未提交的写入不可见
+func (b *BTree) Get(key []byte) ([]byte, error) {
+	tree := b.parent()
+	name, realKey, err := splitKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if name == nil {
+		return tree.get(realKey)
 	}
-	// TODO 从这个主树上去找这个子树
+	sub, err := tree.committedSubTree(string(name))
+	if err != nil || sub == nil {
+		return nil, err
+	}
+	return sub.get(realKey)
+}
 
-	// 也没找到，构建一个新的子树
-	btree := &BTree{
-		header:     &common.InBTree{},
-		rootPage:   &common.Page{},
-		rootNode:   nil,
-		isSubBTree: true,
-		batch:      NewSkipList(),
+// committedSubTree 返回读取已提交版本中子树使用的树，已打开的子树直接复用，
+// 主树中没有该子树时返回 nil
+func (b *BTree) committedSubTree(name string) (*BTree, error) {
+	b.treeLock.RLock()
+	sub, ok := b.bTrees[name]
+	b.treeLock.RUnlock()
+	if ok {
+		return sub, nil
 	}
-	btree.header.SetName(name)
-	btree.parentBTree = b
-	b.bTrees[name] = btree
-	return btree
+	in, err := b.subTreeInode(name)
+	if err != nil || in == nil {
+		return nil, err
+	}
+	return b.newSubTree(name, in), nil
 }
 
-func (b *BTree) Update() error {
-	var wg sync.WaitGroup
-	var errCh chan error
-	if len(b.dirtyBTrees) != 0 {
-		errCh = make(chan error, len(b.dirtyBTrees))
-		for _, tree := range b.dirtyBTrees {
-			wg.Add(1)
-			go func(tree *BTree) {
-				errCh <- tree.update(&wg, tree.batch.Dump())
-			}(tree)
-		}
+// splitKey 将外部 key 解析为子树名与树内 key，账户 key 的子树名为 nil
+func splitKey(key []byte) (name []byte, realKey []byte, err error) {
+	if bytes.HasPrefix(key, util.StoragePrefix()) && len(key) < len(util.StoragePrefix())+util.SubTreeNameLen {
+		return nil, nil, fmt.Errorf("invalid storage key length %d", len(key))
 	}
-	wg.Wait()
-	return b.update(&wg, b.batch.Dump())
+	prefix, name, realKey := util.ParseKey(key)
+	if name == nil && (prefix == nil || !bytes.Equal(prefix, util.AccountPrefix())) {
+		return nil, nil, fmt.Errorf("invalid prefix")
+	}
+	// 树内 key 为空的元素无法写入页，只有前缀（与子树名）的 key 不合法
+	if len(realKey) == 0 {
+		return nil, nil, fmt.Errorf("empty key after prefix")
+	}
+	// 主树中 -storage 开头的 key 留给子树元素，见 subTreeKey
+	if name == nil && bytes.HasPrefix(realKey, util.StoragePrefix()) {
+		return nil, nil, fmt.Errorf("account key %x uses the reserved sub-tree prefix", realKey)
+	}
+	return name, realKey, nil
 }
 
-func (b *BTree) update(wg *sync.WaitGroup, kvs common.Inodes) error {
-	if len(kvs) == 0 {
-		wg.Done()
-		return nil
+// get 自根向下查找 key，主树中子树的元素不对外可见
+func (b *BTree) get(key []byte) ([]byte, error) {
+	in, err := b.lookup(key)
+	if err != nil || in == nil || in.Flags()&common.SubTreeFlag != 0 {
+		return nil, err
 	}
-	if b.header.RootPage() == 0 {
-		b.rootNode = &node{isLeaf: true, bTree: b, pgid: 0, overflow: 0}
-	} else {
-		var err error
-		b.rootNode, err = b.pageNode(b.header.RootPage(), b.header.Overflow())
+	return b.parent().readValue(in.Value())
+}
+
+// lookup 自根向下查找 key，返回叶子元素的副本，不存在时返回 nil。
+// 经过分支页时若子叶子页带有过滤器，先查过滤器，过滤器判定不存在时不读叶子页。
+// 只读事务的视图不查过滤器：过滤器页经过主树的页缓存，提交期间读入的旧页可能在页号复用后残留
+func (b *BTree) lookup(key []byte) (*common.Inode, error) {
+	tree := b.parent()
+	id, overflow := b.header.RootPage(), b.header.Overflow()
+	if id == 0 {
+		return nil, nil
+	}
+	var filtered bool
+	for {
+		n, h, err := b.lookupNode(id, overflow)
 		if err != nil {
-			return err
+			return nil, err
+		}
+		if n.isLeaf {
+			in, found := n.search(key)
+			var ret *common.Inode
+			if found {
+				ret = &common.Inode{}
+				ret.SetFlags(in.Flags())
+				ret.SetKey(key)
+				ret.SetValue(append([]byte(nil), in.Value()...))
+				ret.SetHash(in.Hash())
+			}
+			if h != nil {
+				h.Release()
+			}
+			if !found && filtered {
+				atomic.AddUint64(&tree.filterFalsePositives, 1)
+			}
+			return ret, nil
+		}
+		child := n.childAt(key)
+		id, overflow = child.Pgid(), child.Overflow()
+		filterID := child.Filter()
+		if h != nil {
+			h.Release()
 		}
+		if filterID != 0 && !b.txView {
+			ok, err := b.mayContain(filterID, key)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				return nil, nil
+			}
+			filtered = true
+		}
+	}
+}
+
+// readValue 叶子页中保存的是 value 在 value log 中的 (fid, index)
+func (b *BTree) readValue(ptr []byte) ([]byte, error) {
+	if len(ptr) != ValueSize {
+		return nil, fmt.Errorf("invalid value pointer size %d", len(ptr))
+	}
+	fid := binary.LittleEndian.Uint64(ptr[:8])
+	index := binary.LittleEndian.Uint64(ptr[8:16])
+	return b.parent().vlog.Get(fid, index)
+}
+
+// createIfNotExists 返回名为 name 的子树，未打开过时先从主树中加载子树头，
+// 主树中也没有时新建一棵空子树。加载在锁外进行，并发加载同一子树时以先登记的为准
+func (b *BTree) createIfNotExists(name string) (*BTree, error) {
+	b.treeLock.RLock()
+	tree, ok := b.bTrees[name]
+	b.treeLock.RUnlock()
+	if ok {
+		return tree, nil
+	}
+	in, err := b.subTreeInode(name)
+	if err != nil {
+		return nil, err
+	}
+	b.treeLock.Lock()
+	defer b.treeLock.Unlock()
+	if tree, ok := b.bTrees[name]; ok {
+		return tree, nil
+	}
+	tree = b.newSubTree(name, in)
+	b.bTrees[name] = tree
+	return tree, nil
+}
+
+// markDirty 登记本次提交需要更新的子树
+func (b *BTree) markDirty(tree *BTree) {
+	name := tree.header.Name()
+	b.treeLock.RLock()
+	_, ok := b.dirtyBTrees[name]
+	b.treeLock.RUnlock()
+	if ok {
+		return
+	}
+	b.treeLock.Lock()
+	b.dirtyBTrees[name] = tree
+	b.treeLock.Unlock()
+}
+
+// dirtySubTrees 返回本次提交需要更新的子树
+func (b *BTree) dirtySubTrees() []*BTree {
+	b.treeLock.RLock()
+	defer b.treeLock.RUnlock()
+	trees := make([]*BTree, 0, len(b.dirtyBTrees))
+	for _, tree := range b.dirtyBTrees {
+		trees = append(trees, tree)
+	}
+	return trees
+}
+
+// subTreeInode 在已提交的主树中查找子树对应的元素，不存在时返回 nil
+func (b *BTree) subTreeInode(name string) (*common.Inode, error) {
+	in, err := b.lookup(subTreeKey(name))
+	if err != nil {
+		return nil, fmt.Errorf("load sub tree %x: %w", name, err)
+	}
+	if in == nil || in.Flags()&common.SubTreeFlag == 0 {
+		return nil, nil
+	}
+	if decodeSubTree(name, in.Value()) == nil {
+		return nil, fmt.Errorf("load sub tree %x: invalid header size %d", name, len(in.Value()))
+	}
+	return in, nil
+}
+
+// newSubTree 由主树中的元素构建子树，in 为 nil 时为空子树
+func (b *BTree) newSubTree(name string, in *common.Inode) *BTree {
+	tree := &BTree{
+		header:      &common.InBTree{},
+		rootPage:    &common.Page{},
+		isSubBTree:  true,
+		batch:       NewArenaSkipList(),
+		parentBTree: b,
+		pageCache:   b.namespace(name),
 	}
-	return b.rootNode.update(wg, kvs, 0, len(kvs)-1)
+	tree.resetHeader(name, in)
+	return tree
+}
+
+// resetHeader 将子树头恢复为主树元素 in 记录的版本
+func (b *BTree) resetHeader(name string, in *common.Inode) {
+	if in == nil {
+		b.header = &common.InBTree{}
+		b.header.SetName(name)
+		b.rootHash = nil
+		return
+	}
+	b.header = decodeSubTree(name, in.Value())
+	b.rootHash = in.Hash()
 }
 
 func (b *BTree) parent() *BTree {
@@ -180,23 +604,42 @@ func (b *BTree) parent() *BTree {
 	return b.parentBTree
 }
 
+// newHash 从池中取本树使用的哈希函数，用完后 hasher.Return
+func (b *BTree) newHash() *hasher.Hasher {
+	return hasher.NewHash(b.parent().hashType)
+}
+
+// hashSize 本树默克尔哈希的长度
+func (b *BTree) hashSize() int {
+	return b.parent().hashType.Size()
+}
+
 func (b *BTree) pageSize() uint32 {
-	return b.pSize
+	return b.parent().pSize
 }
 
-func (b *BTree) page(id common.Pgid, overflow uint32) (*common.Page, error) {
-	page, err := b.pageMgr.ReadAt(id, overflow)
+// page 读取页，同时返回页所在的缓冲区；压缩页已解压，缓冲区可能大于 overflow 对应的长度
+func (b *BTree) page(id common.Pgid, overflow uint32) (*common.Page, []byte, error) {
+	buf, err := b.parent().pageMgr.read(id, overflow)
 	if err != nil {
-		return nil, fmt.Errorf("pageMgr.ReadAt(%d, %d): %w", id, overflow, err)
+		return nil, nil, fmt.Errorf("pageMgr.ReadAt(%d, %d): %w", id, overflow, err)
 	}
+	page := (*common.Page)(unsafe.Pointer(&buf[0]))
 	if page.Overflow() != overflow {
-		return page, fmt.Errorf("overflow is not equal to want. want %d, got %d", overflow, page.Overflow())
+		return page, buf, fmt.Errorf("overflow is not equal to want. want %d, got %d", overflow, page.Overflow())
 	}
-	return page, nil
+	return page, buf, nil
 }
 
 func (b *BTree) pageNode(id common.Pgid, overflow uint32) (*node, error) {
-	p, err := b.page(id, overflow)
+	if b.pageCache != nil {
+		return b.cachedPageNode(id, overflow)
+	}
+	return b.readPageNode(id, overflow)
+}
+
+func (b *BTree) readPageNode(id common.Pgid, overflow uint32) (*node, error) {
+	p, _, err := b.page(id, overflow)
 	if err != nil {
 		return nil, err
 	}
@@ -206,22 +649,352 @@ func (b *BTree) pageNode(id common.Pgid, overflow uint32) (*node, error) {
 }
 
 func (b *BTree) allocate(count int) *common.Page {
-	buf := make([]byte, count*common.DefaultPageSize)
+	buf := b.pageMgr.alloc(count * int(b.pageSize()))
 	page := (*common.Page)(unsafe.Pointer(&buf[0]))
 	defer func() {
 		// TODO 缓存当前 page
 	}()
 	page.SetOverflow(uint32(count) - 1)
-	pid := b.freelist.Allocate(common.TxID(b.header.InSequence()), count)
-	if pid != 0 {
-		page.SetId(pid)
-		return page
+	b.allocLock.Lock()
+	defer b.allocLock.Unlock()
+	pid := b.reuseTxPages(count)
+	if pid == 0 {
+		pid = b.freelist.Allocate(b.ctx.meta.Txid()+1, count)
+	}
+	if pid == 0 {
+		// freelist 中没有连续空闲页，从高水位处扩展
+		pid = b.hwm
+		b.hwm += common.Pgid(count)
 	}
+	page.SetId(pid)
+	b.dirtyPages[pid] = page
+	b.txPages[pid] = struct{}{}
 	return page
 }
 
+// pageSpan 连续的 count 个页
+type pageSpan struct {
+	id    common.Pgid
+	count int
+}
+
+// reuseTxPages 从本次提交释放的页中取 count 个连续页，没有时返回 0
+func (b *BTree) reuseTxPages(count int) common.Pgid {
+	for i, span := range b.txFree {
+		if span.count < count {
+			continue
+		}
+		if span.count == count {
+			b.txFree = append(b.txFree[:i], b.txFree[i+1:]...)
+		} else {
+			b.txFree[i] = pageSpan{id: span.id + common.Pgid(count), count: span.count - count}
+		}
+		return span.id
+	}
+	return 0
+}
+
+// freePage 释放本次提交不再引用的页，页在本事务（txid+1）中登记为 pending，
+// 回滚时随之撤销；可与 allocate 并发调用
+func (b *BTree) freePage(p *common.Page) {
+	b.allocLock.Lock()
+	defer b.allocLock.Unlock()
+	if _, ok := b.txPages[p.Id()]; ok {
+		// 分段更新时，前面的段写出的页可能被后面的段改写。
+		// 本事务分配的页不能在本事务中交给 freelist，留待本次提交复用
+		delete(b.txPages, p.Id())
+		delete(b.dirtyPages, p.Id())
+		b.txFree = append(b.txFree, pageSpan{id: p.Id(), count: int(p.Overflow()) + 1})
+		return
+	}
+	b.freelist.Free(b.ctx.meta.Txid()+1, p)
+}
+
+// resetTxPages 提交结束后清空本次提交的页记录。提交成功时未复用的页
+// 已不被引用，在下一个事务中释放
+func (b *BTree) resetTxPages(committed bool) {
+	b.allocLock.Lock()
+	defer b.allocLock.Unlock()
+	if committed {
+		for _, span := range b.txFree {
+			b.freelist.Free(b.ctx.meta.Txid()+1, common.NewPage(span.id, 0, 0, uint32(span.count-1)))
+		}
+	}
+	b.txPages = make(map[common.Pgid]struct{})
+	b.txFree = nil
+	b.writtenPages = 0
+}
+
+// spill 将节点编码后写入新分配的页，并设置 n.pgid、n.overflow。
+// compress 为 true 且压缩后更短时，页体以压缩形式保存，页头记录编码方式，
+// 分配的页数按压缩后的长度计算
+func (b *BTree) spill(n *node, compress bool) *common.Page {
+	tree := b.parent()
+	hdr := int(common.PageHeaderSize)
+	var hp common.Page
+	if n.isLeaf {
+		hp.SetFlags(common.LeafPageFlag)
+	} else {
+		hp.SetFlags(common.BranchPageFlag)
+	}
+	raw := make([]byte, common.UsedSpaceInPage(n.inodes, &hp))
+	rp := (*common.Page)(unsafe.Pointer(&raw[0]))
+	n.write(rp, nil)
+	body := raw[hdr:]
+
+	codec := tree.compressor.Codec()
+	payload := body
+	if !compress || codec == 0 {
+		codec = 0
+	} else if enc := tree.compressor.Encode(nil, body); len(enc) < len(body) {
+		payload = enc
+	} else {
+		codec = 0
+	}
+
+	pageSize := int(tree.pageSize())
+	count := (hdr + len(payload) + pageSize - 1) / pageSize
+	p := tree.allocate(count)
+	p.SetFlags(rp.Flags())
+	p.SetCount(rp.Count())
+	p.SetHash(n.hash)
+	p.SetCodec(uint8(codec))
+	p.SetSize(uint32(len(payload)))
+	copy(common.UnsafeByteSlice(unsafe.Pointer(p), common.PageHeaderSize, 0, len(payload)), payload)
+	n.pgid = p.Id()
+	n.overflow = p.Overflow()
+	return p
+}
+
+// leafPageLimit 叶子页中元素的总大小上限，启用压缩时按压缩前的大小放宽到 4 页
+func (b *BTree) leafPageLimit() int {
+	if b.EnableCompress() {
+		return 4 * int(b.pageSize())
+	}
+	return int(b.pageSize()) - int(common.PageHeaderSize)
+}
+
 func (b *BTree) EnableCompress() bool {
 	return b.compressEnable
 }
 
 // 以下操作，仅 主树 可以操作
+
+// Commit 将批次更新写入页文件，并提交一个新的 meta 版本。
+// 落盘顺序：数据页 -> 页文件 fsync -> value log fsync -> meta 写入并 fsync。
+// meta 是提交点，掉电后重新打开时恢复到最后一个完整写入的 meta，
+// meta 之前的写入丢失或撕裂都不影响已提交的版本
+func (b *BTree) Commit() error {
+	if b.isSubBTree {
+		return b.parent().Commit()
+	}
+	if b.isReadOnly {
+		return errors.ErrDatabaseReadOnly
+	}
+	b.writerLock.Lock()
+	defer b.writerLock.Unlock()
+	return b.commitBatch()
+}
+
+// commitBatch 提交当前批次，调用方持有 writerLock。
+// 持 spillLock 的写锁直到批次替换完成，期间的 Put 与 spill 等待提交结束
+func (b *BTree) commitBatch() error {
+	b.spillLock.Lock()
+	defer b.spillLock.Unlock()
+	var cs CommitStats
+	start := time.Now()
+	if b.batch.Size() > 0 || len(b.runs) > 0 || len(b.ranges) > 0 || len(b.dirtySubTrees()) > 0 {
+		if err := b.Update(); err != nil {
+			b.rollback()
+			return err
+		}
+	}
+	cs.Update = time.Since(start)
+	if err := b.commit(&cs); err != nil {
+		b.rollback()
+		return err
+	}
+	cs.Total = time.Since(start)
+	b.updateStats(&cs)
+	return nil
+}
+
+func (b *BTree) commit(cs *CommitStats) error {
+	start := time.Now()
+	freelist := b.writeFreelist()
+	pgids := make(common.Pgids, 0, len(b.dirtyPages))
+	for id := range b.dirtyPages {
+		pgids = append(pgids, id)
+	}
+	sort.Sort(pgids)
+	for _, id := range pgids {
+		p := b.dirtyPages[id]
+		if err := b.pageMgr.Write(p); err != nil {
+			return err
+		}
+		cs.Pages += int(p.Overflow()) + 1
+	}
+	cs.Pages += b.writtenPages
+	cs.Write = time.Since(start)
+	start = time.Now()
+	if err := b.pageMgr.Sync(); err != nil {
+		return fmt.Errorf("sync page file: %w", err)
+	}
+	if err := b.vlog.Sync(); err != nil {
+		return fmt.Errorf("sync value log: %w", err)
+	}
+	cs.Sync = time.Since(start)
+	start = time.Now()
+
+	meta := &common.Meta{}
+	b.ctx.meta.Copy(meta)
+	meta.IncTxid()
+	meta.SetPgid(b.hwm)
+	meta.SetDict(b.dictPage)
+	meta.SetFreelist(freelist.id)
+	// meta 按字节原样落盘，不能携带 name 的字符串指针
+	root := *b.header
+	root.SetName("")
+	meta.SetRootBucket(root)
+	if err := b.metaMgr.Write(meta); err != nil {
+		return fmt.Errorf("write meta: %w", err)
+	}
+	cs.Meta = time.Since(start)
+	b.vlog.Commit()
+	b.syncPins()
+
+	b.metaLock.Lock()
+	b.ctx.meta = meta
+	b.metaLock.Unlock()
+	b.freelistSpan = freelist
+	b.resetTxPages(true)
+	b.committedDicts = len(b.dicts)
+	b.dirtyPages = make(map[common.Pgid]*common.Page)
+	b.batch = NewArenaSkipList()
+	b.ranges = nil
+	b.treeLock.Lock()
+	for name, tree := range b.dirtyBTrees {
+		tree.batch = NewArenaSkipList()
+		tree.ranges = nil
+		delete(b.dirtyBTrees, name)
+	}
+	b.treeLock.Unlock()
+	b.releaseHandles()
+	// 批次已提交，spill 文件删除失败不影响本次提交
+	_ = b.releaseSpill()
+	return nil
+}
+
+// rollback 丢弃本次提交分配的页，回到最后一个已提交的版本
+func (b *BTree) rollback() {
+	// 失败的提交可能已从 freelist 分配页、释放旧页，按已提交的空闲列表页恢复，
+	// 读不到时只能撤销本事务释放的页，已分配的页要等下次成功提交后才回到 freelist
+	if fl, err := b.parseFreelist(b.ctx.meta); err == nil {
+		if fl == nil {
+			fl = &persistedFreelist{}
+		}
+		b.loadFreelist(fl)
+		b.syncPins()
+	} else {
+		b.allocLock.Lock()
+		b.freelist.Rollback(b.ctx.meta.Txid() + 1)
+		b.allocLock.Unlock()
+	}
+	b.hwm = b.ctx.meta.Pgid()
+	b.dirtyPages = make(map[common.Pgid]*common.Page)
+	b.resetTxPages(false)
+	b.vlog.Rollback()
+	root := b.ctx.meta.RootBucket()
+	b.header.SetRootPage(root.RootPage())
+	b.header.SetOverflow(root.Overflow())
+	b.releaseHandles()
+	// 子树头已在本次更新中改写，按已提交的主树恢复，批次保留以便重试。
+	// 读不到已提交的子树头时只能丢弃该子树，下次访问时重新加载
+	for _, tree := range b.dirtySubTrees() {
+		name := tree.header.Name()
+		in, err := b.subTreeInode(name)
+		if err != nil {
+			b.treeLock.Lock()
+			delete(b.bTrees, name)
+			delete(b.dirtyBTrees, name)
+			b.treeLock.Unlock()
+			continue
+		}
+		tree.resetHeader(name, in)
+	}
+	if b.dictPage != b.ctx.meta.Dict() {
+		// 丢弃本次训练的字典，新字典在链表头部，已提交的字典在尾部
+		b.dictPage = b.ctx.meta.Dict()
+		_ = b.useDicts(b.dicts[len(b.dicts)-b.committedDicts:])
+	}
+}
+
+// syncPins 使 freelist 中登记的只读事务与 meta 环中保留的版本保持一致。
+// 保留的版本仍可能被读取，它引用的页即使已被之后的版本释放也不能复用，
+// 版本被移出环后这些页才会从 pending 转为空闲
+func (b *BTree) syncPins() {
+	b.allocLock.Lock()
+	defer b.allocLock.Unlock()
+	retained := make(map[common.TxID]struct{})
+	for _, m := range b.metaMgr.Versions() {
+		retained[m.Txid()] = struct{}{}
+	}
+	for txid := range b.pinned {
+		if _, ok := retained[txid]; !ok {
+			b.freelist.RemoveReadonlyTXID(txid)
+			delete(b.pinned, txid)
+		}
+	}
+	for txid := range retained {
+		if _, ok := b.pinned[txid]; !ok {
+			b.freelist.AddReadonlyTXID(txid)
+			b.pinned[txid] = struct{}{}
+		}
+	}
+	b.freelist.ReleasePendingPages()
+}
+
+// Versions 返回 meta 环中保留的所有版本，按 txid 升序
+func (b *BTree) Versions() []*common.Meta {
+	return b.parent().metaMgr.Versions()
+}
+
+// PinnedPages 返回每个保留版本固定住的 pending 页：这些页在该版本中仍被引用，
+// 但已被之后的版本释放，要等该版本移出 meta 环后才能复用
+func (b *BTree) PinnedPages() map[common.TxID]common.Pgids {
+	tree := b.parent()
+	pending := tree.freelist.PendingPages()
+	res := make(map[common.TxID]common.Pgids)
+	for _, m := range tree.metaMgr.Versions() {
+		var ids common.Pgids
+		for freedBy, pgids := range pending {
+			if freedBy > m.Txid() {
+				ids = append(ids, pgids...)
+			}
+		}
+		if len(ids) > 0 {
+			sort.Sort(ids)
+			res[m.Txid()] = ids
+		}
+	}
+	return res
+}
+
+// Txid 返回最后一个已提交版本的事务号
+func (b *BTree) Txid() common.TxID {
+	return b.parent().ctx.meta.Txid()
+}
+
+// RootHash 返回最后一个已提交版本的根页哈希，空树返回 nil
+func (b *BTree) RootHash() ([]byte, error) {
+	tree := b.parent()
+	root := tree.ctx.meta.RootBucket()
+	if root.RootPage() == 0 {
+		return nil, nil
+	}
+	p, _, err := tree.page(root.RootPage(), root.Overflow())
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), p.GetHash()...), nil
+}
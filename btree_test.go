@@ -0,0 +1,429 @@
+package go_tsmm
+
+import (
+	"bytes"
+	"encoding/binary"
+	stderrors "errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/breeze-go-rust/go-tsmm/errors"
+	"github.com/breeze-go-rust/go-tsmm/util"
+	"github.com/breeze-go-rust/go-tsmm/util/hasher"
+)
+
+// benchAccountKey -account,<32B>
+func benchAccountKey(n uint64) []byte {
+	key := make([]byte, 0, 8+32)
+	key = append(key, "-account"...)
+	return binary.BigEndian.AppendUint64(append(key, make([]byte, 24)...), n)
+}
+
+// benchStorageKey -storage,<20B>,<32B>
+func benchStorageKey(name, n uint64) []byte {
+	key := make([]byte, 0, 8+20+32)
+	key = append(key, "-storage"...)
+	key = binary.BigEndian.AppendUint64(append(key, make([]byte, 12)...), name)
+	return binary.BigEndian.AppendUint64(append(key, make([]byte, 24)...), n)
+}
+
+// BenchmarkCommit 每次迭代写入一批账户 key 与分布在多棵子树中的 storage key 并提交，只计提交耗时。
+// 用 -cpu 1,2,4,8 比较并行哈希流水线在不同 GOMAXPROCS 下的吞吐
+func BenchmarkCommit(b *testing.B) {
+	const (
+		accounts = 2000
+		subtrees = 20
+		slots    = 100
+	)
+	for _, compress := range []string{"", "snappy"} {
+		name := compress
+		if name == "" {
+			name = "none"
+		}
+		b.Run(name, func(b *testing.B) {
+			tree, err := NewBTree(false, false, true, b.TempDir(), compress, 3, 0, "", 0, 0, nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer tree.Close()
+			// 固定种子，各次运行写入相同的数据
+			rnd := rand.New(rand.NewSource(1))
+			value := make([]byte, 64)
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				b.StopTimer()
+				for i := 0; i < accounts; i++ {
+					rnd.Read(value)
+					if err := tree.Put(benchAccountKey(rnd.Uint64()), value); err != nil {
+						b.Fatal(err)
+					}
+				}
+				for s := 0; s < subtrees; s++ {
+					sub := rnd.Uint64()
+					for i := 0; i < slots; i++ {
+						rnd.Read(value)
+						if err := tree.Put(benchStorageKey(sub, rnd.Uint64()), value); err != nil {
+							b.Fatal(err)
+						}
+					}
+				}
+				b.StartTimer()
+				if err := tree.Commit(); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(b.N*(accounts+subtrees*slots))/b.Elapsed().Seconds(), "keys/s")
+		})
+	}
+}
+
+// TestConcurrentWritersAndCommit 多个 goroutine 向主树与多棵子树并发写入，同时不断提交，
+// 较小的批次内存预算使写入期间反复 spill。用 -race 运行可以检查批次替换与写入之间的竞争
+func TestConcurrentWritersAndCommit(t *testing.T) {
+	tree, err := NewBTree(false, false, true, t.TempDir(), "", 3, 0, "", 0, 0,
+		&Options{PageSize: 4096, BatchMemory: 32 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+
+	const (
+		writers  = 4
+		perWrite = 1500
+		subtrees = 8
+	)
+	// 写入者 w 的第 i 个 key：偶数写入者写账户，奇数写入者写子树，Apply 与 Put 交替使用
+	key := func(w, i int) []byte {
+		if w%2 == 0 {
+			return benchAccountKey(uint64(w)<<32 | uint64(i))
+		}
+		return benchStorageKey(uint64(i%subtrees), uint64(w)<<32|uint64(i))
+	}
+	value := func(w, i int) []byte {
+		return []byte(fmt.Sprintf("value-%d-%d", w, i))
+	}
+	var wg sync.WaitGroup
+	var done atomic.Bool
+	errs := make(chan error, writers+1)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWrite; i++ {
+				var err error
+				if i%10 == 0 {
+					wb := NewBatch()
+					_ = wb.Put(key(w, i), value(w, i))
+					err = tree.Apply(wb)
+				} else {
+					err = tree.Put(key(w, i), value(w, i))
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(w)
+	}
+	commits := make(chan struct{})
+	go func() {
+		defer close(commits)
+		for !done.Load() {
+			if err := tree.Commit(); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+	wg.Wait()
+	done.Store(true)
+	<-commits
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if err := tree.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	for w := 0; w < writers; w++ {
+		for i := 0; i < perWrite; i++ {
+			got, err := tree.Get(key(w, i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, value(w, i)) {
+				t.Fatalf("writer %d key %d = %q, want %q", w, i, got, value(w, i))
+			}
+		}
+	}
+}
+
+func TestPutRejectsEmptyKey(t *testing.T) {
+	tree, err := NewBTree(false, false, true, t.TempDir(), "", 3, 0, "", 0, 0, &Options{PageSize: 4096})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	name := bytes.Repeat([]byte{1}, util.SubTreeNameLen)
+	for _, key := range [][]byte{
+		util.AccountPrefix(),
+		append(util.StoragePrefix(), name...),
+	} {
+		if err := tree.Put(key, []byte("v")); err == nil {
+			t.Fatalf("Put(%q) accepted an empty key", key)
+		}
+		wb := NewBatch()
+		if err := wb.Put(key, []byte("v")); err == nil {
+			t.Fatalf("WriteBatch.Put(%q) accepted an empty key", key)
+		}
+	}
+}
+
+// TestAccountKeyDoesNotOverwriteSubTree 与子树同名的账户 key 不覆盖主树中的子树元素，
+// 占用子树元素前缀的账户 key 被拒绝
+func TestAccountKeyDoesNotOverwriteSubTree(t *testing.T) {
+	dir := t.TempDir()
+	tree, err := NewBTree(false, false, true, dir, "", 3, 0, "", 0, 0, &Options{PageSize: 4096})
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := bytes.Repeat([]byte{7}, util.SubTreeNameLen)
+	slot := append(append(util.StoragePrefix(), name...), "slot"...)
+	account := append(util.AccountPrefix(), name...)
+	if err := tree.Put(slot, []byte("storage")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Put(account, []byte("account")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	reserved := append(append(util.AccountPrefix(), util.StoragePrefix()...), name...)
+	if err := tree.Put(reserved, []byte("v")); err == nil {
+		t.Fatalf("Put(%q) accepted a key under the reserved sub-tree prefix", reserved)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	tree, err = NewBTree(false, false, true, dir, "", 3, 0, "", 0, 0, &Options{PageSize: 4096})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	for key, want := range map[string]string{string(slot): "storage", string(account): "account"} {
+		got, err := tree.Get([]byte(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Fatalf("Get(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+// TestCommitFailsOnWorkerPanic 工作池任务中的 panic 使提交返回错误，已提交的版本不变
+func TestCommitFailsOnWorkerPanic(t *testing.T) {
+	tree, err := NewBTree(false, false, true, t.TempDir(), "", 3, 0, "", 0, 0, &Options{PageSize: 4096})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	// 主树根为分支页，空 key 的叶子放回父节点时 panic
+	for i := uint64(1); i <= 2000; i++ {
+		if err := tree.Put(benchAccountKey(i), []byte(fmt.Sprintf("v%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if treeDepth(t, tree) < 2 {
+		t.Fatal("root is still a leaf")
+	}
+	txid := tree.Txid()
+	// 提交放到后台，任务遗留的锁使提交卡住时及时失败
+	commit := func() error {
+		done := make(chan error, 1)
+		go func() { done <- tree.Commit() }()
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(30 * time.Second):
+			t.Fatal("commit did not finish")
+			return nil
+		}
+	}
+	// 绕过 splitKey 写入空 key，写最左的叶子页时 panic；每个叶子都有改动，
+	// 其他叶子在 panic 之后仍要放回同一个父节点
+	tree.batch.put(nil, []byte("bad"))
+	for i := uint64(2); i <= 2000; i += 10 {
+		if err := tree.Put(benchAccountKey(i), []byte("updated")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := commit(); err == nil {
+		t.Fatal("commit with an empty key succeeded")
+	}
+	if got := tree.Txid(); got != txid {
+		t.Fatalf("txid after failed commit = %d, want %d", got, txid)
+	}
+	if got, err := tree.Get(benchAccountKey(1)); err != nil || string(got) != "v1" {
+		t.Fatalf("committed key after failed commit = %q, %v", got, err)
+	}
+	// 失败的任务不能留下持有的锁，去掉坏元素后重试提交成功
+	tree.batch.del(nil)
+	if err := commit(); err != nil {
+		t.Fatalf("commit after dropping the empty key: %v", err)
+	}
+	if got := tree.Txid(); got != txid+1 {
+		t.Fatalf("txid after retried commit = %d, want %d", got, txid+1)
+	}
+	if got, err := tree.Get(benchAccountKey(2)); err != nil || string(got) != "updated" {
+		t.Fatalf("key written before the failed commit = %q, %v", got, err)
+	}
+}
+
+// TestHashTypePersisted 哈希函数记录在 meta 中：重新打开时沿用，指定其他哈希函数时拒绝打开；
+// 20 字节的 SHA-1 与 32 字节的哈希都能构建多层的主树与子树
+func TestHashTypePersisted(t *testing.T) {
+	roots := make(map[string]hasher.HashType)
+	for _, ht := range []hasher.HashType{hasher.SHA1, hasher.SHA256, hasher.Keccak256, hasher.BLAKE3} {
+		dir := t.TempDir()
+		open := func(want hasher.HashType) (*BTree, error) {
+			return NewBTree(false, false, true, dir, "", 3, 0, "", 0, 0, &Options{PageSize: 4096, HashType: want})
+		}
+		tree, err := open(ht)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := uint64(0); i < 3000; i++ {
+			if err := tree.Put(benchAccountKey(i), []byte(fmt.Sprintf("account-%d", i))); err != nil {
+				t.Fatal(err)
+			}
+			if err := tree.Put(benchStorageKey(1, i), []byte(fmt.Sprintf("slot-%d", i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tree.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if treeDepth(t, tree) < 2 {
+			t.Fatalf("%v: main tree has a single level", ht)
+		}
+		root, err := tree.RootHash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(root) != ht.Size() {
+			t.Fatalf("%v: root hash size %d, want %d", ht, len(root), ht.Size())
+		}
+		if prev, ok := roots[string(root)]; ok {
+			t.Fatalf("%v and %v produce the same root hash", ht, prev)
+		}
+		roots[string(root)] = ht
+		if err := tree.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		other := hasher.SHA256
+		if ht == other {
+			other = hasher.BLAKE3
+		}
+		if _, err := open(other); !stderrors.Is(err, errors.ErrHashTypeMismatch) {
+			t.Fatalf("%v: reopen with %v returned %v, want ErrHashTypeMismatch", ht, other, err)
+		}
+		tree, err = open(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tree.hashType != ht || hasher.HashType(tree.ctx.meta.HashType()) != ht {
+			t.Fatalf("%v: hash type after reopen = %v, meta %#x", ht, tree.hashType, tree.ctx.meta.HashType())
+		}
+		if got, err := tree.RootHash(); err != nil || !bytes.Equal(got, root) {
+			t.Fatalf("%v: root hash after reopen %x, %v, want %x", ht, got, err, root)
+		}
+		for i := uint64(0); i < 3000; i += 97 {
+			if got, err := tree.Get(benchStorageKey(1, i)); err != nil || string(got) != fmt.Sprintf("slot-%d", i) {
+				t.Fatalf("%v: slot %d after reopen = %q, %v", ht, i, got, err)
+			}
+		}
+		// 重新打开后的更新沿用记录的哈希函数
+		if err := tree.Put(benchAccountKey(5), []byte("updated")); err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := tree.RootHash(); err != nil || len(got) != ht.Size() || bytes.Equal(got, root) {
+			t.Fatalf("%v: root hash after update %x, %v", ht, got, err)
+		}
+		if err := tree.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := NewBTree(false, false, true, t.TempDir(), "", 3, 0, "", 0, 0, &Options{HashType: 0x50}); !stderrors.Is(err, errors.ErrInvalidHashType) {
+		t.Fatalf("open with an unknown hash type returned %v, want ErrInvalidHashType", err)
+	}
+}
+
+// TestPageSizePersisted 页大小记录在 meta 中：重新打开时沿用，Options.PageSize 与之不同时拒绝打开
+func TestPageSizePersisted(t *testing.T) {
+	dir := t.TempDir()
+	open := func(pageSize int) (*BTree, error) {
+		return NewBTree(false, false, true, dir, "", 3, 0, "", 0, 0, &Options{PageSize: pageSize})
+	}
+	tree, err := open(8192)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := uint64(0); i < 3000; i++ {
+		if err := tree.Put(benchAccountKey(i), []byte(fmt.Sprintf("v%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if got := tree.ctx.meta.PageSize(); got != 8192 {
+		t.Fatalf("meta page size = %d, want 8192", got)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := open(4096); !stderrors.Is(err, errors.ErrPageSizeMismatch) {
+		t.Fatalf("reopen with page size 4096 returned %v, want ErrPageSizeMismatch", err)
+	}
+	for _, pageSize := range []int{0, 8192} {
+		tree, err := open(pageSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := tree.pageSize(); got != 8192 {
+			t.Fatalf("reopen with page size %d: page size %d, want 8192", pageSize, got)
+		}
+		for i := uint64(0); i < 3000; i += 101 {
+			if got, err := tree.Get(benchAccountKey(i)); err != nil || string(got) != fmt.Sprintf("v%d", i) {
+				t.Fatalf("key %d after reopen = %q, %v", i, got, err)
+			}
+		}
+		if err := tree.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, pageSize := range []int{-4096, 3000, 1 << 30} {
+		if _, err := NewBTree(false, false, true, t.TempDir(), "", 3, 0, "", 0, 0, &Options{PageSize: pageSize}); !stderrors.Is(err, errors.ErrInvalidPageSize) {
+			t.Fatalf("open with page size %d returned %v, want ErrInvalidPageSize", pageSize, err)
+		}
+	}
+}
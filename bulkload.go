@@ -0,0 +1,311 @@
+package go_tsmm
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/breeze-go-rust/go-tsmm/errors"
+	"github.com/breeze-go-rust/go-tsmm/internal/common"
+	"github.com/breeze-go-rust/go-tsmm/util"
+	"github.com/breeze-go-rust/go-tsmm/util/hasher"
+)
+
+// bulkFlushPages BulkLoad 每写出这么多页就落到页文件，内存中不保留整棵树
+const bulkFlushPages = 4096
+
+// BulkLoad 将按外部 key 严格升序的键值流导入空的存储，并提交第一个版本。
+// 各树自底向上构建：叶子页按 BulkFillPercent 装满后写出，写出的页依次加入上一层的分支页，
+// 页哈希在写出时计算，不经过批次与逐层合并。value 为 nil 的元素被忽略。
+// 账户 key 先于 storage key，其叶子元素暂存在 spill 目录下，子树全部构建完成后与子树元素
+// 归并成主树。存储已有提交的版本或未提交的写入时返回 errors.ErrStoreNotEmpty
+func (b *BTree) BulkLoad(iter KVIterator) error {
+	tree := b.parent()
+	if tree.isReadOnly {
+		return errors.ErrDatabaseReadOnly
+	}
+	tree.writerLock.Lock()
+	defer tree.writerLock.Unlock()
+	tree.spillLock.Lock()
+	defer tree.spillLock.Unlock()
+	if tree.metaMgr.Latest() != nil || tree.batch.Size() > 0 || len(tree.runs) > 0 ||
+		len(tree.ranges) > 0 || len(tree.dirtySubTrees()) > 0 {
+		return errors.ErrStoreNotEmpty
+	}
+	var cs CommitStats
+	start := time.Now()
+	if err := tree.bulkLoad(iter); err != nil {
+		tree.rollback()
+		_ = tree.releaseSpill()
+		return err
+	}
+	cs.Update = time.Since(start)
+	if err := tree.commit(&cs); err != nil {
+		tree.rollback()
+		return err
+	}
+	cs.Total = time.Since(start)
+	tree.updateStats(&cs)
+	return nil
+}
+
+func (b *BTree) bulkLoad(iter KVIterator) error {
+	sf, err := b.newSpillFile()
+	if err != nil {
+		return err
+	}
+	b.spillFiles = append(b.spillFiles, sf)
+	w := &spillWriter{h: sf.h}
+	bw := bufio.NewWriterSize(w, spillBufferSize)
+	accounts := NewBatchEncoder(bw)
+
+	h := b.newHash()
+	defer hasher.Return(h)
+	var (
+		prev    []byte
+		sub     *bulkBuilder
+		subName []byte
+		subs    common.Inodes // 主树中的子树元素，按子树名升序
+	)
+	finishSub := func() error {
+		if sub == nil {
+			return nil
+		}
+		if err := sub.finish(); err != nil {
+			return err
+		}
+		if in, err := sub.tree.subTreeElement(h); err != nil {
+			return err
+		} else if in != nil {
+			subs = append(subs, in)
+		}
+		sub = nil
+		return nil
+	}
+	for iter.Next() {
+		key, value := iter.Key(), iter.Value()
+		if prev != nil && bytes.Compare(key, prev) <= 0 {
+			return fmt.Errorf("bulk load: key %x is not greater than the previous key", key)
+		}
+		prev = append(prev[:0], key...)
+		if value == nil {
+			continue
+		}
+		name, realKey, err := splitKey(key)
+		if err != nil {
+			return err
+		}
+		kv := &common.Inode{}
+		kv.SetKey(append([]byte(nil), realKey...))
+		kv.SetValue(value)
+		if name == nil {
+			in, err := genLeafInode(h, b.vlog.Update, kv, math.MaxUint64, math.MaxUint64, b.header.InSequence())
+			if err != nil {
+				return err
+			}
+			// 叶子元素暂存为 value log 指针与哈希
+			if err := accounts.Put(key, append(in.Value(), in.Hash()...)); err != nil {
+				return err
+			}
+			continue
+		}
+		if sub == nil || !bytes.Equal(name, subName) {
+			if err := finishSub(); err != nil {
+				return err
+			}
+			subName = append(subName[:0], name...)
+			sub = newBulkBuilder(b.newSubTree(string(name), nil))
+		}
+		if err := sub.addKV(h, kv); err != nil {
+			return err
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	if err := finishSub(); err != nil {
+		return err
+	}
+	if err := accounts.Close(); err != nil {
+		return fmt.Errorf("write spill file %s: %w", sf.name, err)
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("write spill file %s: %w", sf.name, err)
+	}
+	return b.bulkLoadMain(io.NewSectionReader(sf.h, 0, w.off), subs)
+}
+
+// bulkLoadMain 将暂存的账户叶子元素与子树元素归并成主树
+func (b *BTree) bulkLoadMain(r io.Reader, subs common.Inodes) error {
+	dec := NewBatchDecoder(bufio.NewReaderSize(r, spillBufferSize))
+	prefix := len(util.AccountPrefix())
+	main := newBulkBuilder(b)
+	var account *common.Inode
+	next := func() error {
+		key, value, err := dec.Next()
+		if err == io.EOF {
+			account = nil
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(value) != ValueSize+b.hashSize() {
+			return fmt.Errorf("%w: bulk load account entry", errors.ErrBatchCorrupted)
+		}
+		account = &common.Inode{}
+		account.SetKey(key[prefix:])
+		account.SetValue(value[:ValueSize])
+		account.SetHash(value[ValueSize:])
+		return nil
+	}
+	if err := next(); err != nil {
+		return err
+	}
+	for account != nil || len(subs) > 0 {
+		if account == nil || (len(subs) > 0 && bytes.Compare(subs[0].Key(), account.Key()) < 0) {
+			if err := main.add(0, subs[0]); err != nil {
+				return err
+			}
+			subs = subs[1:]
+			continue
+		}
+		if err := main.add(0, account); err != nil {
+			return err
+		}
+		if err := next(); err != nil {
+			return err
+		}
+	}
+	return main.finish()
+}
+
+// subTreeElement 子树在主树中的叶子元素，子树为空时返回 nil
+func (b *BTree) subTreeElement(h *hasher.Hasher) (*common.Inode, error) {
+	if b.header.RootPage() == 0 {
+		return nil, nil
+	}
+	kv := &common.Inode{}
+	kv.SetKey(subTreeKey(b.header.Name()))
+	kv.SetFlags(common.SubTreeFlag)
+	kv.SetValue(encodeSubTree(b.header))
+	kv.SetHash(b.rootHash)
+	return genLeafInode(h, nil, kv, math.MaxUint64, math.MaxUint64, 0)
+}
+
+// bulkBuilder 自底向上构建一棵树：每层只在内存中保留正在装填的一个节点，
+// 节点装满后写出，写出的页作为元素加入上一层
+type bulkBuilder struct {
+	tree    *BTree
+	levels  []*node
+	sizes   []int
+	flushed []int // 各层已写出的节点数
+	pages   int   // 上次落盘后写出的页数
+}
+
+func newBulkBuilder(tree *BTree) *bulkBuilder {
+	bb := &bulkBuilder{tree: tree}
+	bb.grow()
+	return bb
+}
+
+func (bb *bulkBuilder) grow() {
+	bb.levels = append(bb.levels, &node{bTree: bb.tree, isLeaf: len(bb.levels) == 0})
+	bb.sizes = append(bb.sizes, int(common.PageHeaderSize))
+	bb.flushed = append(bb.flushed, 0)
+}
+
+// addKV 将 value 写入 value log 后把叶子元素加入最底层
+func (bb *bulkBuilder) addKV(h *hasher.Hasher, kv *common.Inode) error {
+	in, err := genLeafInode(h, bb.tree.parent().vlog.Update, kv, math.MaxUint64, math.MaxUint64, bb.tree.header.InSequence())
+	if err != nil {
+		return err
+	}
+	return bb.add(0, in)
+}
+
+// add 将元素加入第 level 层，节点超过装填比例时先写出
+func (bb *bulkBuilder) add(level int, in *common.Inode) error {
+	tree := bb.tree.parent()
+	n := bb.levels[level]
+	elemSize := int(common.BranchPageElementSize)
+	limit := int(tree.pageSize())
+	if n.isLeaf {
+		elemSize = int(common.LeafPageElementSize)
+		limit = tree.leafPageLimit()
+	}
+	elemSize += len(in.Key()) + len(in.Value()) + len(in.Hash())
+	if len(n.inodes) >= common.MinKeysPerPage && bb.sizes[level]+elemSize > int(float64(limit)*tree.bulkFillPercent) {
+		if err := bb.flush(level); err != nil {
+			return err
+		}
+		n = bb.levels[level]
+	}
+	n.inodes = append(n.inodes, in)
+	bb.sizes[level] += elemSize
+	return nil
+}
+
+// flush 写出第 level 层正在装填的节点，并把它加入上一层
+func (bb *bulkBuilder) flush(level int) error {
+	n := bb.write(bb.levels[level])
+	var filter common.Pgid
+	if n.isLeaf {
+		filter = bb.tree.writeFilterPage(n.inodes)
+	}
+	bb.levels[level] = &node{bTree: bb.tree, isLeaf: n.isLeaf}
+	bb.sizes[level] = int(common.PageHeaderSize)
+	bb.flushed[level]++
+	if level+1 == len(bb.levels) {
+		bb.grow()
+	}
+	in := &common.Inode{}
+	in.SetKey(n.key)
+	in.SetPgid(n.pgid)
+	in.SetOverflow(n.overflow)
+	in.SetFilter(filter)
+	in.SetHash(n.hash)
+	if err := bb.add(level+1, in); err != nil {
+		return err
+	}
+	if bb.pages += int(n.overflow) + 1; bb.pages >= bulkFlushPages {
+		bb.pages = 0
+		return bb.tree.parent().writeDirtyPages()
+	}
+	return nil
+}
+
+// write 计算节点哈希并写出
+func (bb *bulkBuilder) write(n *node) *node {
+	n.key = n.inodes[0].Key()
+	n.hashChildren()
+	bb.tree.spill(n, n.isLeaf && bb.tree.parent().EnableCompress())
+	return n
+}
+
+// finish 自下而上写出各层剩余的节点。最高一层从未写出过节点时，
+// 它正在装填的节点就是根；根页没有父节点，不写过滤器页
+func (bb *bulkBuilder) finish() error {
+	for level := 0; level < len(bb.levels); level++ {
+		n := bb.levels[level]
+		if level == len(bb.levels)-1 && bb.flushed[level] == 0 {
+			if len(n.inodes) == 0 {
+				return nil
+			}
+			bb.write(n)
+			bb.tree.header.SetRootPage(n.pgid)
+			bb.tree.header.SetOverflow(n.overflow)
+			bb.tree.rootHash = n.hash
+			return nil
+		}
+		if len(n.inodes) > 0 {
+			if err := bb.flush(level); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
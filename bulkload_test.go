@@ -0,0 +1,191 @@
+package go_tsmm
+
+import (
+	"bytes"
+	stderrors "errors"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/breeze-go-rust/go-tsmm/errors"
+)
+
+// sliceIter 按给定顺序产生键值的 KVIterator
+type sliceIter struct {
+	kvs [][2][]byte
+	pos int
+}
+
+func (it *sliceIter) Next() bool {
+	it.pos++
+	return it.pos <= len(it.kvs)
+}
+
+func (it *sliceIter) Key() []byte   { return it.kvs[it.pos-1][0] }
+func (it *sliceIter) Value() []byte { return it.kvs[it.pos-1][1] }
+func (it *sliceIter) Error() error  { return nil }
+
+func openBulkTree(t *testing.T, dir string) *BTree {
+	t.Helper()
+	b, err := NewBTree(false, false, true, dir, "", 3, 0, "", 0, 0, &Options{PageSize: 4096})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// bulkData 账户 key 与三棵子树的 storage key，按外部 key 升序
+func bulkData(accounts, slots int) [][2][]byte {
+	var kvs [][2][]byte
+	for i := 0; i < accounts; i++ {
+		kvs = append(kvs, [2][]byte{benchAccountKey(uint64(i)), []byte(fmt.Sprintf("account-%d", i))})
+	}
+	for name := uint64(1); name <= 3; name++ {
+		for i := 0; i < slots; i++ {
+			kvs = append(kvs, [2][]byte{benchStorageKey(name, uint64(i)), []byte(fmt.Sprintf("slot-%d-%d", name, i))})
+		}
+	}
+	sort.Slice(kvs, func(i, j int) bool { return bytes.Compare(kvs[i][0], kvs[j][0]) < 0 })
+	return kvs
+}
+
+// checkBulkData 每个 key 都能读到，账户游标按序返回全部账户
+func checkBulkData(t *testing.T, b *BTree, kvs [][2][]byte, accounts int) {
+	t.Helper()
+	for _, kv := range kvs {
+		got, err := b.Get(kv[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, kv[1]) {
+			t.Fatalf("Get(%x) = %q, want %q", kv[0], got, kv[1])
+		}
+	}
+	tx, err := b.Begin(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	c := tx.Cursor()
+	n := 0
+	for ok := c.First(); ok; ok = c.Next() {
+		if want := benchAccountKey(uint64(n))[len("-account"):]; !bytes.Equal(c.Key(), want) {
+			t.Fatalf("cursor key %d = %x, want %x", n, c.Key(), want)
+		}
+		n++
+	}
+	if n != accounts {
+		t.Fatalf("cursor returned %d accounts, want %d", n, accounts)
+	}
+}
+
+// TestBulkLoadMatchesCommit 导入的数据与逐个写入后提交的数据根哈希相同。
+// 页哈希覆盖页内的元素，两者的页划分相同时根哈希才相同，这里每棵树都只有一个叶子页
+func TestBulkLoadMatchesCommit(t *testing.T) {
+	kvs := bulkData(20, 10)
+	put := openBulkTree(t, t.TempDir())
+	defer put.Close()
+	for _, kv := range kvs {
+		if err := put.Put(kv[0], kv[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := put.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if treeDepth(t, put) != 1 {
+		t.Fatal("main tree does not fit in one leaf")
+	}
+	want, err := put.RootHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := openBulkTree(t, t.TempDir())
+	defer b.Close()
+	if err := b.BulkLoad(&sliceIter{kvs: kvs}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := b.RootHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("bulk load root hash %x, want %x", got, want)
+	}
+	checkBulkData(t, b, kvs, 20)
+}
+
+// TestBulkLoadSurvivesReopen 多层的主树与子树导入后重新打开，Get 与游标结果不变
+func TestBulkLoadSurvivesReopen(t *testing.T) {
+	kvs := bulkData(3000, 2000)
+	dir := t.TempDir()
+	b := openBulkTree(t, dir)
+	if err := b.BulkLoad(&sliceIter{kvs: kvs}); err != nil {
+		t.Fatal(err)
+	}
+	if treeDepth(t, b) < 2 {
+		t.Fatal("main tree has a single level")
+	}
+	want, err := b.RootHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBulkData(t, b, kvs, 3000)
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b = openBulkTree(t, dir)
+	defer b.Close()
+	checkBulkData(t, b, kvs, 3000)
+	if got, err := b.RootHash(); err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("root hash after reopen %x, %v, want %x", got, err, want)
+	}
+}
+
+// TestBulkLoadRejectsNonEmptyStore 已有提交的版本或未提交的写入时拒绝导入
+func TestBulkLoadRejectsNonEmptyStore(t *testing.T) {
+	kvs := bulkData(10, 10)
+
+	b := openBulkTree(t, t.TempDir())
+	defer b.Close()
+	if err := b.Put(benchAccountKey(1), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.BulkLoad(&sliceIter{kvs: kvs}); !stderrors.Is(err, errors.ErrStoreNotEmpty) {
+		t.Fatalf("bulk load with pending writes returned %v, want ErrStoreNotEmpty", err)
+	}
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.BulkLoad(&sliceIter{kvs: kvs}); !stderrors.Is(err, errors.ErrStoreNotEmpty) {
+		t.Fatalf("bulk load into a committed store returned %v, want ErrStoreNotEmpty", err)
+	}
+	if got, err := b.Get(benchAccountKey(1)); err != nil || string(got) != "v" {
+		t.Fatalf("committed key after rejected bulk load = %q, %v", got, err)
+	}
+}
+
+// TestBulkLoadRejectsUnsortedInput 乱序或重复的 key 使导入失败，存储保持为空，之后仍可导入
+func TestBulkLoadRejectsUnsortedInput(t *testing.T) {
+	kvs := bulkData(100, 100)
+	unsorted := append([][2][]byte(nil), kvs...)
+	unsorted[50], unsorted[150] = unsorted[150], unsorted[50]
+	duplicate := append(append([][2][]byte(nil), kvs[:120]...), kvs[119:]...)
+
+	b := openBulkTree(t, t.TempDir())
+	defer b.Close()
+	for name, input := range map[string][][2][]byte{"unsorted": unsorted, "duplicate": duplicate} {
+		if err := b.BulkLoad(&sliceIter{kvs: input}); err == nil {
+			t.Fatalf("bulk load of %s keys succeeded", name)
+		}
+		if b.Txid() != 0 || b.ctx.meta.Pgid() != b.hwm {
+			t.Fatalf("%s: store changed after a failed bulk load: txid %d", name, b.Txid())
+		}
+	}
+	if err := b.BulkLoad(&sliceIter{kvs: kvs}); err != nil {
+		t.Fatal(err)
+	}
+	checkBulkData(t, b, kvs, 100)
+}
@@ -0,0 +1,258 @@
+package cache
+
+import (
+	"sync"
+	"unsafe"
+)
+
+// arcNode is either a resident entry in t1/t2, holding a handle on the
+// 'cache node', or a ghost entry in b1/b2 that only remembers the key.
+type arcNode struct {
+	n       *Node
+	h       *Handle
+	ns, key uint64
+	size    int
+	list    *arcList
+	ban     bool
+
+	next, prev *arcNode
+}
+
+type arcList struct {
+	root arcNode
+	used int
+}
+
+func (l *arcList) init() {
+	l.root.next = &l.root
+	l.root.prev = &l.root
+	l.used = 0
+}
+
+// pushFront inserts n as the most recently used entry.
+func (l *arcList) pushFront(n *arcNode) {
+	x := l.root.next
+	l.root.next = n
+	n.prev = &l.root
+	n.next = x
+	x.prev = n
+	n.list = l
+	l.used += n.size
+}
+
+// back returns the least recently used entry, or nil if l is empty.
+func (l *arcList) back() *arcNode {
+	if l.root.prev == &l.root {
+		return nil
+	}
+	return l.root.prev
+}
+
+func (l *arcList) remove(n *arcNode) {
+	if n.list != l || n.prev == nil {
+		panic("BUG: removing removed node")
+	}
+	n.prev.next = n.next
+	n.next.prev = n.prev
+	n.prev = nil
+	n.next = nil
+	n.list = nil
+	l.used -= n.size
+}
+
+type arcKey struct {
+	ns, key uint64
+}
+
+// arc implements the Adaptive Replacement Cache of Megiddo and Modha,
+// "ARC: A Self-Tuning, Low Overhead Replacement Cache", FAST 2003, with
+// sizes measured in bytes instead of entries.
+//
+// Entries seen once live in t1 and entries seen at least twice in t2;
+// b1 and b2 remember keys recently evicted from t1 and t2. A one-time
+// scan only churns t1, so the frequently used entries in t2 survive it.
+// Values with PriorityHigh are admitted straight into t2.
+type arc struct {
+	mu       sync.Mutex
+	capacity int
+	p        int // target size of t1
+	t1, t2   arcList
+	b1, b2   arcList
+	ghosts   map[arcKey]*arcNode
+}
+
+func (r *arc) reset() {
+	r.t1.init()
+	r.t2.init()
+	r.b1.init()
+	r.b2.init()
+	r.p = 0
+	r.ghosts = make(map[arcKey]*arcNode)
+}
+
+func (r *arc) Capacity() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.capacity
+}
+
+func (r *arc) SetCapacity(capacity int) {
+	r.mu.Lock()
+	r.capacity = capacity
+	if r.p > capacity {
+		r.p = capacity
+	}
+	evicted := r.evict(nil)
+	r.trimGhosts()
+	r.mu.Unlock()
+
+	for _, an := range evicted {
+		an.h.Release()
+	}
+}
+
+func (r *arc) Promote(n *Node) {
+	var evicted []*arcNode
+
+	r.mu.Lock()
+	if n.CacheData == nil {
+		if n.Size() <= r.capacity {
+			k := arcKey{n.NS(), n.Key()}
+			an := &arcNode{n: n, h: n.GetHandle(), ns: k.ns, key: k.key, size: n.Size()}
+			n.CacheData = unsafe.Pointer(an)
+			if g, ok := r.ghosts[k]; ok {
+				// A ghost hit means the list it was evicted from is too
+				// small; move the target towards it.
+				if g.list == &r.b1 {
+					r.p = min(r.capacity, r.p+max(r.b2.used/max(r.b1.used, 1), 1)*an.size)
+				} else {
+					r.p = max(0, r.p-max(r.b1.used/max(r.b2.used, 1), 1)*an.size)
+				}
+				g.list.remove(g)
+				delete(r.ghosts, k)
+				r.t2.pushFront(an)
+			} else if priorityOf(n) >= PriorityHigh {
+				r.t2.pushFront(an)
+			} else {
+				r.t1.pushFront(an)
+			}
+			evicted = r.evict(an)
+			r.trimGhosts()
+		}
+	} else {
+		an := (*arcNode)(n.CacheData)
+		if !an.ban {
+			an.list.remove(an)
+			r.t2.pushFront(an)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, an := range evicted {
+		an.h.Release()
+	}
+}
+
+// evict moves entries from t1 or t2 to the matching ghost list until the
+// resident size fits in the capacity. The just admitted entry is evicted
+// last.
+func (r *arc) evict(admitted *arcNode) []*arcNode {
+	var evicted []*arcNode
+	for r.t1.used+r.t2.used > r.capacity {
+		var from, to *arcList
+		if r.t1.used > 0 && (r.t1.used > r.p || r.t2.used == 0) {
+			from, to = &r.t1, &r.b1
+		} else {
+			from, to = &r.t2, &r.b2
+		}
+		an := from.back()
+		if an == nil {
+			break
+		}
+		if an == admitted && an.prev == &from.root {
+			// Only the admitted entry is left in this list, take from the other.
+			if from == &r.t1 {
+				from, to = &r.t2, &r.b2
+			} else {
+				from, to = &r.t1, &r.b1
+			}
+			if an = from.back(); an == nil {
+				break
+			}
+		}
+		from.remove(an)
+		an.n.CacheData = nil
+		evicted = append(evicted, an)
+
+		ghost := &arcNode{ns: an.ns, key: an.key, size: an.size}
+		to.pushFront(ghost)
+		r.ghosts[arcKey{an.ns, an.key}] = ghost
+	}
+	return evicted
+}
+
+// trimGhosts bounds t1+b1 and the whole directory to the capacity and
+// twice the capacity respectively.
+func (r *arc) trimGhosts() {
+	for r.t1.used+r.b1.used > r.capacity {
+		if !r.dropGhost(&r.b1) {
+			break
+		}
+	}
+	for r.t1.used+r.t2.used+r.b1.used+r.b2.used > 2*r.capacity {
+		if !r.dropGhost(&r.b2) && !r.dropGhost(&r.b1) {
+			break
+		}
+	}
+}
+
+func (r *arc) dropGhost(l *arcList) bool {
+	g := l.back()
+	if g == nil {
+		return false
+	}
+	l.remove(g)
+	delete(r.ghosts, arcKey{g.ns, g.key})
+	return true
+}
+
+func (r *arc) Ban(n *Node) {
+	r.mu.Lock()
+	if n.CacheData == nil {
+		n.CacheData = unsafe.Pointer(&arcNode{n: n, ban: true})
+	} else {
+		an := (*arcNode)(n.CacheData)
+		if !an.ban {
+			an.list.remove(an)
+			an.ban = true
+			r.mu.Unlock()
+
+			an.h.Release()
+			an.h = nil
+			return
+		}
+	}
+	r.mu.Unlock()
+}
+
+func (r *arc) Evict(n *Node) {
+	r.mu.Lock()
+	an := (*arcNode)(n.CacheData)
+	if an == nil || an.ban {
+		r.mu.Unlock()
+		return
+	}
+	an.list.remove(an)
+	n.CacheData = nil
+	r.mu.Unlock()
+
+	an.h.Release()
+}
+
+// NewARC creates a new scan-resistant ARC cache. Values implementing
+// Prioritizer with PriorityHigh are retained ahead of other values.
+func NewARC(capacity int) Cacher {
+	r := &arc{capacity: capacity}
+	r.reset()
+	return r
+}
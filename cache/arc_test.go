@@ -0,0 +1,119 @@
+package cache
+
+import "testing"
+
+type priorityValue struct {
+	p Priority
+}
+
+func (v priorityValue) CachePriority() Priority {
+	return v.p
+}
+
+// touch looks up key, inserting a value of size 1 if it is missing.
+func touch(c *Cache, key uint64, value Value) {
+	h := c.Get(0, key, func() (int, Value) { return 1, value })
+	if h == nil {
+		panic("cache get failed")
+	}
+	h.Release()
+}
+
+// resident counts the keys in [from, to) that are still cached.
+func resident(c *Cache, from, to uint64) int {
+	n := 0
+	for key := from; key < to; key++ {
+		if h := c.Get(0, key, nil); h != nil {
+			h.Release()
+			n++
+		}
+	}
+	return n
+}
+
+func TestARCScanResistance(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		cacher   Cacher
+		survives bool
+	}{
+		{"arc", NewARC(100), true},
+		{"lru", NewLRU(100), false},
+	} {
+		c := NewCache(tc.cacher)
+		// The frequent set is used twice before the scan.
+		for i := 0; i < 2; i++ {
+			for key := uint64(0); key < 50; key++ {
+				touch(c, key, key)
+			}
+		}
+		// A one-pass scan, ten times the capacity.
+		for key := uint64(1000); key < 2000; key++ {
+			touch(c, key, key)
+		}
+		if size := c.Size(); size > 100 {
+			t.Fatalf("%s: size %d exceeds the capacity", tc.name, size)
+		}
+		got := resident(c, 0, 50)
+		if tc.survives && got != 50 {
+			t.Fatalf("%s: %d of 50 frequent entries survived the scan", tc.name, got)
+		}
+		if !tc.survives && got != 0 {
+			t.Fatalf("%s: %d of 50 frequent entries survived the scan", tc.name, got)
+		}
+		c.Close(true)
+	}
+}
+
+func TestARCPriorityHint(t *testing.T) {
+	c := NewCache(NewARC(100))
+	defer c.Close(true)
+	// Entries used only once: branch pages hint PriorityHigh, leaves do not.
+	for key := uint64(0); key < 40; key++ {
+		touch(c, key, priorityValue{PriorityHigh})
+	}
+	for key := uint64(100); key < 140; key++ {
+		touch(c, key, priorityValue{PriorityLow})
+	}
+	for key := uint64(1000); key < 2000; key++ {
+		touch(c, key, key)
+	}
+	if got := resident(c, 0, 40); got != 40 {
+		t.Fatalf("%d of 40 high priority entries survived the scan", got)
+	}
+	if got := resident(c, 100, 140); got != 0 {
+		t.Fatalf("%d of 40 low priority entries survived the scan", got)
+	}
+}
+
+// TestARCGhostHit checks that an entry evicted from t1 and used again is
+// admitted as frequent and then survives a scan.
+func TestARCGhostHit(t *testing.T) {
+	c := NewCache(NewARC(100))
+	defer c.Close(true)
+	// Half of the capacity is taken by frequent entries, so t1 holds 50
+	// entries and b1 remembers the 50 evicted from it.
+	for i := 0; i < 2; i++ {
+		for key := uint64(500); key < 550; key++ {
+			touch(c, key, key)
+		}
+	}
+	for key := uint64(0); key < 100; key++ {
+		touch(c, key, key)
+	}
+	if got := resident(c, 0, 50); got != 0 {
+		t.Fatalf("%d of the oldest 50 entries are still cached", got)
+	}
+	for key := uint64(0); key < 20; key++ {
+		touch(c, key, key)
+	}
+	for key := uint64(1000); key < 2000; key++ {
+		touch(c, key, key)
+	}
+	if got := resident(c, 0, 20); got != 20 {
+		t.Fatalf("%d of 20 ghost hits survived the scan", got)
+	}
+	if got := resident(c, 500, 550); got != 50 {
+		t.Fatalf("%d of 50 frequent entries survived the scan", got)
+	}
+}
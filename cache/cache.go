@@ -32,6 +32,27 @@ type Cacher interface {
 // so the the Release method will be called once object is released.
 type Value interface{}
 
+// Priority is a retention hint for a 'cache-able object'.
+type Priority int
+
+const (
+	PriorityLow Priority = iota
+	PriorityHigh
+)
+
+// Prioritizer may be implemented by a Value to hint the Cacher that it
+// should be retained ahead of other values. Cachers are free to ignore it.
+type Prioritizer interface {
+	CachePriority() Priority
+}
+
+func priorityOf(n *Node) Priority {
+	if p, ok := n.Value().(Prioritizer); ok {
+		return p.CachePriority()
+	}
+	return PriorityLow
+}
+
 // NamespaceGetter provides convenient wrapper for namespace.
 type NamespaceGetter struct {
 	Cache *Cache
@@ -631,6 +652,34 @@ func (r *Cache) EvictAll() {
 // If 'force' is true then all 'cache node' will be forcefully released
 // even if the 'node ref' is not zero.
 func (r *Cache) Close(force bool) {
+	var head *mHead
+	// Hold RW-lock to make sure no more in-flight operations.
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		head = (*mHead)(atomic.LoadPointer(&r.mHead))
+	}
+	r.mu.Unlock()
+
+	if head != nil {
+		head.enumerateNodesWithCB(func(nodes []*Node) {
+			for _, n := range nodes {
+				// Zeroing ref. Prevent unRefExternal to call finalizer.
+				if force {
+					atomic.StoreInt32(&n.ref, 0)
+				}
+
+				// Evict from cacher.
+				if r.cacher != nil {
+					r.cacher.Evict(n)
+				}
+
+				if force {
+					n.callFinalizer()
+				}
+			}
+		})
+	}
 }
 
 // Node is a 'cache node'.
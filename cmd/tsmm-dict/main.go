@@ -0,0 +1,48 @@
+// Command tsmm-dict trains a new zstd dictionary for a store opened with the
+// zstd-dict compression type and commits it. Leaf pages written afterwards
+// use the new dictionary; pages compressed with older dictionaries stay
+// readable.
+//
+//	tsmm-dict -path /data/tsmm -versions 3 -samples 1024 -size 16384
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	tsmm "github.com/breeze-go-rust/go-tsmm"
+	"github.com/breeze-go-rust/go-tsmm/internal/compress"
+)
+
+func main() {
+	path := flag.String("path", "", "store directory")
+	versions := flag.Int("versions", 3, "number of retained meta versions the store was created with")
+	samples := flag.Int("samples", tsmm.DefaultDictSamples, "number of leaf pages to sample")
+	size := flag.Int("size", compress.DefaultDictSize, "maximum dictionary size in bytes")
+	flag.Parse()
+	if *path == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(*path, *versions, *samples, *size); err != nil {
+		fmt.Fprintln(os.Stderr, "tsmm-dict:", err)
+		os.Exit(1)
+	}
+}
+
+func run(path string, versions, samples, size int) error {
+	tree, err := tsmm.NewBTree(false, false, false, path, tsmm.CompressZSTDDict, versions, 0, "", 0, 0, nil)
+	if err != nil {
+		return err
+	}
+	if err := tree.TrainDict(samples, size); err != nil {
+		_ = tree.Close()
+		return err
+	}
+	if err := tree.Commit(); err != nil {
+		_ = tree.Close()
+		return err
+	}
+	return tree.Close()
+}
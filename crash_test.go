@@ -0,0 +1,207 @@
+package go_tsmm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/breeze-go-rust/go-tsmm/file"
+	"github.com/breeze-go-rust/go-tsmm/internal/common"
+	"github.com/breeze-go-rust/go-tsmm/internal/crashtest"
+	"github.com/breeze-go-rust/go-tsmm/util"
+)
+
+const crashDir = "/store"
+
+func openCrashTree(fs file.FS) (*BTree, error) {
+	return NewBTree(false, false, false, crashDir, "", 2, 0, "", 0, 0, &Options{FS: fs, PageSize: 4096})
+}
+
+func crashKey(i int) []byte {
+	return append(util.AccountPrefix(), []byte(fmt.Sprintf("key-%05d", i))...)
+}
+
+func crashValue(txid common.TxID, i int) []byte {
+	return []byte(fmt.Sprintf("value-%d-%d", txid, i))
+}
+
+func crashVersion(b *BTree) (crashtest.Version, error) {
+	root, err := b.RootHash()
+	if err != nil {
+		return crashtest.Version{}, err
+	}
+	return crashtest.Version{Txid: uint64(b.Txid()), Root: root}, nil
+}
+
+// crashWorkload 每次提交改写一部分 key、删除一部分 key，第 n 次提交后
+// key i（i%3 != 0 或 n 为 1）的 value 为 crashValue(n, i)
+func crashWorkload(fs file.FS, committed func(crashtest.Version)) error {
+	b, err := openCrashTree(fs)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+	for round := 1; round <= 2; round++ {
+		for i := 0; i < 300; i++ {
+			value := crashValue(common.TxID(round), i)
+			if round > 1 && i%3 == 0 {
+				value = nil
+			}
+			if err := b.Put(crashKey(i), value); err != nil {
+				return err
+			}
+		}
+		if err := b.Commit(); err != nil {
+			return err
+		}
+		v, err := crashVersion(b)
+		if err != nil {
+			return err
+		}
+		committed(v)
+	}
+	return nil
+}
+
+// crashOpen 重新打开后检查所有 key 都属于恢复到的版本
+func crashOpen(fs file.FS) (crashtest.Version, error) {
+	b, err := openCrashTree(fs)
+	if err != nil {
+		return crashtest.Version{}, err
+	}
+	defer b.Close()
+	txid := b.Txid()
+	for i := 0; i < 300 && txid > 0; i++ {
+		got, err := b.Get(crashKey(i))
+		if err != nil {
+			return crashtest.Version{}, err
+		}
+		want := crashValue(txid, i)
+		if txid > 1 && i%3 == 0 {
+			want = nil
+		}
+		if !bytes.Equal(got, want) {
+			return crashtest.Version{}, fmt.Errorf("txid %d key %d: got %q want %q", txid, i, got, want)
+		}
+	}
+	return crashVersion(b)
+}
+
+func TestCrashEveryPrefix(t *testing.T) {
+	err := crashtest.Run(crashtest.Config{
+		Seed:     1,
+		Workload: crashWorkload,
+		Open:     crashOpen,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestCommitFault 提交过程中写入失败时 Commit 返回错误，重新打开后停留在上一个版本
+func TestCommitFault(t *testing.T) {
+	mem := file.NewMemFS()
+	if err := crashWorkload(mem, func(crashtest.Version) {}); err != nil {
+		t.Fatal(err)
+	}
+	want, err := crashOpen(mem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tear := range []bool{false, true} {
+		fs := file.NewFaultFS(mem)
+		b, err := openCrashTree(fs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 300; i++ {
+			if err := b.Put(crashKey(i), []byte("lost")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		vlog := b.Stats().ValueLog
+		fs.FailWriteAt(fs.Written()+1024, tear)
+		if err := b.Commit(); !errors.Is(err, file.ErrInjectedFault) {
+			t.Fatalf("tear=%v: commit returned %v, want injected fault", tear, err)
+		}
+		// 被覆盖的旧记录仍属于已提交的版本，只有本次追加的记录失效
+		after := b.Stats().ValueLog
+		if want := vlog.Discard + after.Size - vlog.Size; after.Discard != want {
+			t.Fatalf("tear=%v: discard after failed commit = %d, want %d", tear, after.Discard, want)
+		}
+		_ = b.Close()
+		got, err := crashOpen(mem)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equal(want) {
+			t.Fatalf("tear=%v: recovered %v, want %v", tear, got, want)
+		}
+	}
+}
+
+// TestCommitFaultKeepsFreelist 提交失败后 freelist 回到已提交的状态，重试提交复用空闲页而不扩展页文件
+func TestCommitFaultKeepsFreelist(t *testing.T) {
+	run := func(fail bool) (free int, hwm common.Pgid) {
+		fs := file.NewFaultFS(file.NewMemFS())
+		b, err := openCrashTree(fs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer b.Close()
+		put := func(round int) {
+			for i := 0; i < 300; i++ {
+				if err := b.Put(crashKey(i), crashValue(common.TxID(round), i)); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		for round := 1; round <= 4; round++ {
+			put(round)
+			if err := b.Commit(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		put(5)
+		if fail {
+			b.allocLock.Lock()
+			free, hwm := b.freelist.FreeCount(), b.hwm
+			b.allocLock.Unlock()
+			fs.FailWriteAt(fs.Written()+1024, false)
+			if err := b.Commit(); !errors.Is(err, file.ErrInjectedFault) {
+				t.Fatalf("commit returned %v, want injected fault", err)
+			}
+			b.allocLock.Lock()
+			gotFree, gotHwm := b.freelist.FreeCount(), b.hwm
+			b.allocLock.Unlock()
+			if gotFree != free || gotHwm != hwm {
+				t.Fatalf("after failed commit free=%d hwm=%d, want free=%d hwm=%d", gotFree, gotHwm, free, hwm)
+			}
+			fs.Reset()
+		}
+		if err := b.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 300; i++ {
+			got, err := b.Get(crashKey(i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := crashValue(5, i); !bytes.Equal(got, want) {
+				t.Fatalf("key %d = %q, want %q", i, got, want)
+			}
+		}
+		b.allocLock.Lock()
+		defer b.allocLock.Unlock()
+		return b.freelist.FreeCount(), b.hwm
+	}
+	wantFree, wantHwm := run(false)
+	free, hwm := run(true)
+	if hwm > wantHwm {
+		t.Fatalf("page file grew to %d pages after a failed commit, want at most %d", hwm, wantHwm)
+	}
+	if free+int(wantHwm-hwm) < wantFree {
+		t.Fatalf("free pages = %d after a failed commit, want %d", free, wantFree)
+	}
+}
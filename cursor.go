@@ -0,0 +1,405 @@
+package go_tsmm
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/breeze-go-rust/go-tsmm/errors"
+	"github.com/breeze-go-rust/go-tsmm/internal/common"
+)
+
+// Cursor 按 key 顺序遍历事务版本中的一棵树，实现 Iterator。
+// 路径上的节点读入后即复制并释放缓存句柄，游标不固定任何缓存页；
+// 主树中子树的元素被跳过。Key 与 Value 返回的切片在游标移动后仍然有效。
+// 写事务的游标与事务中尚未提交的写入合并遍历，First、Last、Seek 时取写入的快照
+type Cursor struct {
+	tx       *Tx
+	tree     *BTree // 读页使用的树，nil 表示空树
+	prefix   []byte // 本树的 key 在外部 key 中的前缀
+	skipSubs bool
+	stack    []cursorElem
+	err      error
+
+	overlay common.Inodes // 写事务中本树尚未提交的写入，树内 key，value 为 nil 表示删除；nil 时只遍历树
+	oi      int           // overlay 中的位置
+	dir     int           // 最近一次移动的方向，1 向后，-1 向前
+	onBase  bool          // 当前元素来自树而不是 overlay
+	ok      bool          // 合并遍历时游标是否落在元素上
+}
+
+// cursorElem 路径上的一个节点与当前元素的下标
+type cursorElem struct {
+	inodes common.Inodes
+	isLeaf bool
+	index  int
+}
+
+func newCursor(tx *Tx, tree *BTree, prefix []byte, skipSubs bool) *Cursor {
+	return &Cursor{tx: tx, tree: tree, prefix: prefix, skipSubs: skipSubs}
+}
+
+func (c *Cursor) First() bool {
+	if !c.merging() {
+		return c.baseFirst()
+	}
+	c.baseFirst()
+	c.oi = 0
+	return c.settle(1)
+}
+
+func (c *Cursor) Last() bool {
+	if !c.merging() {
+		return c.baseLast()
+	}
+	c.baseLast()
+	c.oi = len(c.overlay) - 1
+	return c.settle(-1)
+}
+
+// Seek 定位到第一个不小于 key 的元素
+func (c *Cursor) Seek(key []byte) bool {
+	if !c.merging() {
+		return c.baseSeek(key)
+	}
+	c.baseSeek(key)
+	c.oi = sort.Search(len(c.overlay), func(i int) bool { return bytes.Compare(c.overlay[i].Key(), key) >= 0 })
+	return c.settle(1)
+}
+
+func (c *Cursor) Next() bool {
+	if c.overlay == nil {
+		return c.baseNext()
+	}
+	return c.step(1)
+}
+
+func (c *Cursor) Prev() bool {
+	if c.overlay == nil {
+		return c.basePrev()
+	}
+	return c.step(-1)
+}
+
+func (c *Cursor) Key() []byte {
+	if c.overlay != nil && !c.ok {
+		return nil
+	}
+	if c.overlay == nil || c.onBase {
+		if !c.valid() {
+			return nil
+		}
+		return c.current().Key()
+	}
+	return c.overlay[c.oi].Key()
+}
+
+// Value 读取当前元素在 value log 中的 value，读取失败时返回 nil，错误由 Error 报告
+func (c *Cursor) Value() []byte {
+	if c.overlay != nil && !c.ok {
+		return nil
+	}
+	if c.overlay == nil || c.onBase {
+		if !c.valid() {
+			return nil
+		}
+		v, err := c.tree.parent().readValue(c.current().Value())
+		if err != nil {
+			c.err = err
+			return nil
+		}
+		return v
+	}
+	return append([]byte{}, c.overlay[c.oi].Value()...)
+}
+
+// Get 读取事务版本中本树的 key，不移动游标；写事务中读到事务自己的写入
+func (c *Cursor) Get(key []byte) ([]byte, error) {
+	if c.tx.closed {
+		return nil, errors.ErrTxClosed
+	}
+	if c.tx.writable {
+		return c.tx.Get(append(append(make([]byte, 0, len(c.prefix)+len(key)), c.prefix...), key...))
+	}
+	if c.tree == nil {
+		return nil, nil
+	}
+	return c.tree.get(key)
+}
+
+func (c *Cursor) Error() error {
+	return c.err
+}
+
+// merging 重新取写事务中本树尚未提交的写入，没有时只遍历树
+func (c *Cursor) merging() bool {
+	c.overlay = c.tx.overlay(c.prefix)
+	c.ok = false
+	return c.overlay != nil
+}
+
+// settle 从树与 overlay 的当前位置中按方向 dir 选出第一个可见的元素：
+// 同一个 key 以 overlay 为准，overlay 中的删除遮住树中的 key
+func (c *Cursor) settle(dir int) bool {
+	c.dir = dir
+	c.ok = false
+	for c.err == nil {
+		onBase := c.valid()
+		if c.oi < 0 || c.oi >= len(c.overlay) {
+			c.onBase = onBase
+			c.ok = onBase
+			return onBase
+		}
+		in := c.overlay[c.oi]
+		cmp := -dir
+		if onBase {
+			cmp = bytes.Compare(in.Key(), c.current().Key())
+		}
+		if cmp*dir > 0 {
+			c.onBase, c.ok = true, true
+			return true
+		}
+		if in.Value() == nil {
+			if cmp == 0 {
+				c.baseStep(dir)
+			}
+			c.oi += dir
+			continue
+		}
+		c.onBase, c.ok = false, true
+		return true
+	}
+	return false
+}
+
+// step 合并遍历时移动到 dir 方向的下一个元素，方向改变时先让两路都回到当前 key
+func (c *Cursor) step(dir int) bool {
+	if !c.ok {
+		return false
+	}
+	if c.dir != dir {
+		key := c.Key()
+		if dir > 0 {
+			c.baseSeek(key)
+			c.oi = sort.Search(len(c.overlay), func(i int) bool { return bytes.Compare(c.overlay[i].Key(), key) >= 0 })
+		} else {
+			if !c.baseSeek(key) {
+				c.baseLast()
+			} else if !bytes.Equal(c.current().Key(), key) {
+				c.basePrev()
+			}
+			c.oi = sort.Search(len(c.overlay), func(i int) bool { return bytes.Compare(c.overlay[i].Key(), key) > 0 }) - 1
+		}
+		if !c.settle(dir) {
+			return false
+		}
+	}
+	if c.onBase {
+		c.baseStep(dir)
+	} else {
+		if c.valid() && bytes.Equal(c.current().Key(), c.overlay[c.oi].Key()) {
+			c.baseStep(dir)
+		}
+		c.oi += dir
+	}
+	return c.settle(dir)
+}
+
+func (c *Cursor) baseStep(dir int) {
+	if dir > 0 {
+		c.baseNext()
+	} else {
+		c.basePrev()
+	}
+}
+
+func (c *Cursor) baseFirst() bool {
+	if !c.reset() {
+		return false
+	}
+	root := c.tree.header
+	if !c.descend(root.RootPage(), root.Overflow(), false) {
+		return false
+	}
+	return c.forward()
+}
+
+func (c *Cursor) baseLast() bool {
+	if !c.reset() {
+		return false
+	}
+	root := c.tree.header
+	if !c.descend(root.RootPage(), root.Overflow(), true) {
+		return false
+	}
+	return c.backward()
+}
+
+func (c *Cursor) baseSeek(key []byte) bool {
+	if !c.reset() {
+		return false
+	}
+	id, overflow := c.tree.header.RootPage(), c.tree.header.Overflow()
+	for {
+		e, ok := c.load(id, overflow)
+		if !ok {
+			return false
+		}
+		if e.isLeaf {
+			e.index = sort.Search(len(e.inodes), func(i int) bool { return bytes.Compare(e.inodes[i].Key(), key) != -1 })
+			c.stack = append(c.stack, e)
+			return c.forward()
+		}
+		e.index = sort.Search(len(e.inodes), func(i int) bool { return bytes.Compare(e.inodes[i].Key(), key) == 1 })
+		if e.index > 0 {
+			e.index--
+		}
+		c.stack = append(c.stack, e)
+		child := e.inodes[e.index]
+		id, overflow = child.Pgid(), child.Overflow()
+	}
+}
+
+func (c *Cursor) baseNext() bool {
+	if !c.valid() {
+		return false
+	}
+	c.stack[len(c.stack)-1].index++
+	return c.forward()
+}
+
+func (c *Cursor) basePrev() bool {
+	if !c.valid() {
+		return false
+	}
+	c.stack[len(c.stack)-1].index--
+	return c.backward()
+}
+
+// reset 清空路径，事务已结束或树为空时返回 false
+func (c *Cursor) reset() bool {
+	c.stack = c.stack[:0]
+	if c.tx.closed {
+		c.err = errors.ErrTxClosed
+		return false
+	}
+	return c.err == nil && c.tree != nil && c.tree.header.RootPage() != 0
+}
+
+func (c *Cursor) valid() bool {
+	if c.tx.closed {
+		c.err = errors.ErrTxClosed
+		c.stack = c.stack[:0]
+	}
+	if c.err != nil || len(c.stack) == 0 {
+		return false
+	}
+	e := c.stack[len(c.stack)-1]
+	return e.index >= 0 && e.index < len(e.inodes)
+}
+
+func (c *Cursor) current() *common.Inode {
+	e := c.stack[len(c.stack)-1]
+	return e.inodes[e.index]
+}
+
+// load 读取节点并复制其元素，随即释放缓存句柄
+func (c *Cursor) load(id common.Pgid, overflow uint32) (cursorElem, bool) {
+	n, h, err := c.tree.lookupNode(id, overflow)
+	if err != nil {
+		c.err = err
+		c.stack = c.stack[:0]
+		return cursorElem{}, false
+	}
+	e := cursorElem{inodes: make(common.Inodes, len(n.inodes)), isLeaf: n.isLeaf}
+	for i, in := range n.inodes {
+		cp := &common.Inode{}
+		cp.SetFlags(in.Flags())
+		cp.SetPgid(in.Pgid())
+		cp.SetOverflow(in.Overflow())
+		cp.SetKey(append([]byte(nil), in.Key()...))
+		cp.SetValue(append([]byte(nil), in.Value()...))
+		e.inodes[i] = cp
+	}
+	if h != nil {
+		h.Release()
+	}
+	return e, true
+}
+
+// descend 自节点 id 沿第一个（last 为 true 时最后一个）子节点下降到叶子
+func (c *Cursor) descend(id common.Pgid, overflow uint32, last bool) bool {
+	for {
+		e, ok := c.load(id, overflow)
+		if !ok {
+			return false
+		}
+		if last {
+			e.index = len(e.inodes) - 1
+		}
+		c.stack = append(c.stack, e)
+		if e.isLeaf || len(e.inodes) == 0 {
+			return true
+		}
+		child := e.inodes[e.index]
+		id, overflow = child.Pgid(), child.Overflow()
+	}
+}
+
+// forward 当前位置越过叶子末尾或落在子树元素上时向后移动，直到一个有效的元素
+func (c *Cursor) forward() bool {
+	for len(c.stack) > 0 {
+		e := &c.stack[len(c.stack)-1]
+		if !e.isLeaf || e.index >= len(e.inodes) {
+			// 回到还有下一个子节点的祖先，再下降到其最左的叶子
+			c.stack = c.stack[:len(c.stack)-1]
+			for len(c.stack) > 0 && c.stack[len(c.stack)-1].index+1 >= len(c.stack[len(c.stack)-1].inodes) {
+				c.stack = c.stack[:len(c.stack)-1]
+			}
+			if len(c.stack) == 0 {
+				return false
+			}
+			p := &c.stack[len(c.stack)-1]
+			p.index++
+			child := p.inodes[p.index]
+			if !c.descend(child.Pgid(), child.Overflow(), false) {
+				return false
+			}
+			continue
+		}
+		if c.skipSubs && e.inodes[e.index].Flags()&common.SubTreeFlag != 0 {
+			e.index++
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// backward 与 forward 相反，向前移动到一个有效的元素
+func (c *Cursor) backward() bool {
+	for len(c.stack) > 0 {
+		e := &c.stack[len(c.stack)-1]
+		if !e.isLeaf || e.index < 0 {
+			c.stack = c.stack[:len(c.stack)-1]
+			for len(c.stack) > 0 && c.stack[len(c.stack)-1].index <= 0 {
+				c.stack = c.stack[:len(c.stack)-1]
+			}
+			if len(c.stack) == 0 {
+				return false
+			}
+			p := &c.stack[len(c.stack)-1]
+			p.index--
+			child := p.inodes[p.index]
+			if !c.descend(child.Pgid(), child.Overflow(), true) {
+				return false
+			}
+			continue
+		}
+		if c.skipSubs && e.inodes[e.index].Flags()&common.SubTreeFlag != 0 {
+			e.index--
+			continue
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+package go_tsmm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unsafe"
+
+	"github.com/breeze-go-rust/go-tsmm/errors"
+	"github.com/breeze-go-rust/go-tsmm/internal/common"
+	"github.com/breeze-go-rust/go-tsmm/internal/compress"
+)
+
+// CompressZSTDDict compressType：叶子页使用训练得到的 zstd 字典压缩，
+// 训练字典之前按普通 zstd 压缩
+const CompressZSTDDict = "zstd-dict"
+
+// DefaultDictSamples TrainDict 默认采样的叶子页数
+const DefaultDictSamples = 1024
+
+// 字典页：页头 | 上一个字典页号(8B) | zstd 字典，页头 size 为字典长度。
+// meta 记录最新的字典页，重新训练时旧字典页按链表保留不释放，
+// 用旧字典压缩的页仍可解压
+
+const dictPrevSize = 8
+
+// loadDicts 按 dictPage 加载字典链，字典模式下之后的叶子页使用最新的字典压缩
+func (b *BTree) loadDicts() error {
+	var dicts [][]byte
+	for id := b.dictPage; id != 0; {
+		buf, err := b.pageMgr.readFull(id)
+		if err != nil {
+			return fmt.Errorf("read dict page %d: %w", id, err)
+		}
+		p := (*common.Page)(unsafe.Pointer(&buf[0]))
+		hdr := int(common.PageHeaderSize)
+		if !p.IsDictPage() || hdr+dictPrevSize+int(p.Size()) > len(buf) {
+			b.pageMgr.free(buf)
+			return fmt.Errorf("dict page %d: %w", id, errors.ErrInvalid)
+		}
+		prev := common.Pgid(binary.LittleEndian.Uint64(buf[hdr:]))
+		dicts = append(dicts, append([]byte(nil), buf[hdr+dictPrevSize:hdr+dictPrevSize+int(p.Size())]...))
+		b.pageMgr.free(buf)
+		id = prev
+	}
+	b.committedDicts = len(dicts)
+	return b.useDicts(dicts)
+}
+
+// useDicts dicts 由新到旧排列
+func (b *BTree) useDicts(dicts [][]byte) error {
+	b.dicts = dicts
+	if len(dicts) == 0 {
+		b.pageMgr.dict.Store(nil)
+		if b.dictMode {
+			b.compressor = compress.NewCompressor(CompressZSTDDict)
+		}
+		return nil
+	}
+	c, err := compress.NewZSTDDictCompressor(dicts[0], dicts[1:]...)
+	if err != nil {
+		return err
+	}
+	b.pageMgr.dict.Store(c)
+	if b.dictMode {
+		b.compressor = c
+	}
+	return nil
+}
+
+// TrainDict 从页文件中采样至多 samples 个叶子页（<= 0 时为 DefaultDictSamples）
+// 训练不超过 maxSize 字节的 zstd 字典并写入新的字典页，之后的叶子页使用新字典压缩。
+// 旧字典保留，用它压缩的页仍可读取。字典随下一次 Commit 生效，回滚时一并丢弃；
+// 仅在 compressType 为 CompressZSTDDict 时可用，不能与 Commit 并发调用
+func (b *BTree) TrainDict(samples, maxSize int) error {
+	tree := b.parent()
+	if tree.isReadOnly {
+		return errors.ErrDatabaseReadOnly
+	}
+	if !tree.dictMode {
+		return fmt.Errorf("train dict: compress type is not %s", CompressZSTDDict)
+	}
+	if samples <= 0 {
+		samples = DefaultDictSamples
+	}
+	bodies, err := tree.sampleLeafPages(samples)
+	if err != nil {
+		return fmt.Errorf("train dict: %w", err)
+	}
+	if len(bodies) == 0 {
+		return fmt.Errorf("train dict: no leaf pages to sample")
+	}
+	d, err := compress.TrainZSTDDict(bodies, maxSize)
+	if err != nil {
+		return fmt.Errorf("train dict: %w", err)
+	}
+
+	hdr := int(common.PageHeaderSize)
+	pageSize := int(tree.pageSize())
+	p := tree.allocate((hdr + dictPrevSize + len(d) + pageSize - 1) / pageSize)
+	p.SetFlags(common.DictPageFlag)
+	p.SetSize(uint32(len(d)))
+	data := common.UnsafeByteSlice(unsafe.Pointer(p), common.PageHeaderSize, 0, dictPrevSize+len(d))
+	binary.LittleEndian.PutUint64(data, uint64(tree.dictPage))
+	copy(data[dictPrevSize:], d)
+
+	if err := tree.useDicts(append([][]byte{d}, tree.dicts...)); err != nil {
+		return fmt.Errorf("train dict: %w", err)
+	}
+	tree.dictPage = p.Id()
+	return nil
+}
+
+// sampleLeafPages 顺序扫描页文件，返回至多 n 个叶子页解压后的页体。
+// 已释放但尚未复用的页也会被采到，作为训练样本没有影响
+func (b *BTree) sampleLeafPages(n int) ([][]byte, error) {
+	hdr := int(common.PageHeaderSize)
+	var bodies [][]byte
+	for id := common.Pgid(2); id < b.ctx.meta.Pgid() && len(bodies) < n; {
+		raw, err := b.pageMgr.readRaw(id, 0)
+		if err != nil {
+			return nil, err
+		}
+		p := (*common.Page)(unsafe.Pointer(&raw[0]))
+		isLeaf, overflow := p.IsLeafPage(), p.Overflow()
+		b.pageMgr.free(raw)
+		if isLeaf && id+common.Pgid(overflow) < b.ctx.meta.Pgid() {
+			buf, err := b.pageMgr.read(id, overflow)
+			if err != nil {
+				return nil, err
+			}
+			p = (*common.Page)(unsafe.Pointer(&buf[0]))
+			if size := int(p.Size()); size > 0 && hdr+size <= len(buf) {
+				bodies = append(bodies, append([]byte(nil), buf[hdr:hdr+size]...))
+			}
+			b.pageMgr.free(buf)
+		}
+		id += common.Pgid(overflow) + 1
+	}
+	return bodies, nil
+}
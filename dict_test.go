@@ -0,0 +1,86 @@
+package go_tsmm
+
+import (
+	"testing"
+	"unsafe"
+
+	"github.com/breeze-go-rust/go-tsmm/internal/common"
+	"github.com/breeze-go-rust/go-tsmm/internal/compress"
+)
+
+// TestTrainDict 训练字典后的叶子页用字典压缩，重新打开后按字典链解压；
+// 没有加载字典时读取这些页返回错误
+func TestTrainDict(t *testing.T) {
+	dir := t.TempDir()
+	b := openCodecTree(t, dir, CompressZSTDDict)
+	putCodecRound(t, b, 0, 0, 2000)
+	if err := b.TrainDict(0, 4<<10); err != nil {
+		t.Fatal(err)
+	}
+	putCodecRound(t, b, 1, 0, 1000)
+	if err := b.TrainDict(0, 4<<10); err != nil {
+		t.Fatal(err)
+	}
+	putCodecRound(t, b, 2, 0, 500)
+	if got := len(b.dicts); got != 2 {
+		t.Fatalf("dictionaries after training twice = %d, want 2", got)
+	}
+	if got := leafCodecs(t, b); got[compress.CodecZSTDDict] == 0 {
+		t.Fatalf("leaf codecs after training a dictionary = %v", got)
+	}
+	round := func(i int) int {
+		switch {
+		case i < 500:
+			return 2
+		case i < 1000:
+			return 1
+		}
+		return 0
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 字典链与 compressType 无关，换用其他编码方式打开也能读取字典压缩的页
+	for _, cType := range []string{CompressZSTDDict, "snappy"} {
+		b = openCodecTree(t, dir, cType)
+		if got := len(b.dicts); got != 2 {
+			t.Fatalf("%s: dictionaries after reopen = %d, want 2", cType, got)
+		}
+		checkCodecValues(t, b, 2000, round)
+		if err := b.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b = openCodecTree(t, dir, "zstd")
+	defer b.Close()
+	if err := b.TrainDict(0, 0); err == nil {
+		t.Fatal("TrainDict succeeded without the zstd-dict compress type")
+	}
+	// 最左的叶子页在最后一次训练之后写出
+	id, overflow := b.header.RootPage(), b.header.Overflow()
+	for {
+		n, err := b.pageNode(id, overflow)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n.isLeaf {
+			break
+		}
+		id, overflow = n.inodes[0].Pgid(), n.inodes[0].Overflow()
+	}
+	raw, err := b.pageMgr.readRaw(id, overflow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec := compress.Codec((*common.Page)(unsafe.Pointer(&raw[0])).Codec())
+	b.pageMgr.free(raw)
+	if codec != compress.CodecZSTDDict {
+		t.Fatalf("leftmost leaf codec = %v, want %v", codec, compress.CodecZSTDDict)
+	}
+	b.pageMgr.dict.Store(nil)
+	if _, err := b.pageMgr.read(id, overflow); err == nil {
+		t.Fatal("reading a dictionary page without the dictionary succeeded")
+	}
+}
@@ -27,6 +27,25 @@ var (
 	// ErrTimeout is returned when a database cannot obtain an exclusive lock
 	// on the data file after the timeout passed to Open().
 	ErrTimeout = errors.New("timeout")
+
+	// ErrInvalidPageSize is returned when the page size is not a power of two
+	// between MinPageSize and MaxPageSize.
+	ErrInvalidPageSize = errors.New("invalid page size")
+
+	// ErrPageSizeMismatch is returned when the page size passed to Open()
+	// differs from the page size the database was created with.
+	ErrPageSizeMismatch = errors.New("page size mismatch")
+
+	// ErrInvalidHashType is returned when the hash type is not supported.
+	ErrInvalidHashType = errors.New("invalid hash type")
+
+	// ErrHashTypeMismatch is returned when the hash type passed to Open()
+	// differs from the hash type the database was created with.
+	ErrHashTypeMismatch = errors.New("hash type mismatch")
+
+	// ErrStoreNotEmpty is returned when bulk loading into a store that already
+	// has a committed version or pending writes.
+	ErrStoreNotEmpty = errors.New("store not empty")
 )
 
 // These errors can occur when beginning or committing a Tx.
@@ -81,4 +100,19 @@ var (
 	// ErrDifferentDB is returned when trying to move a sub-bucket between
 	// source and target buckets, while source and target buckets are in different database files.
 	ErrDifferentDB = errors.New("the source and target buckets are in different database files")
+
+	// ErrInvalidRange is returned when a deleted key range is unbounded or
+	// spans account keys and storage keys or more than one sub-tree.
+	ErrInvalidRange = errors.New("invalid key range")
+)
+
+// These errors can occur when decoding a serialized write batch.
+var (
+	// ErrBatchVersion is returned when a serialized batch was written with an
+	// unsupported format version.
+	ErrBatchVersion = errors.New("unsupported batch version")
+
+	// ErrBatchCorrupted is returned when a serialized batch fails its checksum
+	// or is truncated.
+	ErrBatchCorrupted = errors.New("batch corrupted")
 )
@@ -0,0 +1,57 @@
+package file
+
+import (
+	"errors"
+	"unsafe"
+)
+
+const (
+	// AlignSize 直接 I/O 要求的内存对齐大小
+	AlignSize = 4096
+	// BlockSize 直接 I/O 单次读写的最小块大小，偏移与长度都必须是它的整数倍
+	BlockSize = 4096
+)
+
+// ErrUnaligned 直接 I/O 模式下偏移或长度没有按 BlockSize 对齐
+var ErrUnaligned = errors.New("file: offset or length not aligned for direct I/O")
+
+// alignment 返回 block 首地址相对 alignSize 的偏移
+func alignment(block []byte, alignSize int) int {
+	return int(uintptr(unsafe.Pointer(&block[0])) & uintptr(alignSize-1))
+}
+
+// IsAligned 判断 block 的首地址是否满足直接 I/O 的内存对齐要求
+func IsAligned(block []byte) bool {
+	if len(block) == 0 {
+		return true
+	}
+	return alignment(block, AlignSize) == 0
+}
+
+// AlignedBlock 分配 size 字节、首地址按 AlignSize 对齐的内存块，
+// 可直接用于 O_DIRECT 的读写
+func AlignedBlock(size int) []byte {
+	if size == 0 {
+		return make([]byte, 0)
+	}
+	block := make([]byte, size+AlignSize)
+	offset := 0
+	if a := alignment(block, AlignSize); a != 0 {
+		offset = AlignSize - a
+	}
+	return block[offset : offset+size : offset+size]
+}
+
+// AlignUp 将 n 向上取整到 BlockSize 的整数倍
+func AlignUp(n int64) int64 {
+	return (n + BlockSize - 1) &^ (BlockSize - 1)
+}
+
+// AlignDown 将 n 向下取整到 BlockSize 的整数倍
+func AlignDown(n int64) int64 {
+	return n &^ (BlockSize - 1)
+}
+
+func isBlockAligned(offset int64, size int) bool {
+	return offset%BlockSize == 0 && size%BlockSize == 0
+}
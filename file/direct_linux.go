@@ -0,0 +1,8 @@
+//go:build linux
+
+package file
+
+import "syscall"
+
+// oDirect Linux 下绕过页缓存的打开标志
+const oDirect = syscall.O_DIRECT
@@ -0,0 +1,6 @@
+//go:build !linux
+
+package file
+
+// oDirect 非 Linux 平台不支持 O_DIRECT，DirectIO 选项会被忽略
+const oDirect = 0
@@ -0,0 +1,165 @@
+package file
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// ErrInjectedFault 由 FaultFS 注入的 I/O 错误
+var ErrInjectedFault = errors.New("file: injected fault")
+
+// FaultFS 包装一个 FS，在累计写入到达指定字节时让写入失败或被撕裂，
+// 用于验证崩溃恢复。故障触发后所有写入与 Sync 都返回 ErrInjectedFault，
+// 相当于设备已经掉电
+type FaultFS struct {
+	FS
+
+	mu       sync.Mutex
+	written  int64 // 累计写入字节数
+	failAt   int64 // 累计写入到达该字节时触发故障，<0 表示不触发
+	tear     bool  // 触发故障的那次写入是否先写入 failAt 之前的部分
+	failSync bool  // Sync 是否失败
+	tripped  bool
+}
+
+// NewFaultFS 包装 fs，初始不注入任何故障
+func NewFaultFS(fs FS) *FaultFS {
+	return &FaultFS{FS: fs, failAt: -1}
+}
+
+// FailWriteAt 在累计写入到达第 at 字节时触发故障；
+// tear 为 true 时该次写入中 at 之前的字节仍会写入底层文件
+func (fs *FaultFS) FailWriteAt(at int64, tear bool) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.failAt = at
+	fs.tear = tear
+}
+
+// FailSync 让之后的 Sync 与 SyncDir 返回 ErrInjectedFault
+func (fs *FaultFS) FailSync(fail bool) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.failSync = fail
+}
+
+// Reset 清除所有故障设置与计数
+func (fs *FaultFS) Reset() {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.written = 0
+	fs.failAt = -1
+	fs.tear = false
+	fs.failSync = false
+	fs.tripped = false
+}
+
+// Written 返回累计写入的字节数
+func (fs *FaultFS) Written() int64 {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.written
+}
+
+// Tripped 返回故障是否已经触发
+func (fs *FaultFS) Tripped() bool {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.tripped
+}
+
+// admit 计算本次写入允许落到底层的字节数
+func (fs *FaultFS) admit(n int) (int, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.tripped {
+		return 0, ErrInjectedFault
+	}
+	if fs.failAt >= 0 && fs.written+int64(n) > fs.failAt {
+		fs.tripped = true
+		allowed := 0
+		if fs.tear {
+			allowed = int(fs.failAt - fs.written)
+		}
+		fs.written += int64(allowed)
+		return allowed, ErrInjectedFault
+	}
+	fs.written += int64(n)
+	return n, nil
+}
+
+func (fs *FaultFS) syncErr() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.tripped || fs.failSync {
+		return ErrInjectedFault
+	}
+	return nil
+}
+
+func (fs *FaultFS) Open(name string, opts *Options) (Handle, error) {
+	h, err := fs.FS.Open(name, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &faultHandle{Handle: h, fs: fs}, nil
+}
+
+func (fs *FaultFS) Remove(name string) error {
+	if _, err := fs.admit(0); err != nil {
+		return err
+	}
+	return fs.FS.Remove(name)
+}
+
+func (fs *FaultFS) Rename(oldName, newName string) error {
+	if _, err := fs.admit(0); err != nil {
+		return err
+	}
+	return fs.FS.Rename(oldName, newName)
+}
+
+func (fs *FaultFS) SyncDir(dir string) error {
+	if err := fs.syncErr(); err != nil {
+		return err
+	}
+	return fs.FS.SyncDir(dir)
+}
+
+func (fs *FaultFS) Lock(name string, exclusive bool, timeout time.Duration) (io.Closer, error) {
+	return fs.FS.Lock(name, exclusive, timeout)
+}
+
+type faultHandle struct {
+	Handle
+	fs *FaultFS
+}
+
+func (h *faultHandle) WriteAt(p []byte, off int64) (int, error) {
+	allowed, err := h.fs.admit(len(p))
+	if allowed > 0 {
+		if n, werr := h.Handle.WriteAt(p[:allowed], off); werr != nil {
+			return n, werr
+		}
+	}
+	if err != nil {
+		return allowed, err
+	}
+	return len(p), nil
+}
+
+func (h *faultHandle) Truncate(size int64) error {
+	if _, err := h.fs.admit(0); err != nil {
+		return err
+	}
+	return h.Handle.Truncate(size)
+}
+
+func (h *faultHandle) Sync() error {
+	if err := h.fs.syncErr(); err != nil {
+		return err
+	}
+	return h.Handle.Sync()
+}
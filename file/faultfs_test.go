@@ -0,0 +1,106 @@
+package file
+
+import (
+	"bytes"
+	stderrors "errors"
+	"testing"
+)
+
+func openFault(t *testing.T) (*FaultFS, Handle) {
+	t.Helper()
+	fs := NewFaultFS(NewMemFS())
+	h, err := fs.Open("/data", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fs, h
+}
+
+// TestFaultFSFailWriteAt 累计写入越过故障点的那次写入失败，之后所有写入与 Sync 都失败
+func TestFaultFSFailWriteAt(t *testing.T) {
+	for _, tear := range []bool{false, true} {
+		fs, h := openFault(t)
+		fs.FailWriteAt(10, tear)
+		if n, err := h.WriteAt([]byte("012345"), 0); n != 6 || err != nil {
+			t.Fatalf("tear %v: write before the fault point = %d, %v", tear, n, err)
+		}
+		n, err := h.WriteAt([]byte("abcdef"), 6)
+		if !stderrors.Is(err, ErrInjectedFault) {
+			t.Fatalf("tear %v: write across the fault point returned %v", tear, err)
+		}
+		want := "012345"
+		if tear {
+			want = "012345abcd"
+		}
+		if n != len(want)-6 {
+			t.Fatalf("tear %v: write across the fault point reported %d bytes", tear, n)
+		}
+		if got := readAll(t, h); string(got) != want {
+			t.Fatalf("tear %v: file = %q, want %q", tear, got, want)
+		}
+		if got := fs.Written(); got != int64(len(want)) {
+			t.Fatalf("tear %v: Written = %d, want %d", tear, got, len(want))
+		}
+		if !fs.Tripped() {
+			t.Fatalf("tear %v: fault not tripped", tear)
+		}
+
+		// 掉电之后的所有修改都失败，读取不受影响
+		if _, err := h.WriteAt([]byte("x"), 0); !stderrors.Is(err, ErrInjectedFault) {
+			t.Fatalf("tear %v: write after the fault returned %v", tear, err)
+		}
+		for name, err := range map[string]error{
+			"sync":     h.Sync(),
+			"truncate": h.Truncate(0),
+			"sync dir": fs.SyncDir("/"),
+			"rename":   fs.Rename("/data", "/moved"),
+			"remove":   fs.Remove("/data"),
+		} {
+			if !stderrors.Is(err, ErrInjectedFault) {
+				t.Fatalf("tear %v: %s after the fault returned %v", tear, name, err)
+			}
+		}
+		if got := readAll(t, h); string(got) != want {
+			t.Fatalf("tear %v: file changed after the fault: %q", tear, got)
+		}
+
+		fs.Reset()
+		if fs.Tripped() || fs.Written() != 0 {
+			t.Fatalf("tear %v: Reset left tripped %v, written %d", tear, fs.Tripped(), fs.Written())
+		}
+		if _, err := h.WriteAt([]byte("x"), 0); err != nil {
+			t.Fatalf("tear %v: write after Reset: %v", tear, err)
+		}
+		if err := h.Sync(); err != nil {
+			t.Fatalf("tear %v: sync after Reset: %v", tear, err)
+		}
+	}
+}
+
+// TestFaultFSFailSync FailSync 只让 Sync 与 SyncDir 失败，写入照常进行
+func TestFaultFSFailSync(t *testing.T) {
+	fs, h := openFault(t)
+	fs.FailSync(true)
+	if _, err := h.WriteAt([]byte("data"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Sync(); !stderrors.Is(err, ErrInjectedFault) {
+		t.Fatalf("Sync returned %v", err)
+	}
+	if err := fs.SyncDir("/"); !stderrors.Is(err, ErrInjectedFault) {
+		t.Fatalf("SyncDir returned %v", err)
+	}
+	if fs.Tripped() {
+		t.Fatal("a failed sync tripped the write fault")
+	}
+	if got := readAll(t, h); !bytes.Equal(got, []byte("data")) {
+		t.Fatalf("file = %q", got)
+	}
+	fs.FailSync(false)
+	if err := h.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if got := fs.Written(); got != 4 {
+		t.Fatalf("Written = %d, want 4", got)
+	}
+}
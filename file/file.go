@@ -2,37 +2,85 @@ package file
 
 import (
 	"fmt"
-	"os"
 )
 
+// Options 打开文件时的可选参数
+type Options struct {
+	// DirectIO 以 O_DIRECT 打开文件，绕过操作系统页缓存。
+	// 文件系统不支持时（如 tmpfs）自动回退为普通 I/O
+	DirectIO bool
+	// ReadOnly 以只读方式打开文件，文件不存在时不会创建
+	ReadOnly bool
+}
+
 type File struct {
-	file  *os.File
-	flock FLock
+	file   Handle
+	direct bool
 }
 
-func OpenFile(filePath string, lock FLock) (*File, error) {
-	file, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE, 0600)
-	if err != nil {
-		return nil, fmt.Errorf("error opening file: %v", err)
+// OpenFile 通过 fs 打开文件，fs 为 nil 时使用 OS
+func OpenFile(fs FS, filePath string, opts *Options) (*File, error) {
+	if fs == nil {
+		fs = OS
 	}
-	if lock != nil {
-		if err := lock.Lock(file); err != nil {
-			_ = file.Close()
-			return nil, fmt.Errorf("error locking file: %v", err)
-		}
+	file, err := fs.Open(filePath, opts)
+	if err != nil {
+		return nil, fmt.Errorf("error opening file: %w", err)
 	}
 	f := &File{
-		file:  file,
-		flock: lock,
+		file:   file,
+		direct: file.DirectIO(),
 	}
 	return f, nil
 }
 
+// DirectIO 返回文件是否实际以直接 I/O 模式打开
+func (f *File) DirectIO() bool {
+	return f.direct
+}
+
+// WriteAt 直接 I/O 模式下 offset 与 len(data) 必须按 BlockSize 对齐，
+// data 未按内存对齐时会经由对齐的临时缓冲区写入
 func (f *File) WriteAt(offset int64, data []byte) (int, error) {
-	return f.file.WriteAt(data, offset)
+	if !f.direct {
+		return f.file.WriteAt(data, offset)
+	}
+	if !isBlockAligned(offset, len(data)) {
+		return 0, ErrUnaligned
+	}
+	if IsAligned(data) {
+		return f.file.WriteAt(data, offset)
+	}
+	buf := AlignedBlock(len(data))
+	copy(buf, data)
+	return f.file.WriteAt(buf, offset)
 }
+
+// ReadAt 直接 I/O 模式下的对齐要求同 WriteAt
 func (f *File) ReadAt(offset int64, data []byte) (int, error) {
-	return f.file.ReadAt(data, offset)
+	if !f.direct {
+		return f.file.ReadAt(data, offset)
+	}
+	if !isBlockAligned(offset, len(data)) {
+		return 0, ErrUnaligned
+	}
+	if IsAligned(data) {
+		return f.file.ReadAt(data, offset)
+	}
+	buf := AlignedBlock(len(data))
+	n, err := f.file.ReadAt(buf, offset)
+	copy(data, buf[:n])
+	return n, err
+}
+
+// Size 返回文件当前大小
+func (f *File) Size() (int64, error) {
+	return f.file.Size()
+}
+
+// Truncate 调整文件大小
+func (f *File) Truncate(size int64) error {
+	return f.file.Truncate(size)
 }
 
 func (f *File) Sync() error {
@@ -41,12 +89,8 @@ func (f *File) Sync() error {
 
 func (f *File) Close() error {
 	if err := f.file.Sync(); err != nil {
+		_ = f.file.Close()
 		return fmt.Errorf("error syncing file: %v", err)
 	}
-	if f.flock != nil {
-		if err := f.flock.Unlock(f.file); err != nil {
-			return fmt.Errorf("error unlocking file: %v", err)
-		}
-	}
 	return f.file.Close()
 }
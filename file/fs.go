@@ -0,0 +1,148 @@
+package file
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Handle 打开的文件句柄，由 FS 实现提供
+type Handle interface {
+	io.ReaderAt
+	io.WriterAt
+	io.Closer
+	Sync() error
+	Size() (int64, error)
+	Truncate(size int64) error
+	// DirectIO 返回句柄是否实际以直接 I/O 方式打开
+	DirectIO() bool
+}
+
+// FS 文件系统抽象。PageMgr、MetaMgr 与 value log 只通过 FS 访问文件，
+// 测试中可以换成内存实现或故障注入实现
+type FS interface {
+	// Open 按 opts 打开文件，非只读模式下文件不存在时创建
+	Open(name string, opts *Options) (Handle, error)
+	Remove(name string) error
+	Rename(oldName, newName string) error
+	// List 返回目录下的文件名（不含目录前缀）
+	List(dir string) ([]string, error)
+	MkdirAll(dir string) error
+	// SyncDir 刷盘目录项，使新建、删除、重命名持久化
+	SyncDir(dir string) error
+	// Lock 获取 name 上的锁，返回的 io.Closer 用于释放
+	Lock(name string, exclusive bool, timeout time.Duration) (io.Closer, error)
+}
+
+// OS 基于操作系统文件的 FS 实现
+var OS FS = osFS{}
+
+type osFS struct{}
+
+type osFile struct {
+	*os.File
+	direct bool
+}
+
+func (f *osFile) Size() (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (f *osFile) DirectIO() bool {
+	return f.direct
+}
+
+func (osFS) Open(name string, opts *Options) (Handle, error) {
+	flag := os.O_RDWR | os.O_CREATE
+	if opts != nil && opts.ReadOnly {
+		flag = os.O_RDONLY
+	}
+	direct := opts != nil && opts.DirectIO && oDirect != 0
+	// direct 模式被文件系统拒绝时（EINVAL）回退为普通模式
+	if direct {
+		f, err := os.OpenFile(name, flag|oDirect, 0600)
+		if err == nil {
+			return &osFile{File: f, direct: true}, nil
+		}
+		if !errors.Is(err, syscall.EINVAL) {
+			return nil, err
+		}
+	}
+	f, err := os.OpenFile(name, flag, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &osFile{File: f}, nil
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFS) Rename(oldName, newName string) error {
+	return os.Rename(oldName, newName)
+}
+
+func (osFS) List(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names, nil
+}
+
+func (osFS) MkdirAll(dir string) error {
+	return os.MkdirAll(dir, 0700)
+}
+
+func (osFS) SyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := d.Sync(); err != nil {
+		_ = d.Close()
+		return err
+	}
+	return d.Close()
+}
+
+func (osFS) Lock(name string, exclusive bool, timeout time.Duration) (io.Closer, error) {
+	flag := os.O_RDWR | os.O_CREATE
+	if !exclusive {
+		flag = os.O_RDONLY
+	}
+	f, err := os.OpenFile(name, flag, 0600)
+	if err != nil {
+		return nil, err
+	}
+	locker := NewFLocker(exclusive, timeout)
+	if err := locker.Lock(f); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return &osLock{file: f, locker: locker}, nil
+}
+
+type osLock struct {
+	file   *os.File
+	locker FLock
+}
+
+func (l *osLock) Close() error {
+	if err := l.locker.Unlock(l.file); err != nil {
+		_ = l.file.Close()
+		return err
+	}
+	return l.file.Close()
+}
@@ -1,31 +1,76 @@
 package file
 
 import (
+	"io"
 	"os"
+	"path/filepath"
 	"syscall"
+	"time"
+
+	"github.com/breeze-go-rust/go-tsmm/errors"
 )
 
+// LockFileName 目录锁文件名
+const LockFileName = "LOCK"
+
+// flockRetryTimeout 非阻塞加锁失败后的重试间隔
+const flockRetryTimeout = 50 * time.Millisecond
+
 // FLock 接口定义了文件锁的基本操作
 type FLock interface {
-	Lock(file *os.File) error   // 获取排他锁
+	Lock(file *os.File) error   // 获取锁
 	Unlock(file *os.File) error // 释放锁
 }
 
 // FLocker 实现了 Lock 接口，基于系统的 flock 实现
-type FLocker struct{}
+type FLocker struct {
+	exclusive bool
+	timeout   time.Duration
+}
 
 // NewFLocker 创建一个新的 FLocker 实例
-func NewFLocker() *FLocker {
-	return &FLocker{}
+// exclusive 为 true 时获取排他锁，否则获取共享锁；
+// timeout 为 0 时一直等待直到获取到锁
+func NewFLocker(exclusive bool, timeout time.Duration) *FLocker {
+	return &FLocker{exclusive: exclusive, timeout: timeout}
 }
 
-// Lock 获取排他锁（阻塞模式）
-// 若文件已被锁定，当前调用会阻塞直到锁被释放
+// Lock 以非阻塞方式反复尝试加锁，超过 timeout 仍未获取到时返回 errors.ErrTimeout
 func (f *FLocker) Lock(file *os.File) error {
 	// syscall.Flock 参数说明：
 	// 1. 文件描述符（需转换为 int）
 	// 2. 操作类型：LOCK_EX（排他锁）| LOCK_SH（共享锁），可配合 LOCK_NB（非阻塞）
-	return syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+	flag := syscall.LOCK_SH
+	if f.exclusive {
+		flag = syscall.LOCK_EX
+	}
+	var deadline time.Time
+	if f.timeout != 0 {
+		deadline = time.Now().Add(f.timeout)
+	}
+	for {
+		err := syscall.Flock(int(file.Fd()), flag|syscall.LOCK_NB)
+		if err == nil {
+			return nil
+		} else if err == syscall.EINTR {
+			// 被信号中断，立即重试
+			continue
+		} else if err != syscall.EWOULDBLOCK {
+			return err
+		}
+		wait := flockRetryTimeout
+		if f.timeout != 0 {
+			// 超时后放弃；剩余时间不足一个重试间隔时只等待剩余时间，到期前再尝试一次
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				return errors.ErrTimeout
+			}
+			if remaining < wait {
+				wait = remaining
+			}
+		}
+		time.Sleep(wait)
+	}
 }
 
 // Unlock 释放已获取的锁
@@ -33,3 +78,17 @@ func (f *FLocker) Unlock(file *os.File) error {
 	// LOCK_UN 表示释放锁
 	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
 }
+
+// LockDir 对目录加锁，目录内的其余文件不再单独加锁。
+// 读写模式获取排他锁，只读模式获取共享锁，允许多个只读进程同时打开
+func LockDir(fs FS, dir string, readOnly bool, timeout time.Duration) (io.Closer, error) {
+	if fs == nil {
+		fs = OS
+	}
+	if !readOnly {
+		if err := fs.MkdirAll(dir); err != nil {
+			return nil, err
+		}
+	}
+	return fs.Lock(filepath.Join(dir, LockFileName), !readOnly, timeout)
+}
@@ -0,0 +1,114 @@
+package file
+
+import (
+	stderrors "errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/breeze-go-rust/go-tsmm/errors"
+)
+
+func openLockFile(t *testing.T, name string) *os.File {
+	t.Helper()
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = f.Close() })
+	return f
+}
+
+// TestFLockerTimeout 锁被占用时等待 timeout 后返回 ErrTimeout，等待时间不少于 timeout
+func TestFLockerTimeout(t *testing.T) {
+	name := filepath.Join(t.TempDir(), LockFileName)
+	holder := NewFLocker(true, 0)
+	if err := holder.Lock(openLockFile(t, name)); err != nil {
+		t.Fatal(err)
+	}
+	for _, timeout := range []time.Duration{20 * time.Millisecond, 120 * time.Millisecond} {
+		start := time.Now()
+		err := NewFLocker(true, timeout).Lock(openLockFile(t, name))
+		if !stderrors.Is(err, errors.ErrTimeout) {
+			t.Fatalf("timeout %v: lock held elsewhere returned %v, want ErrTimeout", timeout, err)
+		}
+		if waited := time.Since(start); waited < timeout {
+			t.Fatalf("timeout %v: gave up after %v", timeout, waited)
+		}
+	}
+}
+
+// TestFLockerShortTimeout timeout 小于重试间隔时，锁在到期前释放仍能获取到
+func TestFLockerShortTimeout(t *testing.T) {
+	name := filepath.Join(t.TempDir(), LockFileName)
+	held := openLockFile(t, name)
+	holder := NewFLocker(true, 0)
+	if err := holder.Lock(held); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_ = holder.Unlock(held)
+	}()
+	if err := NewFLocker(true, 40*time.Millisecond).Lock(openLockFile(t, name)); err != nil {
+		t.Fatalf("lock released before the deadline: %v", err)
+	}
+}
+
+// TestFLockerShared 共享锁之间互不阻塞，与排他锁互斥
+func TestFLockerShared(t *testing.T) {
+	name := filepath.Join(t.TempDir(), LockFileName)
+	shared := NewFLocker(false, 20*time.Millisecond)
+	first, second := openLockFile(t, name), openLockFile(t, name)
+	if err := shared.Lock(first); err != nil {
+		t.Fatal(err)
+	}
+	if err := shared.Lock(second); err != nil {
+		t.Fatalf("second shared lock: %v", err)
+	}
+	exclusive := NewFLocker(true, 20*time.Millisecond)
+	f := openLockFile(t, name)
+	if err := exclusive.Lock(f); !stderrors.Is(err, errors.ErrTimeout) {
+		t.Fatalf("exclusive lock with shared holders returned %v, want ErrTimeout", err)
+	}
+	for _, held := range []*os.File{first, second} {
+		if err := shared.Unlock(held); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := exclusive.Lock(f); err != nil {
+		t.Fatalf("exclusive lock after the shared holders left: %v", err)
+	}
+	if err := shared.Lock(first); !stderrors.Is(err, errors.ErrTimeout) {
+		t.Fatalf("shared lock with an exclusive holder returned %v, want ErrTimeout", err)
+	}
+}
+
+// TestLockDir 只读打开可以并存，与读写打开互斥
+func TestLockDir(t *testing.T) {
+	dir := t.TempDir()
+	rw, err := LockDir(nil, dir, false, 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LockDir(nil, dir, true, 20*time.Millisecond); !stderrors.Is(err, errors.ErrTimeout) {
+		t.Fatalf("read-only open of a locked directory returned %v, want ErrTimeout", err)
+	}
+	if err := rw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	ro1, err := LockDir(nil, dir, true, 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro1.Close()
+	ro2, err := LockDir(nil, dir, true, 20*time.Millisecond)
+	if err != nil {
+		t.Fatalf("second read-only open: %v", err)
+	}
+	defer ro2.Close()
+	if _, err := LockDir(nil, dir, false, 20*time.Millisecond); !stderrors.Is(err, errors.ErrTimeout) {
+		t.Fatalf("read-write open of a directory held read-only returned %v, want ErrTimeout", err)
+	}
+}
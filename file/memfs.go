@@ -0,0 +1,279 @@
+package file
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/breeze-go-rust/go-tsmm/errors"
+)
+
+// MemFS 纯内存的 FS 实现，用于单元测试。DirectIO 选项被忽略
+type MemFS struct {
+	mu    sync.Mutex
+	files map[string]*memFile
+	dirs  map[string]struct{}
+	locks map[string]*memLock
+}
+
+type memFile struct {
+	mu   sync.RWMutex
+	data []byte
+}
+
+type memLock struct {
+	readers int
+	writer  bool
+}
+
+// NewMemFS 创建一个空的内存文件系统
+func NewMemFS() *MemFS {
+	return &MemFS{
+		files: make(map[string]*memFile),
+		dirs:  map[string]struct{}{"/": {}, ".": {}},
+		locks: make(map[string]*memLock),
+	}
+}
+
+func (fs *MemFS) Open(name string, opts *Options) (Handle, error) {
+	name = filepath.Clean(name)
+	readOnly := opts != nil && opts.ReadOnly
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	f, ok := fs.files[name]
+	if !ok {
+		if readOnly {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+		if _, ok := fs.dirs[filepath.Dir(name)]; !ok {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+		f = &memFile{}
+		fs.files[name] = f
+	}
+	return &memHandle{name: name, f: f, readOnly: readOnly}, nil
+}
+
+func (fs *MemFS) Remove(name string) error {
+	name = filepath.Clean(name)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if _, ok := fs.files[name]; ok {
+		delete(fs.files, name)
+		return nil
+	}
+	if _, ok := fs.dirs[name]; ok {
+		for n := range fs.files {
+			if filepath.Dir(n) == name {
+				return &os.PathError{Op: "remove", Path: name, Err: os.ErrExist}
+			}
+		}
+		delete(fs.dirs, name)
+		return nil
+	}
+	return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+}
+
+func (fs *MemFS) Rename(oldName, newName string) error {
+	oldName, newName = filepath.Clean(oldName), filepath.Clean(newName)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	f, ok := fs.files[oldName]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: os.ErrNotExist}
+	}
+	if _, ok := fs.dirs[filepath.Dir(newName)]; !ok {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: os.ErrNotExist}
+	}
+	delete(fs.files, oldName)
+	fs.files[newName] = f
+	return nil
+}
+
+func (fs *MemFS) List(dir string) ([]string, error) {
+	dir = filepath.Clean(dir)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if _, ok := fs.dirs[dir]; !ok {
+		return nil, &os.PathError{Op: "readdir", Path: dir, Err: os.ErrNotExist}
+	}
+	var names []string
+	for n := range fs.files {
+		if filepath.Dir(n) == dir {
+			names = append(names, filepath.Base(n))
+		}
+	}
+	for d := range fs.dirs {
+		if d != dir && filepath.Dir(d) == dir {
+			names = append(names, filepath.Base(d))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (fs *MemFS) MkdirAll(dir string) error {
+	dir = filepath.Clean(dir)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for {
+		fs.dirs[dir] = struct{}{}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil
+		}
+		dir = parent
+	}
+}
+
+func (fs *MemFS) SyncDir(dir string) error {
+	dir = filepath.Clean(dir)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if _, ok := fs.dirs[dir]; !ok {
+		return &os.PathError{Op: "sync", Path: dir, Err: os.ErrNotExist}
+	}
+	return nil
+}
+
+// Lock 进程内的读写锁语义，与 FLocker 一样按 flockRetryTimeout 轮询直到超时
+func (fs *MemFS) Lock(name string, exclusive bool, timeout time.Duration) (io.Closer, error) {
+	name = filepath.Clean(name)
+	if exclusive {
+		if _, err := fs.Open(name, nil); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := fs.Open(name, &Options{ReadOnly: true}); err != nil {
+			return nil, err
+		}
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		fs.mu.Lock()
+		l, ok := fs.locks[name]
+		if !ok {
+			l = &memLock{}
+			fs.locks[name] = l
+		}
+		if exclusive && !l.writer && l.readers == 0 {
+			l.writer = true
+			fs.mu.Unlock()
+			return &memUnlocker{fs: fs, l: l, exclusive: true}, nil
+		}
+		if !exclusive && !l.writer {
+			l.readers++
+			fs.mu.Unlock()
+			return &memUnlocker{fs: fs, l: l}, nil
+		}
+		fs.mu.Unlock()
+		wait := flockRetryTimeout
+		if timeout != 0 {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				return nil, errors.ErrTimeout
+			}
+			if remaining < wait {
+				wait = remaining
+			}
+		}
+		time.Sleep(wait)
+	}
+}
+
+type memUnlocker struct {
+	fs        *MemFS
+	l         *memLock
+	exclusive bool
+	once      sync.Once
+}
+
+func (u *memUnlocker) Close() error {
+	u.once.Do(func() {
+		u.fs.mu.Lock()
+		defer u.fs.mu.Unlock()
+		if u.exclusive {
+			u.l.writer = false
+		} else {
+			u.l.readers--
+		}
+	})
+	return nil
+}
+
+type memHandle struct {
+	name     string
+	f        *memFile
+	readOnly bool
+}
+
+func (h *memHandle) ReadAt(p []byte, off int64) (int, error) {
+	h.f.mu.RLock()
+	defer h.f.mu.RUnlock()
+	if off >= int64(len(h.f.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, h.f.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (h *memHandle) WriteAt(p []byte, off int64) (int, error) {
+	if h.readOnly {
+		return 0, &os.PathError{Op: "write", Path: h.name, Err: os.ErrPermission}
+	}
+	h.f.mu.Lock()
+	defer h.f.mu.Unlock()
+	if end := off + int64(len(p)); end > int64(len(h.f.data)) {
+		h.f.grow(end)
+	}
+	return copy(h.f.data[off:], p), nil
+}
+
+func (f *memFile) grow(size int64) {
+	if size <= int64(cap(f.data)) {
+		f.data = f.data[:size]
+		return
+	}
+	data := make([]byte, size, size*2)
+	copy(data, f.data)
+	f.data = data
+}
+
+func (h *memHandle) Size() (int64, error) {
+	h.f.mu.RLock()
+	defer h.f.mu.RUnlock()
+	return int64(len(h.f.data)), nil
+}
+
+func (h *memHandle) Truncate(size int64) error {
+	if h.readOnly {
+		return &os.PathError{Op: "truncate", Path: h.name, Err: os.ErrPermission}
+	}
+	h.f.mu.Lock()
+	defer h.f.mu.Unlock()
+	if size > int64(len(h.f.data)) {
+		h.f.grow(size)
+		return nil
+	}
+	clear(h.f.data[size:])
+	h.f.data = h.f.data[:size]
+	return nil
+}
+
+func (h *memHandle) Sync() error {
+	return nil
+}
+
+func (h *memHandle) Close() error {
+	return nil
+}
+
+func (h *memHandle) DirectIO() bool {
+	return false
+}
@@ -0,0 +1,176 @@
+package file
+
+import (
+	"bytes"
+	stderrors "errors"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/breeze-go-rust/go-tsmm/errors"
+)
+
+func readAll(t *testing.T, h Handle) []byte {
+	t.Helper()
+	size, err := h.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, size)
+	if _, err := h.ReadAt(buf, 0); err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+// TestMemFSTruncate 截断后丢弃尾部，再次扩展时尾部读到零
+func TestMemFSTruncate(t *testing.T) {
+	fs := NewMemFS()
+	h, err := fs.Open("/data", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.WriteAt([]byte("0123456789"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Truncate(4); err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, h); string(got) != "0123" {
+		t.Fatalf("after truncating to 4 = %q", got)
+	}
+	if err := h.Truncate(8); err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, h); !bytes.Equal(got, []byte("0123\x00\x00\x00\x00")) {
+		t.Fatalf("after growing to 8 = %q", got)
+	}
+	// 超出文件末尾的写入在中间补零
+	if _, err := h.WriteAt([]byte("x"), 12); err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, h); !bytes.Equal(got, []byte("0123\x00\x00\x00\x00\x00\x00\x00\x00x")) {
+		t.Fatalf("after writing past the end = %q", got)
+	}
+	buf := make([]byte, 4)
+	if n, err := h.ReadAt(buf, 11); n != 2 || err != io.EOF {
+		t.Fatalf("short read at the end = %d, %v", n, err)
+	}
+
+	ro, err := fs.Open("/data", &Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ro.Truncate(0); !stderrors.Is(err, os.ErrPermission) {
+		t.Fatalf("truncating a read-only handle returned %v", err)
+	}
+	if _, err := ro.WriteAt([]byte("x"), 0); !stderrors.Is(err, os.ErrPermission) {
+		t.Fatalf("writing a read-only handle returned %v", err)
+	}
+	if _, err := fs.Open("/missing", &Options{ReadOnly: true}); !stderrors.Is(err, os.ErrNotExist) {
+		t.Fatalf("read-only open of a missing file returned %v", err)
+	}
+}
+
+// TestMemFSListRemove List 只返回直接位于目录下的文件与子目录，Remove 拒绝删除非空目录
+func TestMemFSListRemove(t *testing.T) {
+	fs := NewMemFS()
+	if err := fs.MkdirAll("/db/vlog"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"/db/page", "/db/meta", "/db/vlog/000001.vlog"} {
+		if _, err := fs.Open(name, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := fs.Open("/other/file", nil); !stderrors.Is(err, os.ErrNotExist) {
+		t.Fatalf("creating a file in a missing directory returned %v", err)
+	}
+	names, err := fs.List("/db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"meta", "page", "vlog"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("List(/db) = %v, want %v", names, want)
+	}
+	if _, err := fs.List("/missing"); !stderrors.Is(err, os.ErrNotExist) {
+		t.Fatalf("listing a missing directory returned %v", err)
+	}
+
+	if err := fs.Remove("/db/vlog"); !stderrors.Is(err, os.ErrExist) {
+		t.Fatalf("removing a non-empty directory returned %v", err)
+	}
+	if err := fs.Remove("/db/vlog/000001.vlog"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Remove("/db/vlog"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Remove("/db/page"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Remove("/db/page"); !stderrors.Is(err, os.ErrNotExist) {
+		t.Fatalf("removing a removed file returned %v", err)
+	}
+	if names, err := fs.List("/db"); err != nil || !reflect.DeepEqual(names, []string{"meta"}) {
+		t.Fatalf("List(/db) after remove = %v, %v", names, err)
+	}
+
+	if err := fs.Rename("/db/meta", "/db/meta.old"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Open("/db/meta", &Options{ReadOnly: true}); !stderrors.Is(err, os.ErrNotExist) {
+		t.Fatalf("opening the old name after rename returned %v", err)
+	}
+}
+
+// TestMemFSLock 与 FLocker 相同的读写锁语义：共享锁并存，与排他锁互斥，超时返回 ErrTimeout
+func TestMemFSLock(t *testing.T) {
+	fs := NewMemFS()
+	const timeout = 20 * time.Millisecond
+	if _, err := fs.Lock("/LOCK", false, timeout); !stderrors.Is(err, os.ErrNotExist) {
+		t.Fatalf("shared lock of a missing file returned %v", err)
+	}
+	w, err := fs.Lock("/LOCK", true, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err := fs.Lock("/LOCK", false, timeout); !stderrors.Is(err, errors.ErrTimeout) {
+		t.Fatalf("shared lock with an exclusive holder returned %v, want ErrTimeout", err)
+	}
+	if waited := time.Since(start); waited < timeout {
+		t.Fatalf("gave up after %v, timeout %v", waited, timeout)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// 重复释放没有影响
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r1, err := fs.Lock("/LOCK", false, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2, err := fs.Lock("/LOCK", false, timeout)
+	if err != nil {
+		t.Fatalf("second shared lock: %v", err)
+	}
+	if _, err := fs.Lock("/LOCK", true, timeout); !stderrors.Is(err, errors.ErrTimeout) {
+		t.Fatalf("exclusive lock with shared holders returned %v, want ErrTimeout", err)
+	}
+	_ = r1.Close()
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		_ = r2.Close()
+	}()
+	w, err = fs.Lock("/LOCK", true, 40*time.Millisecond)
+	if err != nil {
+		t.Fatalf("exclusive lock released before the deadline: %v", err)
+	}
+	_ = w.Close()
+}
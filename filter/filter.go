@@ -7,6 +7,56 @@ type Buffer interface {
 	WriteByte(c byte) error
 }
 
+// ByteBuffer is a Buffer backed by a growing byte slice.
+type ByteBuffer struct {
+	buf []byte
+}
+
+// Alloc appends n zeroed bytes and returns them.
+func (b *ByteBuffer) Alloc(n int) []byte {
+	off := len(b.buf)
+	b.buf = append(b.buf, make([]byte, n)...)
+	return b.buf[off:]
+}
+
+func (b *ByteBuffer) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	return len(p), nil
+}
+
+func (b *ByteBuffer) WriteByte(c byte) error {
+	b.buf = append(b.buf, c)
+	return nil
+}
+
+// Bytes returns the buffered bytes.
+func (b *ByteBuffer) Bytes() []byte {
+	return b.buf
+}
+
+// Len returns the number of buffered bytes.
+func (b *ByteBuffer) Len() int {
+	return len(b.buf)
+}
+
+// Reset empties the buffer, keeping its storage.
+func (b *ByteBuffer) Reset() {
+	b.buf = b.buf[:0]
+}
+
+// ByName returns the builtin filter whose blocks are serialized under name.
+// The builtin filters serialize their parameters, so the returned filter
+// can test any block written under that name.
+func ByName(name string) (Filter, bool) {
+	switch name {
+	case bloomFilter(0).Name():
+		return bloomFilter(0), true
+	case binaryFuseFilter{}.Name():
+		return binaryFuseFilter{}, true
+	}
+	return nil, false
+}
+
 // Filter is the filter.
 type Filter interface {
 	Name() string
@@ -0,0 +1,125 @@
+package filter
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+const testKeys = 10000
+
+func testKey(prefix byte, i int) []byte {
+	key := make([]byte, 9)
+	key[0] = prefix
+	binary.BigEndian.PutUint64(key[1:], uint64(i))
+	return key
+}
+
+func buildFilter(f Filter, n int) []byte {
+	g := f.NewGenerator()
+	for i := 0; i < n; i++ {
+		g.Add(testKey('k', i))
+	}
+	var buf ByteBuffer
+	g.Generate(&buf)
+	return buf.Bytes()
+}
+
+// falsePositiveRate probes keys that were never added.
+func falsePositiveRate(f Filter, data []byte, probes int) float64 {
+	fp := 0
+	for i := 0; i < probes; i++ {
+		if f.Contains(data, testKey('x', i)) {
+			fp++
+		}
+	}
+	return float64(fp) / float64(probes)
+}
+
+var testFilters = []struct {
+	filter Filter
+	maxFP  float64 // expected rate with some headroom
+}{
+	{NewBloomFilter(10), 0.015},
+	{NewBinaryFuseFilter(), 0.006},
+}
+
+func TestFilterNoFalseNegatives(t *testing.T) {
+	for _, tc := range testFilters {
+		for _, n := range []int{1, 2, 10, 1000, testKeys} {
+			data := buildFilter(tc.filter, n)
+			for i := 0; i < n; i++ {
+				if !tc.filter.Contains(data, testKey('k', i)) {
+					t.Fatalf("%s with %d keys: key %d not found", tc.filter.Name(), n, i)
+				}
+			}
+		}
+	}
+}
+
+func TestFilterFalsePositiveRate(t *testing.T) {
+	for _, tc := range testFilters {
+		data := buildFilter(tc.filter, testKeys)
+		if rate := falsePositiveRate(tc.filter, data, 100000); rate > tc.maxFP {
+			t.Errorf("%s: false positive rate %.4f, want <= %.4f", tc.filter.Name(), rate, tc.maxFP)
+		}
+	}
+}
+
+func TestFilterEmpty(t *testing.T) {
+	for _, tc := range testFilters {
+		data := buildFilter(tc.filter, 0)
+		if rate := falsePositiveRate(tc.filter, data, 1000); rate != 0 {
+			t.Errorf("%s: empty filter matches %.4f of keys", tc.filter.Name(), rate)
+		}
+	}
+}
+
+func TestFilterByName(t *testing.T) {
+	for _, tc := range testFilters {
+		f, ok := ByName(tc.filter.Name())
+		if !ok {
+			t.Fatalf("%s: not found by name", tc.filter.Name())
+		}
+		data := buildFilter(tc.filter, 1000)
+		for i := 0; i < 1000; i++ {
+			if !f.Contains(data, testKey('k', i)) {
+				t.Fatalf("%s: filter found by name misses key %d", tc.filter.Name(), i)
+			}
+		}
+	}
+	if _, ok := ByName("unknown"); ok {
+		t.Fatal("unknown filter name resolved")
+	}
+}
+
+func BenchmarkFilterGenerate(b *testing.B) {
+	for _, tc := range testFilters {
+		b.Run(tc.filter.Name(), func(b *testing.B) {
+			var data []byte
+			for i := 0; i < b.N; i++ {
+				data = buildFilter(tc.filter, testKeys)
+			}
+			b.ReportMetric(float64(len(data))/testKeys, "bytes/key")
+		})
+	}
+}
+
+func BenchmarkFilterContains(b *testing.B) {
+	for _, tc := range testFilters {
+		data := buildFilter(tc.filter, testKeys)
+		for _, hit := range []bool{true, false} {
+			prefix := byte('x')
+			if hit {
+				prefix = 'k'
+			}
+			b.Run(fmt.Sprintf("%s/hit=%v", tc.filter.Name(), hit), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					tc.filter.Contains(data, testKey(prefix, i%testKeys))
+				}
+				b.ReportMetric(falsePositiveRate(tc.filter, data, testKeys)*100, "fp%")
+				b.ReportMetric(float64(len(data))/testKeys, "bytes/key")
+			})
+		}
+	}
+}
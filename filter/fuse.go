@@ -0,0 +1,293 @@
+package filter
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// The binary fuse filter is described in:
+// "Binary Fuse Filters: Fast and Smaller Than Xor Filters", by Thomas Mueller
+// Graf and Daniel Lemire. ACM Journal of Experimental Algorithmics, 2022.
+//
+// A filter with 8-bit fingerprints uses about 9 bits per key for a false
+// positive rate of 1/256, against about 10 bits per key for a 1% bloom
+// filter. The filter is serialized as:
+//
+//	seed(8) | segment length(4) | segment count length(4) | fingerprints
+//
+// A zero segment length marks a filter that matches every key, which is
+// written in the unlikely case that construction does not converge.
+
+const (
+	fuseHeaderSize    = 16
+	fuseMaxIterations = 100
+	fuseMaxSegment    = 1 << 18
+)
+
+type binaryFuseFilter struct{}
+
+// Name The filter serializes its parameters, so they are not part of the name.
+func (binaryFuseFilter) Name() string {
+	return "go-tsmm.BinaryFuse8"
+}
+
+func (binaryFuseFilter) Contains(filter, key []byte) bool {
+	if len(filter) < fuseHeaderSize {
+		return true
+	}
+	seed := binary.LittleEndian.Uint64(filter[0:8])
+	segmentLength := binary.LittleEndian.Uint32(filter[8:12])
+	segmentCountLength := binary.LittleEndian.Uint32(filter[12:16])
+	fingerprints := filter[fuseHeaderSize:]
+	if segmentLength == 0 || segmentLength&(segmentLength-1) != 0 {
+		return true
+	}
+	if len(fingerprints) == 0 {
+		return false
+	}
+	if uint64(len(fingerprints)) != uint64(segmentCountLength)+2*uint64(segmentLength) {
+		return true
+	}
+	hash := fuseMix(fuseHash(key), seed)
+	h0, h1, h2 := fuseIndexes(hash, segmentLength, segmentCountLength)
+	f := fuseFingerprint(hash) ^ fingerprints[h0] ^ fingerprints[h1] ^ fingerprints[h2]
+	return f == 0
+}
+
+func (binaryFuseFilter) NewGenerator() FilterGenerator {
+	return &binaryFuseFilterGenerator{}
+}
+
+type binaryFuseFilterGenerator struct {
+	keyHashes []uint64
+}
+
+func (g *binaryFuseFilterGenerator) Add(key []byte) {
+	g.keyHashes = append(g.keyHashes, fuseHash(key))
+}
+
+func (g *binaryFuseFilterGenerator) Generate(b Buffer) {
+	hashes := g.keyHashes
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+	n := 0
+	for i, h := range hashes {
+		if i == 0 || h != hashes[n-1] {
+			hashes[n] = h
+			n++
+		}
+	}
+	hashes = hashes[:n]
+
+	header := b.Alloc(fuseHeaderSize)
+	if len(hashes) == 0 {
+		// Matches nothing: a valid segment length without fingerprints.
+		binary.LittleEndian.PutUint32(header[8:12], 1)
+		g.keyHashes = g.keyHashes[:0]
+		return
+	}
+	seed, segmentLength, segmentCountLength, fingerprints, ok := fusePopulate(hashes)
+	if ok {
+		binary.LittleEndian.PutUint64(header[0:8], seed)
+		binary.LittleEndian.PutUint32(header[8:12], segmentLength)
+		binary.LittleEndian.PutUint32(header[12:16], segmentCountLength)
+		_, _ = b.Write(fingerprints)
+	}
+	g.keyHashes = g.keyHashes[:0]
+}
+
+func fuseParameters(size int) (segmentLength, segmentCount, arrayLength uint32) {
+	const arity = 3
+	segmentLength = 4
+	if size > 0 {
+		segmentLength = uint32(1) << int(math.Floor(math.Log(float64(size))/math.Log(3.33)+2.25))
+	}
+	if segmentLength > fuseMaxSegment {
+		segmentLength = fuseMaxSegment
+	}
+	sizeFactor := 1.125
+	if size > 1 {
+		sizeFactor = math.Max(1.125, 0.875+0.25*math.Log(1000000)/math.Log(float64(size)))
+	}
+	capacity := 0
+	if size > 1 {
+		capacity = int(math.Round(float64(size) * sizeFactor))
+	}
+	count := (capacity+int(segmentLength)-1)/int(segmentLength) - (arity - 1)
+	if count < 1 {
+		count = 1
+	}
+	segmentCount = uint32(count)
+	arrayLength = (segmentCount + arity - 1) * segmentLength
+	return segmentLength, segmentCount, arrayLength
+}
+
+func fuseIndexes(hash uint64, segmentLength, segmentCountLength uint32) (uint32, uint32, uint32) {
+	hi, _ := bits.Mul64(hash, uint64(segmentCountLength))
+	h0 := uint32(hi)
+	h1 := h0 + segmentLength
+	h2 := h1 + segmentLength
+	mask := segmentLength - 1
+	h1 ^= uint32(hash>>18) & mask
+	h2 ^= uint32(hash) & mask
+	return h0, h1, h2
+}
+
+// fusePopulate builds the fingerprint array for distinct key hashes by
+// peeling the 3-hypergraph, retrying with a new seed if it has a cycle.
+func fusePopulate(keys []uint64) (seed uint64, segmentLength, segmentCountLength uint32, fingerprints []uint8, ok bool) {
+	size := uint32(len(keys))
+	segmentLength, segmentCount, capacity := fuseParameters(len(keys))
+	segmentCountLength = segmentCount * segmentLength
+
+	alone := make([]uint32, capacity)
+	// The low 2 bits of t2count hold the xor of the slot positions (0, 1
+	// or 2) of the keys mapped there, the remaining bits count them.
+	t2count := make([]uint8, capacity)
+	t2hash := make([]uint64, capacity)
+	reverseH := make([]uint8, size)
+	reverseOrder := make([]uint64, size+1)
+	var h012 [5]uint32
+
+	blockBits := 1
+	for (uint32(1) << blockBits) < segmentCount {
+		blockBits++
+	}
+	rng := uint64(1)
+	for iter := 0; iter < fuseMaxIterations; iter++ {
+		seed = fuseSplitMix(&rng)
+		clear(reverseOrder)
+		reverseOrder[size] = 1
+		clear(t2count)
+		clear(t2hash)
+
+		// Sort the hashes roughly by segment for cache locality.
+		startPos := make([]uint32, 1<<blockBits)
+		for i := range startPos {
+			startPos[i] = uint32((uint64(i) * uint64(size)) >> blockBits)
+		}
+		for _, key := range keys {
+			hash := fuseMix(key, seed)
+			segment := hash >> (64 - blockBits)
+			for reverseOrder[startPos[segment]] != 0 {
+				segment = (segment + 1) & ((1 << blockBits) - 1)
+			}
+			reverseOrder[startPos[segment]] = hash
+			startPos[segment]++
+		}
+
+		overflow := false
+		for i := uint32(0); i < size; i++ {
+			hash := reverseOrder[i]
+			h0, h1, h2 := fuseIndexes(hash, segmentLength, segmentCountLength)
+			t2count[h0] += 4
+			t2hash[h0] ^= hash
+			t2count[h1] += 4
+			t2count[h1] ^= 1
+			t2hash[h1] ^= hash
+			t2count[h2] += 4
+			t2count[h2] ^= 2
+			t2hash[h2] ^= hash
+			if t2count[h0] < 4 || t2count[h1] < 4 || t2count[h2] < 4 {
+				overflow = true
+			}
+		}
+		if overflow {
+			continue
+		}
+
+		queue := 0
+		for i := uint32(0); i < capacity; i++ {
+			alone[queue] = i
+			if t2count[i]>>2 == 1 {
+				queue++
+			}
+		}
+		stack := uint32(0)
+		for queue > 0 {
+			queue--
+			index := alone[queue]
+			if t2count[index]>>2 != 1 {
+				continue
+			}
+			hash := t2hash[index]
+			found := t2count[index] & 3
+			reverseH[stack] = found
+			reverseOrder[stack] = hash
+			stack++
+
+			h0, h1, h2 := fuseIndexes(hash, segmentLength, segmentCountLength)
+			h012[1], h012[2], h012[3], h012[4] = h1, h2, h0, h1
+			for j := uint8(1); j <= 2; j++ {
+				other := h012[found+j]
+				alone[queue] = other
+				if t2count[other]>>2 == 2 {
+					queue++
+				}
+				t2count[other] -= 4
+				t2count[other] ^= fuseMod3(found + j)
+				t2hash[other] ^= hash
+			}
+		}
+		if stack != size {
+			continue
+		}
+
+		fingerprints = make([]uint8, capacity)
+		for i := int(size) - 1; i >= 0; i-- {
+			hash := reverseOrder[i]
+			h0, h1, h2 := fuseIndexes(hash, segmentLength, segmentCountLength)
+			found := reverseH[i]
+			h012[0], h012[1], h012[2], h012[3], h012[4] = h0, h1, h2, h0, h1
+			fingerprints[h012[found]] = fuseFingerprint(hash) ^ fingerprints[h012[found+1]] ^ fingerprints[h012[found+2]]
+		}
+		return seed, segmentLength, segmentCountLength, fingerprints, true
+	}
+	return 0, 0, 0, nil, false
+}
+
+func fuseMod3(x uint8) uint8 {
+	if x > 2 {
+		x -= 3
+	}
+	return x
+}
+
+// fuseHash is 64-bit FNV-1a; the result is mixed with the seed before use.
+func fuseHash(key []byte) uint64 {
+	h := uint64(14695981039346656037)
+	for _, c := range key {
+		h ^= uint64(c)
+		h *= 1099511628211
+	}
+	return h
+}
+
+func fuseMix(key, seed uint64) uint64 {
+	h := key + seed
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+func fuseSplitMix(seed *uint64) uint64 {
+	*seed += 0x9E3779B97F4A7C15
+	z := *seed
+	z = (z ^ (z >> 30)) * 0xBF58476D1CE4E5B9
+	z = (z ^ (z >> 27)) * 0x94D049BB133111EB
+	return z ^ (z >> 31)
+}
+
+func fuseFingerprint(hash uint64) uint8 {
+	return uint8(hash ^ (hash >> 32))
+}
+
+// NewBinaryFuseFilter creates a new binary fuse filter with 8-bit
+// fingerprints, for a false positive rate of about 0.4%.
+func NewBinaryFuseFilter() Filter {
+	return binaryFuseFilter{}
+}
@@ -0,0 +1,211 @@
+package go_tsmm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"unsafe"
+
+	"github.com/breeze-go-rust/go-tsmm/errors"
+	"github.com/breeze-go-rust/go-tsmm/internal/common"
+)
+
+// 空闲列表页：页头 | 空闲页数(8B) | 空闲页号... | pending 组数(8B) | {释放事务(8B) 页数(8B) 页号...}... |
+// value log 文件数(8B) | {文件号(8B) 失效字节数(8B)}...，页头 size 为页体长度。
+// pending 页按释放它的事务分组保存，重新打开后仍由 meta 环中保留的版本固定，版本移出环后才转为空闲。
+// value log 的失效统计与空闲列表同属提交的状态，一起写入，旧版本的页没有这一段。
+// meta 记录最新的空闲列表页，每次提交写入新页并释放旧页
+
+// persistedFreelist 已提交的空闲列表页解析出的内容
+type persistedFreelist struct {
+	free     common.Pgids
+	pending  map[common.TxID]common.Pgids
+	discards map[uint32]int64 // 旧版本的页没有失效统计时为 nil
+	span     pageSpan
+}
+
+// readFreelist 按 meta 记录的空闲列表页恢复 freelist，旧版本未保存空闲列表时 freelist 为空
+func (b *BTree) readFreelist(meta *common.Meta) error {
+	fl, err := b.parseFreelist(meta)
+	if err != nil || fl == nil {
+		return err
+	}
+	b.loadFreelist(fl)
+	if fl.discards != nil {
+		b.vlog.SetDiscards(fl.discards)
+	}
+	return nil
+}
+
+// parseFreelist 读取并解析 meta 记录的空闲列表页，不修改内存中的 freelist，未保存空闲列表时返回 nil
+func (b *BTree) parseFreelist(meta *common.Meta) (*persistedFreelist, error) {
+	if !meta.IsFreelistPersisted() {
+		return nil, nil
+	}
+	id := meta.Freelist()
+	buf, err := b.pageMgr.readFull(id)
+	if err != nil {
+		return nil, fmt.Errorf("read freelist page %d: %w", id, err)
+	}
+	defer b.pageMgr.free(buf)
+	p := (*common.Page)(unsafe.Pointer(&buf[0]))
+	hdr := int(common.PageHeaderSize)
+	if !p.IsFreelistPage() || hdr+int(p.Size()) > len(buf) {
+		return nil, fmt.Errorf("freelist page %d: %w", id, errors.ErrInvalid)
+	}
+	data := buf[hdr : hdr+int(p.Size())]
+	next := func() (uint64, bool) {
+		if len(data) < 8 {
+			return 0, false
+		}
+		v := binary.LittleEndian.Uint64(data)
+		data = data[8:]
+		return v, true
+	}
+	ids := func(n uint64) (common.Pgids, bool) {
+		if uint64(len(data))/8 < n {
+			return nil, false
+		}
+		res := make(common.Pgids, n)
+		for i := range res {
+			v, _ := next()
+			res[i] = common.Pgid(v)
+		}
+		return res, true
+	}
+	n, ok := next()
+	free, ok2 := ids(n)
+	groups, ok3 := next()
+	if !ok || !ok2 || !ok3 {
+		return nil, fmt.Errorf("freelist page %d: %w", id, errors.ErrInvalid)
+	}
+	fl := &persistedFreelist{
+		free:    free,
+		pending: make(map[common.TxID]common.Pgids, groups),
+		span:    pageSpan{id: id, count: int(p.Overflow()) + 1},
+	}
+	for i := uint64(0); i < groups; i++ {
+		txid, ok := next()
+		n, ok2 := next()
+		pending, ok3 := ids(n)
+		if !ok || !ok2 || !ok3 {
+			return nil, fmt.Errorf("freelist page %d: %w", id, errors.ErrInvalid)
+		}
+		fl.pending[common.TxID(txid)] = append(fl.pending[common.TxID(txid)], pending...)
+	}
+	if len(data) > 0 {
+		n, ok := next()
+		if !ok || uint64(len(data))/16 < n {
+			return nil, fmt.Errorf("freelist page %d: %w", id, errors.ErrInvalid)
+		}
+		fl.discards = make(map[uint32]int64, n)
+		for i := uint64(0); i < n; i++ {
+			fid, _ := next()
+			size, _ := next()
+			fl.discards[uint32(fid)] = int64(size)
+		}
+	}
+	return fl, nil
+}
+
+// loadFreelist 用解析出的空闲列表替换内存中的空闲页与 pending 页，只读事务的登记保留
+func (b *BTree) loadFreelist(fl *persistedFreelist) {
+	b.allocLock.Lock()
+	defer b.allocLock.Unlock()
+	for txid := range b.freelist.PendingPages() {
+		b.freelist.Rollback(txid)
+	}
+	b.freelist.Init(fl.free)
+	for txid, pending := range fl.pending {
+		for _, pid := range pending {
+			b.freelist.Free(txid, common.NewPage(pid, 0, 0, 0))
+		}
+	}
+	b.freelistSpan = fl.span
+}
+
+// writeFreelist 在提交写出数据页之前释放旧的空闲列表页，并把当前 freelist 写入新分配的页。
+// 本次提交分配后又释放、未被复用的页提交后才交给 freelist，value log 本次失效的记录
+// 提交后才计入统计，这里都按提交后的状态写入
+func (b *BTree) writeFreelist() pageSpan {
+	if b.freelistSpan.count > 0 {
+		b.freePage(common.NewPage(b.freelistSpan.id, 0, 0, uint32(b.freelistSpan.count-1)))
+	}
+	hdr := int(common.PageHeaderSize)
+	pageSize := int(b.pageSize())
+	discards := b.vlog.Discards()
+	size := b.freelistSize(len(discards))
+	p := b.allocate((hdr + size + pageSize - 1) / pageSize)
+	span := pageSpan{id: p.Id(), count: int(p.Overflow()) + 1}
+
+	b.allocLock.Lock()
+	pending := b.freelist.PendingPages()
+	all := make(common.Pgids, b.freelist.Count())
+	b.freelist.Copyall(all)
+	b.allocLock.Unlock()
+	pendingSet := make(map[common.Pgid]struct{})
+	for _, ids := range pending {
+		for _, id := range ids {
+			pendingSet[id] = struct{}{}
+		}
+	}
+	free := make(common.Pgids, 0, len(all)-len(pendingSet))
+	for _, id := range all {
+		if _, ok := pendingSet[id]; !ok {
+			free = append(free, id)
+		}
+	}
+	// 与 resetTxPages 一致，登记为下一个事务释放的页
+	if len(b.txFree) > 0 {
+		next := b.ctx.meta.Txid() + 2
+		for _, s := range b.txFree {
+			for i := 0; i < s.count; i++ {
+				pending[next] = append(pending[next], s.id+common.Pgid(i))
+			}
+		}
+	}
+	txids := make([]common.TxID, 0, len(pending))
+	for txid := range pending {
+		txids = append(txids, txid)
+	}
+	sort.Slice(txids, func(i, j int) bool { return txids[i] < txids[j] })
+
+	body := make([]byte, 0, size)
+	body = binary.LittleEndian.AppendUint64(body, uint64(len(free)))
+	for _, id := range free {
+		body = binary.LittleEndian.AppendUint64(body, uint64(id))
+	}
+	body = binary.LittleEndian.AppendUint64(body, uint64(len(txids)))
+	for _, txid := range txids {
+		body = binary.LittleEndian.AppendUint64(body, uint64(txid))
+		body = binary.LittleEndian.AppendUint64(body, uint64(len(pending[txid])))
+		for _, id := range pending[txid] {
+			body = binary.LittleEndian.AppendUint64(body, uint64(id))
+		}
+	}
+	fids := make([]uint32, 0, len(discards))
+	for fid := range discards {
+		fids = append(fids, fid)
+	}
+	sort.Slice(fids, func(i, j int) bool { return fids[i] < fids[j] })
+	body = binary.LittleEndian.AppendUint64(body, uint64(len(fids)))
+	for _, fid := range fids {
+		body = binary.LittleEndian.AppendUint64(body, uint64(fid))
+		body = binary.LittleEndian.AppendUint64(body, uint64(discards[fid]))
+	}
+	p.SetFlags(common.FreelistPageFlag)
+	p.SetSize(uint32(len(body)))
+	copy(common.UnsafeByteSlice(unsafe.Pointer(p), common.PageHeaderSize, 0, len(body)), body)
+	return span
+}
+
+// freelistSize 空闲列表页体长度的上界，新页分配后只会变短，vlogFiles 为失效统计的文件数
+func (b *BTree) freelistSize(vlogFiles int) int {
+	b.allocLock.Lock()
+	defer b.allocLock.Unlock()
+	n := b.freelist.Count()
+	for _, s := range b.txFree {
+		n += s.count
+	}
+	return 16 + 8*n + 16*(len(b.freelist.PendingPages())+1) + 8 + 16*vlogFiles
+}
@@ -0,0 +1,99 @@
+package go_tsmm
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/breeze-go-rust/go-tsmm/util"
+)
+
+func openFreelistTree(t *testing.T, dir string) *BTree {
+	t.Helper()
+	b, err := NewBTree(false, false, true, dir, "", 3, 0, "", 0, 0, &Options{PageSize: 4096})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func putRound(t *testing.T, b *BTree, round, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		key := append(util.AccountPrefix(), []byte(fmt.Sprintf("key-%06d", i))...)
+		if err := b.Put(key, []byte(fmt.Sprintf("value-%d-%d", round, i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestFreelistSurvivesReopen 空闲页、pending 页与各保留版本固定的页在重新打开后保持不变
+func TestFreelistSurvivesReopen(t *testing.T) {
+	dir := t.TempDir()
+	b := openFreelistTree(t, dir)
+	for round := 0; round < 5; round++ {
+		putRound(t, b, round, 5000)
+	}
+	before := b.Stats()
+	pinned := b.PinnedPages()
+	if before.FreeCount+before.PendingCount == 0 {
+		t.Fatal("no freed pages after overwriting every key")
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b = openFreelistTree(t, dir)
+	defer b.Close()
+	after := b.Stats()
+	if after.FreeCount != before.FreeCount || after.PendingCount != before.PendingCount {
+		t.Fatalf("free/pending after reopen = %d/%d, want %d/%d",
+			after.FreeCount, after.PendingCount, before.FreeCount, before.PendingCount)
+	}
+	if got := b.PinnedPages(); !reflect.DeepEqual(got, pinned) {
+		t.Fatalf("pinned pages after reopen = %v, want %v", got, pinned)
+	}
+}
+
+// TestPageFileBoundedAcrossReopen 每次提交后重新打开，覆盖写入不会让页文件无限增长
+func TestPageFileBoundedAcrossReopen(t *testing.T) {
+	dir := t.TempDir()
+	var hwm []uint64
+	for round := 0; round < 12; round++ {
+		b := openFreelistTree(t, dir)
+		putRound(t, b, round, 3000)
+		hwm = append(hwm, uint64(b.ctx.meta.Pgid()))
+		if err := b.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 前几轮 meta 环尚未填满，之后释放的页应当被复用
+	if last, mid := hwm[len(hwm)-1], hwm[len(hwm)/2]; last > mid {
+		t.Fatalf("page file keeps growing across reopen: %v", hwm)
+	}
+}
+
+// TestValueLogDiscardSurvivesReopen value log 的失效统计随提交持久化，重新打开后不变
+func TestValueLogDiscardSurvivesReopen(t *testing.T) {
+	dir := t.TempDir()
+	b := openFreelistTree(t, dir)
+	for round := 0; round < 3; round++ {
+		putRound(t, b, round, 2000)
+	}
+	before := b.Stats().ValueLog
+	if before.Discard == 0 {
+		t.Fatal("no discarded records after overwriting every key")
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b = openFreelistTree(t, dir)
+	defer b.Close()
+	if after := b.Stats().ValueLog; after != before {
+		t.Fatalf("value log stats after reopen = %+v, want %+v", after, before)
+	}
+}
@@ -7,6 +7,13 @@ toolchain go1.24.5
 require (
 	github.com/klauspost/compress v1.18.0
 	github.com/panjf2000/ants/v2 v2.11.3
+	github.com/pierrec/lz4/v4 v4.1.22
+	github.com/zeebo/blake3 v0.2.4
+	golang.org/x/crypto v0.36.0
 )
 
-require golang.org/x/sync v0.17.0 // indirect
+require (
+	github.com/klauspost/cpuid/v2 v2.0.12 // indirect
+	golang.org/x/sync v0.17.0 // indirect
+	golang.org/x/sys v0.31.0 // indirect
+)
@@ -1,10 +1,12 @@
 package go_tsmm
 
+import "github.com/breeze-go-rust/go-tsmm/internal/common"
+
 // Batch BTree Put Buffer
 type Batch interface {
 	Put(key []byte, value []byte) error
 	Get(key []byte) ([]byte, error)
 	Delete(key []byte) error
 	Size() int
-	Dump() KVS
+	Dump() common.Inodes
 }
@@ -9,9 +9,10 @@ type Inode struct {
 	flags    uint32
 	pgid     Pgid
 	overflow uint32
+	filter   Pgid
 	key      []byte
 	value    []byte
-	hash     [20]byte
+	hash     []byte
 }
 
 type Inodes []*Inode
@@ -47,6 +48,16 @@ func (in *Inode) Value() []byte {
 func (in *Inode) SetValue(value []byte) {
 	in.value = value
 }
+
+// Filter returns the filter page of the leaf a branch inode points to.
+func (in *Inode) Filter() Pgid {
+	return in.filter
+}
+
+func (in *Inode) SetFilter(id Pgid) {
+	in.filter = id
+}
+
 func (in *Inode) Overflow() uint32 {
 	return in.overflow
 }
@@ -56,27 +67,33 @@ func (in *Inode) SetOverflow(overflow uint32) {
 }
 
 func (in *Inode) Hash() []byte {
-	return in.hash[:]
+	return in.hash
 }
 
+// SetHash copies hash, inodes copied by value must not share it.
 func (in *Inode) SetHash(hash []byte) {
-	copy(in.hash[:], hash)
+	in.hash = append([]byte(nil), hash...)
 }
 
 func ReadInodeFromPage(p *Page) Inodes {
 	inodes := make(Inodes, int(p.Count()))
 	isLeaf := p.IsLeafPage()
 	for i := 0; i < int(p.Count()); i++ {
-		inode := inodes[i]
+		inode := &Inode{}
+		inodes[i] = inode
 		if isLeaf {
 			elem := p.LeafPageElement(uint16(i))
 			inode.SetFlags(elem.Flags())
 			inode.SetKey(elem.Key())
 			inode.SetValue(elem.Value())
+			inode.hash = elem.Hash()
 		} else {
 			elem := p.BranchPageElement(uint16(i))
 			inode.SetPgid(elem.Pgid())
+			inode.SetOverflow(elem.Overflow())
+			inode.SetFilter(elem.Filter())
 			inode.SetKey(elem.Key())
+			inode.hash = elem.Hash()
 		}
 		Assert(len(inode.Key()) > 0, "read: zero-length inode key")
 	}
@@ -93,7 +110,7 @@ func WriteInodeToPage(inodes Inodes, p *Page) uint32 {
 
 		// Create a slice to write into of needed size and advance
 		// byte pointer for next iteration.
-		sz := len(item.Key()) + len(item.Value())
+		sz := len(item.Key()) + len(item.Value()) + len(item.Hash())
 		b := UnsafeByteSlice(unsafe.Pointer(p), off, 0, sz)
 		off += uintptr(sz)
 
@@ -104,16 +121,21 @@ func WriteInodeToPage(inodes Inodes, p *Page) uint32 {
 			elem.SetFlags(item.Flags())
 			elem.SetKsize(uint32(len(item.Key())))
 			elem.SetVsize(uint32(len(item.Value())))
+			elem.SetHsize(uint32(len(item.Hash())))
 		} else {
 			elem := p.BranchPageElement(uint16(i))
 			elem.SetPos(uint32(uintptr(unsafe.Pointer(&b[0])) - uintptr(unsafe.Pointer(elem))))
 			elem.SetKsize(uint32(len(item.Key())))
 			elem.SetPgid(item.Pgid())
+			elem.SetOverflow(item.Overflow())
+			elem.SetFilter(item.Filter())
+			elem.SetHsize(uint32(len(item.Hash())))
 			Assert(elem.Pgid() != p.Id(), "write: circular dependency occurred")
 		}
 		// Write data for the element to the end of the page.
 		l := copy(b, item.Key())
-		copy(b[l:], item.Value())
+		l += copy(b[l:], item.Value())
+		copy(b[l:], item.Hash())
 	}
 	return uint32(off)
 }
@@ -121,7 +143,7 @@ func WriteInodeToPage(inodes Inodes, p *Page) uint32 {
 func UsedSpaceInPage(inodes Inodes, p *Page) uint32 {
 	off := unsafe.Sizeof(*p) + p.PageElementSize()*uintptr(len(inodes))
 	for _, item := range inodes {
-		sz := len(item.Key()) + len(item.Value())
+		sz := len(item.Key()) + len(item.Value()) + len(item.Hash())
 		off += uintptr(sz)
 	}
 
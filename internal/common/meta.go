@@ -14,10 +14,12 @@ type Meta struct {
 	version  uint32
 	pageSize uint32
 	flags    uint32
+	hashType uint32 // merkle hash function, see hasher.HashType
 	root     InBTree
 	freelist Pgid
 	pgid     Pgid
 	txid     TxID
+	dict     Pgid // latest compression dictionary page, 0 if none
 	checksum uint64
 }
 
@@ -95,6 +97,14 @@ func (m *Meta) SetFlags(v uint32) {
 	m.flags = v
 }
 
+func (m *Meta) HashType() uint32 {
+	return m.hashType
+}
+
+func (m *Meta) SetHashType(v uint32) {
+	m.hashType = v
+}
+
 func (m *Meta) SetRootBucket(b InBTree) {
 	m.root = b
 }
@@ -139,6 +149,14 @@ func (m *Meta) DecTxid() {
 	m.txid -= 1
 }
 
+func (m *Meta) Dict() Pgid {
+	return m.dict
+}
+
+func (m *Meta) SetDict(id Pgid) {
+	m.dict = id
+}
+
 func (m *Meta) Checksum() uint64 {
 	return m.checksum
 }
@@ -156,10 +174,12 @@ func (m *Meta) Print(w io.Writer) {
 	fmt.Fprintf(w, "Version:    %d\n", m.version)
 	fmt.Fprintf(w, "Page Size:  %d bytes\n", m.pageSize)
 	fmt.Fprintf(w, "Flags:      %08x\n", m.flags)
+	fmt.Fprintf(w, "Hash Type:  %#x\n", m.hashType)
 	fmt.Fprintf(w, "Root:       <pgid=%d>\n", m.root.root)
 	fmt.Fprintf(w, "Freelist:   <pgid=%d>\n", m.freelist)
 	fmt.Fprintf(w, "HWM:        <pgid=%d>\n", m.pgid)
 	fmt.Fprintf(w, "Txn ID:     %d\n", m.txid)
+	fmt.Fprintf(w, "Dict:       <pgid=%d>\n", m.dict)
 	fmt.Fprintf(w, "Checksum:   %016x\n", m.checksum)
 	fmt.Fprintf(w, "\n")
 }
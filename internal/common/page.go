@@ -7,7 +7,9 @@ import (
 	"unsafe"
 )
 
-const HashSize = 20
+// MaxHashSize is the largest merkle hash the page header can hold; the hash
+// length in use is set by the hash type recorded in the meta.
+const MaxHashSize = 32
 
 type PageVersion uint32
 
@@ -26,6 +28,8 @@ const (
 	LeafPageFlag     = 0x02
 	MetaPageFlag     = 0x04
 	FreelistPageFlag = 0x10
+	FilterPageFlag   = 0x20
+	DictPageFlag     = 0x40
 )
 
 const (
@@ -36,13 +40,16 @@ const (
 type Pgid uint64
 
 type Page struct {
-	version  uint32         // 4B
-	id       Pgid           // 8B
-	flags    uint16         // 2B
-	count    uint16         // 2B
-	overflow uint32         // 4B
-	size     uint32         // 4B
-	hash     [HashSize]byte // 20B merkle hash of page
+	version  uint32            // 4B
+	codec    uint8             // 1B codec of the page body, 0 if uncompressed
+	hsize    uint8             // 1B length of hash
+	_        [2]byte           // 2B padding
+	id       Pgid              // 8B
+	flags    uint16            // 2B
+	count    uint16            // 2B
+	overflow uint32            // 4B
+	size     uint32            // 4B
+	hash     [MaxHashSize]byte // 32B merkle hash of page, the first hsize bytes are used
 }
 
 func NewPage(id Pgid, flags, count uint16, overflow uint32) *Page {
@@ -52,7 +59,6 @@ func NewPage(id Pgid, flags, count uint16, overflow uint32) *Page {
 		flags:    flags,
 		count:    count,
 		overflow: overflow,
-		hash:     [20]byte{},
 	}
 }
 
@@ -66,6 +72,10 @@ func (p *Page) Typ() string {
 		return "meta"
 	} else if p.IsFreelistPage() {
 		return "freelist"
+	} else if p.IsFilterPage() {
+		return "filter"
+	} else if p.IsDictPage() {
+		return "dict"
 	}
 	return fmt.Sprintf("unknown<%02x>", p.flags)
 }
@@ -86,6 +96,14 @@ func (p *Page) IsFreelistPage() bool {
 	return p.flags == FreelistPageFlag
 }
 
+func (p *Page) IsFilterPage() bool {
+	return p.flags == FilterPageFlag
+}
+
+func (p *Page) IsDictPage() bool {
+	return p.flags == DictPageFlag
+}
+
 // Meta returns a pointer to the metadata section of the page.
 func (p *Page) Meta() *Meta {
 	return (*Meta)(UnsafeAdd(unsafe.Pointer(p), unsafe.Sizeof(*p)))
@@ -97,7 +115,9 @@ func (p *Page) FastCheck(id Pgid) {
 	Assert(p.IsBranchPage() ||
 		p.IsLeafPage() ||
 		p.IsMetaPage() ||
-		p.IsFreelistPage(),
+		p.IsFreelistPage() ||
+		p.IsFilterPage() ||
+		p.IsDictPage(),
 		"page %v: has unexpected type/flags: %x", p.id, p.flags)
 }
 
@@ -187,6 +207,15 @@ func (p *Page) SetId(target Pgid) {
 	p.id = target
 }
 
+// Codec returns the compression codec of the page body.
+func (p *Page) Codec() uint8 {
+	return p.codec
+}
+
+func (p *Page) SetCodec(v uint8) {
+	p.codec = v
+}
+
 func (p *Page) Size() uint32 {
 	return p.size
 }
@@ -220,10 +249,12 @@ func (p *Page) SetOverflow(target uint32) {
 }
 
 func (p *Page) SetHash(hash []byte) {
-	copy(p.hash[:], hash)
+	p.hsize = uint8(copy(p.hash[:], hash))
 }
-func (p *Page) GetHash() [20]byte {
-	return p.hash
+
+// GetHash returns the merkle hash of the page, referencing the page buffer.
+func (p *Page) GetHash() []byte {
+	return p.hash[:p.hsize]
 }
 
 func (p *Page) String() string {
@@ -242,6 +273,8 @@ type branchPageElement struct {
 	ksize    uint32
 	pgid     Pgid
 	overflow uint32
+	hsize    uint32 // 子页哈希的长度，哈希紧跟在 key 之后
+	filter   Pgid   // 子叶子页的过滤器页，0 表示没有
 }
 
 func (n *branchPageElement) Pos() uint32 {
@@ -268,11 +301,41 @@ func (n *branchPageElement) SetPgid(v Pgid) {
 	n.pgid = v
 }
 
+func (n *branchPageElement) Overflow() uint32 {
+	return n.overflow
+}
+
+func (n *branchPageElement) SetOverflow(v uint32) {
+	n.overflow = v
+}
+
+func (n *branchPageElement) Filter() Pgid {
+	return n.filter
+}
+
+func (n *branchPageElement) SetFilter(v Pgid) {
+	n.filter = v
+}
+
+func (n *branchPageElement) Hsize() uint32 {
+	return n.hsize
+}
+
+func (n *branchPageElement) SetHsize(v uint32) {
+	n.hsize = v
+}
+
 // Key returns a byte slice of the node key.
 func (n *branchPageElement) Key() []byte {
 	return UnsafeByteSlice(unsafe.Pointer(n), 0, int(n.pos), int(n.pos)+int(n.ksize))
 }
 
+// Hash returns a byte slice of the child page hash.
+func (n *branchPageElement) Hash() []byte {
+	i := int(n.pos) + int(n.ksize)
+	return UnsafeByteSlice(unsafe.Pointer(n), 0, i, i+int(n.hsize))
+}
+
 // leafPageElement represents a node on a leaf page.
 type leafPageElement struct {
 	flags uint32
@@ -288,7 +351,7 @@ func NewLeafPageElement(flags, pos, ksize, vsize, hsize uint32) *leafPageElement
 		pos:   pos,
 		ksize: ksize,
 		vsize: vsize,
-		hsize: 20,
+		hsize: hsize,
 	}
 }
 
@@ -346,7 +409,7 @@ func (n *leafPageElement) Value() []byte {
 	return UnsafeByteSlice(unsafe.Pointer(n), 0, i, j)
 }
 
-// Hash returns a byte slice of the node value.
+// Hash returns a byte slice of the key/value hash.
 func (n *leafPageElement) Hash() []byte {
 	i := int(n.pos) + int(n.ksize) + int(n.vsize)
 	j := i + int(n.hsize)
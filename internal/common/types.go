@@ -10,7 +10,7 @@ import (
 const MaxMmapStep = 1 << 30 // 1GB
 
 // Version represents the data file format version.
-const Version uint32 = 2
+const Version uint32 = 4
 
 // Magic represents a marker value to indicate that a file is a Bolt DB.
 const Magic uint32 = 0xED0CDAED
@@ -32,5 +32,16 @@ const (
 // DefaultPageSize is the default page size for db which is set to the OS page size.
 var DefaultPageSize = os.Getpagesize()
 
+// MinPageSize and MaxPageSize bound the page size chosen at creation.
+const (
+	MinPageSize = 4 * 1024
+	MaxPageSize = 64 * 1024
+)
+
+// ValidPageSize reports whether size is a power of two within [MinPageSize, MaxPageSize].
+func ValidPageSize(size uint32) bool {
+	return size >= MinPageSize && size <= MaxPageSize && size&(size-1) == 0
+}
+
 // TxID represents the internal transaction identifier.
 type TxID uint64
@@ -1,8 +1,44 @@
 package compress
 
+import (
+	"fmt"
+	"sync"
+)
+
+// Codec identifies the compression of a page body. It is stored in the
+// page header, so existing values must never be renumbered.
+type Codec uint8
+
+const (
+	CodecNone Codec = iota
+	CodecSnappy
+	CodecZSTD
+	CodecLZ4
+	// CodecZSTDDict pages need the dictionary to decode, see ZSTDDictCompressor.
+	CodecZSTDDict
+)
+
+func (c Codec) String() string {
+	switch c {
+	case CodecNone:
+		return "direct"
+	case CodecSnappy:
+		return "snappy"
+	case CodecZSTD:
+		return "zstd"
+	case CodecLZ4:
+		return "lz4"
+	case CodecZSTDDict:
+		return "zstd-dict"
+	}
+	return fmt.Sprintf("unknown<%d>", uint8(c))
+}
+
 type Compressor interface {
 	Encode(data, src []byte) []byte
 	Decode(data, src []byte) ([]byte, error)
+	// Codec returns the id recorded in pages encoded by this compressor.
+	Codec() Codec
 }
 
 func NewCompressor(cType string) Compressor {
@@ -11,9 +47,45 @@ func NewCompressor(cType string) Compressor {
 		return NewSnappyCompressor()
 	case "zstd":
 		return NewZSTDCompressor()
+	case "lz4":
+		return NewLZ4Compressor()
+	case "zstd-dict":
+		// Plain zstd until a dictionary has been trained and loaded.
+		return NewZSTDCompressor()
 	case "direct":
 		return NewDirectCompressor()
 	default:
 		return NewDirectCompressor()
 	}
 }
+
+var (
+	decodersMu sync.Mutex
+	decoders   = make(map[Codec]Compressor)
+)
+
+// Decode decodes src written with codec c, independent of the codec
+// currently configured, appending to data. CodecZSTDDict is not handled
+// here, as it needs the dictionaries of the store.
+func Decode(c Codec, data, src []byte) ([]byte, error) {
+	decodersMu.Lock()
+	d, ok := decoders[c]
+	if !ok {
+		switch c {
+		case CodecNone:
+			d = NewDirectCompressor()
+		case CodecSnappy:
+			d = NewSnappyCompressor()
+		case CodecZSTD:
+			d = NewZSTDCompressor()
+		case CodecLZ4:
+			d = NewLZ4Compressor()
+		default:
+			decodersMu.Unlock()
+			return nil, fmt.Errorf("unknown codec %d", uint8(c))
+		}
+		decoders[c] = d
+	}
+	decodersMu.Unlock()
+	return d.Decode(data, src)
+}
@@ -0,0 +1,152 @@
+package compress
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// pageLike resembles a leaf page body: repeated prefixes with changing numbers.
+func pageLike(seed, n int) []byte {
+	var buf bytes.Buffer
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&buf, "-account%08d|value-%d-%d|", seed*n+i, seed, i)
+	}
+	return buf.Bytes()
+}
+
+func TestCompressorRoundTrip(t *testing.T) {
+	random := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(random)
+	inputs := map[string][]byte{
+		"empty":        {},
+		"page":         pageLike(1, 100),
+		"incompressed": random,
+	}
+	for _, c := range []Compressor{
+		NewDirectCompressor(),
+		NewSnappyCompressor(),
+		NewZSTDCompressor(),
+		NewLZ4Compressor(),
+	} {
+		for name, src := range inputs {
+			enc := c.Encode(nil, src)
+			got, err := c.Decode(nil, enc)
+			if err != nil {
+				t.Fatalf("%v/%s: decode: %v", c.Codec(), name, err)
+			}
+			if !bytes.Equal(got, src) {
+				t.Fatalf("%v/%s: round trip mismatch", c.Codec(), name)
+			}
+			// Decoding by codec does not depend on the configured compressor.
+			got, err = Decode(c.Codec(), nil, enc)
+			if err != nil || !bytes.Equal(got, src) {
+				t.Fatalf("%v/%s: Decode by codec = %v", c.Codec(), name, err)
+			}
+		}
+	}
+}
+
+// TestZSTDDecodeDecompresses checks that Decode decompresses rather than
+// encoding the input again.
+func TestZSTDDecodeDecompresses(t *testing.T) {
+	c := NewZSTDCompressor()
+	src := pageLike(2, 200)
+	enc := c.Encode(nil, src)
+	if len(enc) >= len(src) {
+		t.Fatalf("zstd did not compress: %d >= %d", len(enc), len(src))
+	}
+	got, err := c.Decode(nil, enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, src) {
+		t.Fatalf("decoded %d bytes, want the %d source bytes", len(got), len(src))
+	}
+	if _, err := c.Decode(nil, src); err == nil {
+		t.Fatal("decoding data that is not a zstd frame succeeded")
+	}
+}
+
+func TestLZ4RejectsCorruptInput(t *testing.T) {
+	c := NewLZ4Compressor()
+	enc := c.Encode(nil, pageLike(3, 100))
+	if _, err := c.Decode(nil, nil); err == nil {
+		t.Fatal("decoding an empty block succeeded")
+	}
+	if _, err := c.Decode(nil, enc[:len(enc)/2]); err == nil {
+		t.Fatal("decoding a truncated block succeeded")
+	}
+}
+
+func TestCodecTags(t *testing.T) {
+	for cType, want := range map[string]Codec{
+		"direct":    CodecNone,
+		"":          CodecNone,
+		"snappy":    CodecSnappy,
+		"zstd":      CodecZSTD,
+		"lz4":       CodecLZ4,
+		"zstd-dict": CodecZSTD, // plain zstd until a dictionary is trained
+	} {
+		if got := NewCompressor(cType).Codec(); got != want {
+			t.Fatalf("NewCompressor(%q).Codec() = %v, want %v", cType, got, want)
+		}
+	}
+	if _, err := Decode(CodecZSTDDict, nil, []byte{1}); err == nil {
+		t.Fatal("Decode of a dictionary page without the dictionary succeeded")
+	}
+	if _, err := Decode(Codec(200), nil, []byte{1}); err == nil {
+		t.Fatal("Decode of an unknown codec succeeded")
+	}
+}
+
+func trainDict(t *testing.T, seed int) []byte {
+	t.Helper()
+	var samples [][]byte
+	for i := 0; i < 64; i++ {
+		samples = append(samples, pageLike(seed*1000+i, 40))
+	}
+	d, err := TrainZSTDDict(samples, 4<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestZSTDDictRoundTrip(t *testing.T) {
+	d1 := trainDict(t, 1)
+	old, err := NewZSTDDictCompressor(d1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := pageLike(5, 40)
+	encOld := old.Encode(nil, src)
+	if got, err := old.Decode(nil, encOld); err != nil || !bytes.Equal(got, src) {
+		t.Fatalf("round trip with the dictionary: %v", err)
+	}
+
+	// A compressor with the new dictionary still reads pages of the old one.
+	d2 := trainDict(t, 2)
+	c, err := NewZSTDDictCompressor(d2, d1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encNew := c.Encode(nil, src)
+	for name, enc := range map[string][]byte{"new": encNew, "old": encOld} {
+		if got, err := c.Decode(nil, enc); err != nil || !bytes.Equal(got, src) {
+			t.Fatalf("decode page of the %s dictionary: %v", name, err)
+		}
+	}
+
+	// A missing or mismatched dictionary fails to decode.
+	if _, err := NewZSTDCompressor().Decode(nil, encNew); err == nil {
+		t.Fatal("decoding a dictionary frame without the dictionary succeeded")
+	}
+	if _, err := old.Decode(nil, encNew); err == nil {
+		t.Fatal("decoding a dictionary frame with another dictionary succeeded")
+	}
+	if _, err := NewZSTDDictCompressor([]byte("not a dictionary")); err == nil {
+		t.Fatal("loading an invalid dictionary succeeded")
+	}
+}
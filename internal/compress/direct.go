@@ -10,5 +10,9 @@ func (DirectCompressor) Encode(data, src []byte) []byte {
 }
 
 func (DirectCompressor) Decode(data, src []byte) ([]byte, error) {
-	return src, nil
+	return append(data, src...), nil
+}
+
+func (DirectCompressor) Codec() Codec {
+	return CodecNone
 }
@@ -0,0 +1,66 @@
+package compress
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/pierrec/lz4/v4"
+)
+
+// LZ4Compressor writes a raw LZ4 block prefixed with the uvarint length of
+// the uncompressed input. A zero length marks input that LZ4 could not
+// compress, which is then stored as is after the prefix.
+type LZ4Compressor struct{}
+
+func NewLZ4Compressor() *LZ4Compressor {
+	return &LZ4Compressor{}
+}
+
+func (LZ4Compressor) Encode(data, src []byte) []byte {
+	start := len(data)
+	data = grow(data, binary.MaxVarintLen64+lz4.CompressBlockBound(len(src)))
+	n := binary.PutUvarint(data[start:], uint64(len(src)))
+	m, err := lz4.CompressBlock(src, data[start+n:], nil)
+	// Empty input still encodes to a token byte, but a zero length means
+	// stored as is, so it has to be stored as is.
+	if err != nil || m == 0 || len(src) == 0 {
+		n = binary.PutUvarint(data[start:], 0)
+		return append(data[:start+n], src...)
+	}
+	return data[:start+n+m]
+}
+
+func (LZ4Compressor) Decode(data, src []byte) ([]byte, error) {
+	size, n := binary.Uvarint(src)
+	if n <= 0 {
+		return nil, errors.New("lz4: invalid length prefix")
+	}
+	if size == 0 {
+		return append(data, src[n:]...), nil
+	}
+	start := len(data)
+	data = grow(data, int(size))
+	m, err := lz4.UncompressBlock(src[n:], data[start:start+int(size)])
+	if err != nil {
+		return nil, fmt.Errorf("lz4: %w", err)
+	}
+	if m != int(size) {
+		return nil, fmt.Errorf("lz4: decoded %d bytes, want %d", m, size)
+	}
+	return data[:start+m], nil
+}
+
+func (LZ4Compressor) Codec() Codec {
+	return CodecLZ4
+}
+
+// grow extends data by n bytes, reallocating if the capacity is too small.
+func grow(data []byte, n int) []byte {
+	if cap(data)-len(data) < n {
+		buf := make([]byte, len(data), len(data)+n)
+		copy(buf, data)
+		data = buf
+	}
+	return data[:len(data)+n]
+}
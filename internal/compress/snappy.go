@@ -16,3 +16,7 @@ func (s *SnappyCompressor) Encode(data, src []byte) []byte {
 func (s *SnappyCompressor) Decode(data, src []byte) ([]byte, error) {
 	return snappy.Decode(data, src)
 }
+
+func (s *SnappyCompressor) Codec() Codec {
+	return CodecSnappy
+}
@@ -8,7 +8,7 @@ type ZSTDCompressor struct {
 }
 
 func NewZSTDCompressor() *ZSTDCompressor {
-	writer, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault), zstd.WithEncoderConcurrency(0))
+	writer, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault))
 	reader, _ := zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
 	return &ZSTDCompressor{
 		Encoder: writer,
@@ -20,5 +20,9 @@ func (zsc *ZSTDCompressor) Encode(data, src []byte) []byte {
 }
 
 func (zsc *ZSTDCompressor) Decode(data, src []byte) ([]byte, error) {
-	return zsc.Encoder.EncodeAll(src, data), nil
+	return zsc.Decoder.DecodeAll(src, data)
+}
+
+func (zsc *ZSTDCompressor) Codec() Codec {
+	return CodecZSTD
 }
@@ -0,0 +1,66 @@
+package compress
+
+import (
+	"fmt"
+
+	"github.com/klauspost/compress/dict"
+	"github.com/klauspost/compress/zstd"
+)
+
+// DefaultDictSize is the maximum size of a trained dictionary.
+const DefaultDictSize = 16 << 10
+
+// ZSTDDictCompressor compresses with a trained zstd dictionary. Every frame
+// records the id of its dictionary, so the decoder reads pages written with
+// any of the dictionaries it was created with.
+type ZSTDDictCompressor struct {
+	Encoder *zstd.Encoder
+	Decoder *zstd.Decoder
+}
+
+// NewZSTDDictCompressor encodes with dict and decodes with dict or any of
+// the older dictionaries.
+func NewZSTDDictCompressor(dict []byte, older ...[]byte) (*ZSTDDictCompressor, error) {
+	writer, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault), zstd.WithEncoderDict(dict))
+	if err != nil {
+		return nil, fmt.Errorf("zstd: load dictionary: %w", err)
+	}
+	reader, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(0), zstd.WithDecoderDicts(append([][]byte{dict}, older...)...))
+	if err != nil {
+		_ = writer.Close()
+		return nil, fmt.Errorf("zstd: load dictionary: %w", err)
+	}
+	return &ZSTDDictCompressor{
+		Encoder: writer,
+		Decoder: reader,
+	}, nil
+}
+
+func (zsc *ZSTDDictCompressor) Encode(data, src []byte) []byte {
+	return zsc.Encoder.EncodeAll(src, data)
+}
+
+func (zsc *ZSTDDictCompressor) Decode(data, src []byte) ([]byte, error) {
+	return zsc.Decoder.DecodeAll(src, data)
+}
+
+func (zsc *ZSTDDictCompressor) Codec() Codec {
+	return CodecZSTDDict
+}
+
+// TrainZSTDDict builds a dictionary of at most maxSize bytes from samples,
+// which should be uncompressed page bodies.
+func TrainZSTDDict(samples [][]byte, maxSize int) ([]byte, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultDictSize
+	}
+	d, err := dict.BuildZstdDict(samples, dict.Options{
+		MaxDictSize: maxSize,
+		HashBytes:   6,
+		ZstdLevel:   zstd.SpeedDefault,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("zstd: train dictionary: %w", err)
+	}
+	return d, nil
+}
@@ -0,0 +1,180 @@
+package crashtest
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+
+	"github.com/breeze-go-rust/go-tsmm/file"
+)
+
+// Replay 把 ops[:n] 重放到新的 MemFS 上，模拟第 n 个操作之后立即掉电。
+// 写入与截断只有在前缀中其文件之后被刷盘过才保留；ops[n-1] 本身是写入时，
+// 随机保留它的一段前缀，模拟扇区被撕裂。目录操作（mkdir、create、remove、rename）
+// 视为有日志保护，总是保留
+func Replay(ops []Op, n int, rnd *rand.Rand) (*file.MemFS, error) {
+	lastSync := make(map[int]int)
+	for i := 0; i < n; i++ {
+		if ops[i].Kind == OpSync {
+			lastSync[ops[i].Inode] = i
+		}
+	}
+	// 第 i 个操作之后其文件被刷盘过，掉电后仍然保留
+	durable := func(i int) bool {
+		last, ok := lastSync[ops[i].Inode]
+		return ok && i < last
+	}
+
+	fs := file.NewMemFS()
+	names := make(map[int]string)
+	handles := make(map[int]file.Handle)
+	handle := func(inode int) (file.Handle, error) {
+		if h, ok := handles[inode]; ok {
+			return h, nil
+		}
+		h, err := fs.Open(names[inode], nil)
+		if err != nil {
+			return nil, err
+		}
+		handles[inode] = h
+		return h, nil
+	}
+	for i := 0; i < n; i++ {
+		op := ops[i]
+		var err error
+		switch op.Kind {
+		case OpMkdir:
+			err = fs.MkdirAll(op.Name)
+		case OpCreate:
+			names[op.Inode] = op.Name
+			_, err = handle(op.Inode)
+		case OpRemove:
+			err = fs.Remove(op.Name)
+		case OpRename:
+			for id, name := range names {
+				if name == op.Name {
+					names[id] = op.NewName
+				}
+			}
+			err = fs.Rename(op.Name, op.NewName)
+		case OpWrite:
+			data := op.Data
+			// 未刷盘的写入丢弃，掉电前的最后一次写入只保留一段前缀
+			if !durable(i) {
+				if i != n-1 {
+					continue
+				}
+				data = data[:rnd.Intn(len(data)+1)]
+			}
+			var h file.Handle
+			if h, err = handle(op.Inode); err == nil {
+				_, err = h.WriteAt(data, op.Offset)
+			}
+		case OpTruncate:
+			if !durable(i) {
+				continue
+			}
+			var h file.Handle
+			if h, err = handle(op.Inode); err == nil {
+				err = h.Truncate(op.Size)
+			}
+		case OpSync:
+		}
+		if err != nil {
+			return nil, fmt.Errorf("crashtest: replay op %d (%v): %w", i, op.Kind, err)
+		}
+	}
+	return fs, nil
+}
+
+// Version 标识存储的一个已提交状态
+type Version struct {
+	Txid uint64
+	Root []byte
+}
+
+func (v Version) Equal(o Version) bool {
+	return v.Txid == o.Txid && bytes.Equal(v.Root, o.Root)
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("<txid=%d,root=%x>", v.Txid, v.Root)
+}
+
+// Config 一次崩溃测试的配置
+type Config struct {
+	// Seed 决定前缀的选择与写入的撕裂位置，相同的 Seed 可以复现
+	Seed int64
+	// Trials 随机重放的前缀数，为 0 时重放所有前缀
+	Trials int
+	// Initial 空存储恢复到的版本
+	Initial Version
+	// Workload 在 fs 上写入，每次提交成功返回后调用 committed
+	Workload func(fs file.FS, committed func(Version)) error
+	// Open 在 fs 上重新打开存储，校验根哈希并返回恢复到的版本
+	Open func(fs file.FS) (Version, error)
+}
+
+type mark struct {
+	op      int // 提交返回时已记录的操作数
+	version Version
+}
+
+// Run 在记录操作的文件系统上执行一次 workload，然后重放记录的各个前缀，
+// 检查重新打开的存储处于掉电前最后一次提交的版本，或者掉电时正在提交的版本
+func Run(cfg Config) error {
+	rec := NewRecorder(file.NewMemFS())
+	var marks []mark
+	if err := cfg.Workload(rec, func(v Version) {
+		marks = append(marks, mark{op: rec.Len(), version: v})
+	}); err != nil {
+		return fmt.Errorf("crashtest: workload: %w", err)
+	}
+	ops := rec.Ops()
+
+	rnd := rand.New(rand.NewSource(cfg.Seed))
+	check := func(n int) error {
+		fs, err := Replay(ops, n, rnd)
+		if err != nil {
+			return err
+		}
+		got, err := cfg.Open(fs)
+		if err != nil {
+			return fmt.Errorf("crashtest: prefix %d/%d (seed %d): open: %w", n, len(ops), cfg.Seed, err)
+		}
+		prev := cfg.Initial
+		for _, m := range marks {
+			if m.op > n {
+				break
+			}
+			prev = m.version
+		}
+		next := prev
+		for _, m := range marks {
+			if m.op > n {
+				next = m.version
+				break
+			}
+		}
+		if !got.Equal(prev) && !got.Equal(next) {
+			return fmt.Errorf("crashtest: prefix %d/%d (seed %d): recovered %v, want %v or %v",
+				n, len(ops), cfg.Seed, got, prev, next)
+		}
+		return nil
+	}
+
+	if cfg.Trials == 0 {
+		for n := 0; n <= len(ops); n++ {
+			if err := check(n); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for i := 0; i < cfg.Trials; i++ {
+		if err := check(rnd.Intn(len(ops) + 1)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+// Package crashtest 对运行在 file.FS 上的存储模拟掉电。
+// 记录经过文件层的每次写入与刷盘，把记录的随机前缀重放到新的内存文件系统上，
+// 丢弃未刷盘的写入并撕裂最后一次写入，再重新打开存储，检查它恢复到某个已提交的版本
+package crashtest
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/breeze-go-rust/go-tsmm/file"
+)
+
+// OpKind 记录的文件系统操作类型
+type OpKind int
+
+const (
+	OpMkdir OpKind = iota
+	OpCreate
+	OpWrite
+	OpTruncate
+	OpSync
+	OpRemove
+	OpRename
+)
+
+// Op 一次记录的操作。写入、截断与刷盘按 inode 引用文件，重命名后文件的刷盘历史不变
+type Op struct {
+	Kind    OpKind
+	Inode   int
+	Name    string
+	NewName string
+	Offset  int64
+	Data    []byte
+	Size    int64
+}
+
+// Recorder 包装一个 file.FS，按顺序记录每个修改内容的操作，读取与加锁直接透传，不做记录
+type Recorder struct {
+	file.FS
+
+	mu     sync.Mutex
+	ops    []Op
+	inodes map[string]int
+	next   int
+}
+
+// NewRecorder 包装 fs
+func NewRecorder(fs file.FS) *Recorder {
+	return &Recorder{FS: fs, inodes: make(map[string]int)}
+}
+
+// Ops 返回目前已记录操作的副本
+func (r *Recorder) Ops() []Op {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Op(nil), r.ops...)
+}
+
+// Len 返回目前已记录的操作数
+func (r *Recorder) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.ops)
+}
+
+func (r *Recorder) record(op Op) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ops = append(r.ops, op)
+}
+
+func (r *Recorder) inode(name string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	name = filepath.Clean(name)
+	id, ok := r.inodes[name]
+	if !ok {
+		r.next++
+		id = r.next
+		r.inodes[name] = id
+		r.ops = append(r.ops, Op{Kind: OpCreate, Inode: id, Name: name})
+	}
+	return id
+}
+
+func (r *Recorder) Open(name string, opts *file.Options) (file.Handle, error) {
+	h, err := r.FS.Open(name, opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts != nil && opts.ReadOnly {
+		return h, nil
+	}
+	return &recordHandle{Handle: h, r: r, inode: r.inode(name)}, nil
+}
+
+func (r *Recorder) MkdirAll(dir string) error {
+	if err := r.FS.MkdirAll(dir); err != nil {
+		return err
+	}
+	r.record(Op{Kind: OpMkdir, Name: filepath.Clean(dir)})
+	return nil
+}
+
+func (r *Recorder) Remove(name string) error {
+	if err := r.FS.Remove(name); err != nil {
+		return err
+	}
+	name = filepath.Clean(name)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.inodes, name)
+	r.ops = append(r.ops, Op{Kind: OpRemove, Name: name})
+	return nil
+}
+
+func (r *Recorder) Rename(oldName, newName string) error {
+	if err := r.FS.Rename(oldName, newName); err != nil {
+		return err
+	}
+	oldName, newName = filepath.Clean(oldName), filepath.Clean(newName)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if id, ok := r.inodes[oldName]; ok {
+		delete(r.inodes, oldName)
+		r.inodes[newName] = id
+	}
+	r.ops = append(r.ops, Op{Kind: OpRename, Name: oldName, NewName: newName})
+	return nil
+}
+
+func (r *Recorder) Lock(name string, exclusive bool, timeout time.Duration) (io.Closer, error) {
+	return r.FS.Lock(name, exclusive, timeout)
+}
+
+type recordHandle struct {
+	file.Handle
+	r     *Recorder
+	inode int
+}
+
+func (h *recordHandle) WriteAt(p []byte, off int64) (int, error) {
+	n, err := h.Handle.WriteAt(p, off)
+	if n > 0 {
+		h.r.record(Op{Kind: OpWrite, Inode: h.inode, Offset: off, Data: append([]byte(nil), p[:n]...)})
+	}
+	return n, err
+}
+
+func (h *recordHandle) Truncate(size int64) error {
+	if err := h.Handle.Truncate(size); err != nil {
+		return err
+	}
+	h.r.record(Op{Kind: OpTruncate, Inode: h.inode, Size: size})
+	return nil
+}
+
+func (h *recordHandle) Sync() error {
+	if err := h.Handle.Sync(); err != nil {
+		return err
+	}
+	h.r.record(Op{Kind: OpSync, Inode: h.inode})
+	return nil
+}
+
+func (k OpKind) String() string {
+	switch k {
+	case OpMkdir:
+		return "mkdir"
+	case OpCreate:
+		return "create"
+	case OpWrite:
+		return "write"
+	case OpTruncate:
+		return "truncate"
+	case OpSync:
+		return "sync"
+	case OpRemove:
+		return "remove"
+	case OpRename:
+		return "rename"
+	}
+	return fmt.Sprintf("unknown<%d>", int(k))
+}
@@ -34,6 +34,9 @@ type Interface interface {
 	// PendingCount returns the number of pending pages.
 	PendingCount() int
 
+	// PendingPages returns a copy of the pending page ids keyed by the txid that freed them.
+	PendingPages() map[common.TxID]common.Pgids
+
 	// AddReadonlyTXID adds a given read-only transaction id for pending page tracking.
 	AddReadonlyTXID(txid common.TxID)
 
@@ -57,7 +57,7 @@ func (f *hashMap) Init(pgids common.Pgids) {
 	f.reindex()
 }
 
-func (f *hashMap) Allocate(txid common.Txid, n int) common.Pgid {
+func (f *hashMap) Allocate(txid common.TxID, n int) common.Pgid {
 	if n == 0 {
 		return 0
 	}
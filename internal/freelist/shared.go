@@ -43,6 +43,17 @@ func (t *shared) PendingCount() int {
 	return count
 }
 
+func (t *shared) PendingPages() map[common.TxID]common.Pgids {
+	m := make(map[common.TxID]common.Pgids, len(t.pending))
+	for txid, txp := range t.pending {
+		ids := make(common.Pgids, len(txp.ids))
+		copy(ids, txp.ids)
+		sort.Sort(ids)
+		m[txid] = ids
+	}
+	return m
+}
+
 func (t *shared) Count() int {
 	return t.FreeCount() + t.PendingCount()
 }
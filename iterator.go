@@ -11,3 +11,12 @@ type Iterator interface {
 	Prev() bool
 	First() bool
 }
+
+// KVIterator 按 key 升序产生键值的数据源，Iterator 满足该接口。
+// Next 返回 false 后由 Error 报告是否因出错而结束
+type KVIterator interface {
+	Next() bool
+	Key() []byte
+	Value() []byte
+	Error() error
+}
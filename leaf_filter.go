@@ -0,0 +1,141 @@
+package go_tsmm
+
+import (
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/breeze-go-rust/go-tsmm/cache"
+	"github.com/breeze-go-rust/go-tsmm/filter"
+	"github.com/breeze-go-rust/go-tsmm/internal/common"
+)
+
+// 过滤器页：页头 | 名称长度(1B) | 过滤器名称 | 过滤器数据，页头 size 为其后数据的长度。
+// 每个叶子页对应一个过滤器页，页号记录在父分支元素中，
+// Get 在读叶子页之前先查过滤器，不存在的 key 不需要读叶子页。
+// 查询时按页中记录的名称选择过滤器，更换过滤器后新旧过滤器页可以共存；
+// 名称无法识别时视为可能存在，退回到读叶子页
+
+// cachedFilter 页缓存中的过滤器页
+type cachedFilter struct {
+	name string
+	data []byte
+	buf  []byte
+	pm   *PageMgr
+}
+
+func (c *cachedFilter) CachePriority() cache.Priority {
+	return cache.PriorityHigh
+}
+
+func (c *cachedFilter) Release() {
+	c.pm.free(c.buf)
+	c.data, c.buf = nil, nil
+}
+
+// writeFilterPage 为叶子页的 key 生成过滤器并写入新分配的过滤器页，
+// 未配置过滤器或过滤器超过一页时返回 0，该叶子页不带过滤器
+func (b *BTree) writeFilterPage(inodes common.Inodes) common.Pgid {
+	tree := b.parent()
+	if tree.filter == nil || len(inodes) == 0 {
+		return 0
+	}
+	name := tree.filter.Name()
+	if len(name) > 0xFF {
+		return 0
+	}
+	g := tree.filter.NewGenerator()
+	for _, in := range inodes {
+		g.Add(in.Key())
+	}
+	buf := &filter.ByteBuffer{}
+	_ = buf.WriteByte(byte(len(name)))
+	_, _ = buf.Write([]byte(name))
+	g.Generate(buf)
+	if int(common.PageHeaderSize)+buf.Len() > int(tree.pageSize()) {
+		return 0
+	}
+	p := tree.allocate(1)
+	p.SetFlags(common.FilterPageFlag)
+	p.SetSize(uint32(buf.Len()))
+	copy(common.UnsafeByteSlice(unsafe.Pointer(p), common.PageHeaderSize, 0, buf.Len()), buf.Bytes())
+	return p.Id()
+}
+
+// freeFilterPage 释放叶子页被替换后不再使用的过滤器页
+func (b *BTree) freeFilterPage(id common.Pgid) {
+	if id == 0 {
+		return
+	}
+	tree := b.parent()
+	tree.freePage(common.NewPage(id, common.FilterPageFlag, 0, 0))
+	tree.evictPage(id)
+}
+
+func decodeFilterPage(p *common.Page) (string, []byte, bool) {
+	if !p.IsFilterPage() || p.Size() == 0 {
+		return "", nil, false
+	}
+	data := common.UnsafeByteSlice(unsafe.Pointer(p), common.PageHeaderSize, 0, int(p.Size()))
+	n := int(data[0])
+	if 1+n > len(data) {
+		return "", nil, false
+	}
+	return string(data[1 : 1+n]), data[1+n:], true
+}
+
+// mayContain 查询过滤器页 id，返回 false 时 key 一定不在对应的叶子页中
+func (b *BTree) mayContain(id common.Pgid, key []byte) (bool, error) {
+	tree := b.parent()
+	var (
+		name string
+		data []byte
+	)
+	if tree.pageCache == nil {
+		p, _, err := tree.page(id, 0)
+		if err != nil {
+			return false, err
+		}
+		var ok bool
+		if name, data, ok = decodeFilterPage(p); !ok {
+			return true, nil
+		}
+	} else {
+		var rerr error
+		h := tree.pageCache.Get(uint64(id), func() (int, cache.Value) {
+			p, buf, err := tree.page(id, 0)
+			if err != nil {
+				rerr = err
+				return 0, nil
+			}
+			name, data, ok := decodeFilterPage(p)
+			if !ok {
+				return 0, nil
+			}
+			return len(buf), &cachedFilter{name: name, data: data, buf: buf, pm: tree.pageMgr}
+		})
+		if rerr != nil {
+			return false, rerr
+		}
+		if h == nil {
+			return true, nil
+		}
+		defer h.Release()
+		cf, ok := h.Value().(*cachedFilter)
+		if !ok {
+			return true, nil
+		}
+		name, data = cf.name, cf.data
+	}
+	f := tree.filter
+	if f == nil || f.Name() != name {
+		var ok bool
+		if f, ok = filter.ByName(name); !ok {
+			return true, nil
+		}
+	}
+	if !f.Contains(data, key) {
+		atomic.AddUint64(&tree.filterNegatives, 1)
+		return false, nil
+	}
+	return true, nil
+}
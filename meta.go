@@ -5,60 +5,209 @@ import (
 	"github.com/breeze-go-rust/go-tsmm/file"
 	"github.com/breeze-go-rust/go-tsmm/internal/common"
 	"path/filepath"
+	"sort"
+	"sync"
 	"unsafe"
 )
 
+// MetaMgr 以环形方式保存最近 activateVersionNum 个 meta 版本。
+// 版本 txid 写入槽位 txid % activateVersionNum，新版本覆盖最旧的版本；
+// 某个槽位写入时掉电，其余槽位中的版本仍然可用
 type MetaMgr struct {
+	mu                 sync.RWMutex
 	mFile              []*file.File
 	noSync             bool
 	activateVersionNum int
+	versions           map[common.TxID]*common.Meta // 环中有效的版本
 }
 
-func NewMetaMgr(metaFilePath string, activateVersionNum int, noSync bool) (*MetaMgr, error) {
+// NewMetaMgr 打开 activateVersionNum 个 meta 槽位组成的环。提交覆盖最旧的槽位，
+// 至少需要两个槽位，写 meta 时掉电才能回退到上一个完整的版本
+func NewMetaMgr(fs file.FS, metaFilePath string, activateVersionNum int, noSync bool, fileOpts *file.Options) (*MetaMgr, error) {
+	if activateVersionNum < 2 {
+		return nil, fmt.Errorf("invalid active meta version number %d", activateVersionNum)
+	}
+	if fileOpts == nil || !fileOpts.ReadOnly {
+		if err := fs.MkdirAll(metaFilePath); err != nil {
+			return nil, fmt.Errorf("error creating meta dir %s: %w", metaFilePath, err)
+		}
+	}
 	files := make([]*file.File, activateVersionNum)
 	for i := 0; i < activateVersionNum; i++ {
 		metaPath := filepath.Join(metaFilePath, fmt.Sprintf("%d.meta", i))
-		mFile, err := file.OpenFile(metaPath, file.NewFLocker())
+		mFile, err := file.OpenFile(fs, metaPath, fileOpts)
 		if err != nil {
+			for _, f := range files[:i] {
+				_ = f.Close()
+			}
 			return nil, fmt.Errorf("error opening page file %s: %w", metaFilePath, err)
 		}
 		files[i] = mFile
 	}
-	metaMgr := &MetaMgr{mFile: files, activateVersionNum: activateVersionNum, noSync: noSync}
+	metaMgr := &MetaMgr{
+		mFile:              files,
+		activateVersionNum: activateVersionNum,
+		noSync:             noSync,
+		versions:           make(map[common.TxID]*common.Meta),
+	}
+	metaMgr.load()
 	return metaMgr, nil
 }
 
-// Write 对于持久化的 meta 进行写入, 树上的活跃版本 不在这里写入
+// load 读取所有槽位，校验失败（未写入、撕裂）或不属于该槽位的 meta 被忽略
+func (mm *MetaMgr) load() {
+	data := make([]byte, common.MetaSize)
+	for slot := range mm.mFile {
+		meta, err := mm.readSlot(slot, data)
+		if err != nil || meta.Validate() != nil {
+			continue
+		}
+		if mm.slot(meta.Txid()) != slot {
+			continue
+		}
+		mm.versions[meta.Txid()] = meta
+	}
+	mm.prune()
+}
+
+func (mm *MetaMgr) slot(txid common.TxID) int {
+	return int(txid % common.TxID(len(mm.mFile)))
+}
+
+func (mm *MetaMgr) readSlot(slot int, data []byte) (*common.Meta, error) {
+	at, err := mm.mFile[slot].ReadAt(0, data)
+	if err != nil {
+		return nil, fmt.Errorf("error reading meta file: %w", err)
+	}
+	if at != len(data) {
+		return nil, fmt.Errorf("incorrect position read size")
+	}
+	meta := &common.Meta{}
+	(*common.Meta)(unsafe.Pointer(&data[0])).Copy(meta)
+	return meta, nil
+}
+
+// prune 只保留 txid 最大的 activateVersionNum 个版本，返回被移除的版本
+func (mm *MetaMgr) prune() []common.TxID {
+	if len(mm.versions) <= mm.activateVersionNum {
+		return nil
+	}
+	txids := mm.txids()
+	pruned := txids[:len(txids)-mm.activateVersionNum]
+	for _, txid := range pruned {
+		delete(mm.versions, txid)
+	}
+	return pruned
+}
+
+func (mm *MetaMgr) txids() []common.TxID {
+	txids := make([]common.TxID, 0, len(mm.versions))
+	for txid := range mm.versions {
+		txids = append(txids, txid)
+	}
+	sort.Slice(txids, func(i, j int) bool { return txids[i] < txids[j] })
+	return txids
+}
+
+// Write 写入新版本 meta 并刷盘，覆盖同一槽位上最旧的版本
 // v=10
 // 0,1,2,3,4,5,6,7,8,9
-// 10,12,13,14,15,16,17,18,19
+// 10,11,12,13,14,15,16,17,18,19
 func (mm *MetaMgr) Write(meta *common.Meta) error {
-	metaFileIndex := int(meta.Pgid()) % len(mm.mFile)
-	metaFilePosition := int(meta.Pgid()) / len(mm.mFile)
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	slot := mm.slot(meta.Txid())
+	// 先从环中移除将被覆盖的版本，写入失败时该槽位已不可信
+	for txid := range mm.versions {
+		if mm.slot(txid) == slot {
+			delete(mm.versions, txid)
+		}
+	}
 	data := meta.Encode()
-	at, err := mm.mFile[metaFileIndex].WriteAt(int64(metaFilePosition), data)
+	at, err := mm.mFile[slot].WriteAt(0, data)
 	if err != nil {
 		return fmt.Errorf("error writing meta file: %w", err)
 	}
 	if at != len(data) {
 		return fmt.Errorf("incorrect position written size")
 	}
-	return Sync(mm.noSync, mm.mFile[metaFileIndex].Sync)
+	if err := Sync(mm.noSync, mm.mFile[slot].Sync); err != nil {
+		return err
+	}
+	m := &common.Meta{}
+	meta.Copy(m)
+	mm.versions[m.Txid()] = m
+	mm.prune()
+	return nil
 }
 
-func (mm *MetaMgr) ReadMeta(metaID uint64, data []byte) (*common.Meta, error) {
-	metaFileIndex := int(metaID) % len(mm.mFile)
-	metaFilePosition := int(metaID) / len(mm.mFile)
-	// 实际的 Offset
-	offset := int(metaFilePosition) * common.MetaSize
-
-	at, err := mm.mFile[metaFileIndex].ReadAt(int64(offset), data)
+// ReadMeta 从磁盘读取版本 txid 的 meta，data 长度需为 common.MetaSize
+func (mm *MetaMgr) ReadMeta(txid common.TxID, data []byte) (*common.Meta, error) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+	meta, err := mm.readSlot(mm.slot(txid), data)
 	if err != nil {
-		return nil, fmt.Errorf("error reading meta file: %w", err)
+		return nil, err
 	}
-	if at != len(data) {
-		return nil, fmt.Errorf("incorrect position read size")
+	if err := meta.Validate(); err != nil {
+		return nil, err
+	}
+	if meta.Txid() != txid {
+		return nil, fmt.Errorf("meta version %d has been overwritten by %d", txid, meta.Txid())
 	}
-	meta := (*common.Meta)(unsafe.Pointer(&data[0]))
 	return meta, nil
 }
+
+// Versions 返回环中保留的所有版本，按 txid 升序
+func (mm *MetaMgr) Versions() []*common.Meta {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+	metas := make([]*common.Meta, 0, len(mm.versions))
+	for _, txid := range mm.txids() {
+		m := &common.Meta{}
+		mm.versions[txid].Copy(m)
+		metas = append(metas, m)
+	}
+	return metas
+}
+
+// Latest 返回最新的版本，环为空时返回 nil
+func (mm *MetaMgr) Latest() *common.Meta {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+	var latest *common.Meta
+	for txid, meta := range mm.versions {
+		if latest == nil || txid > latest.Txid() {
+			latest = meta
+		}
+	}
+	if latest == nil {
+		return nil
+	}
+	m := &common.Meta{}
+	latest.Copy(m)
+	return m
+}
+
+// Meta 返回环中版本 txid 的 meta
+func (mm *MetaMgr) Meta(txid common.TxID) (*common.Meta, bool) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+	meta, ok := mm.versions[txid]
+	if !ok {
+		return nil, false
+	}
+	m := &common.Meta{}
+	meta.Copy(m)
+	return m, true
+}
+
+func (mm *MetaMgr) Close() error {
+	var err error
+	for _, f := range mm.mFile {
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,28 @@
+package go_tsmm
+
+import (
+	"testing"
+
+	"github.com/breeze-go-rust/go-tsmm/file"
+)
+
+// TestNewMetaMgrVersionNum 少于两个槽位时写 meta 掉电会丢失唯一的版本，打开时拒绝
+func TestNewMetaMgrVersionNum(t *testing.T) {
+	fs := file.NewMemFS()
+	for _, n := range []int{-1, 0, 1} {
+		if mm, err := NewMetaMgr(fs, "/meta", n, true, nil); err == nil {
+			_ = mm.Close()
+			t.Fatalf("NewMetaMgr with %d versions succeeded", n)
+		}
+	}
+	mm, err := NewMetaMgr(fs, "/meta", 2, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewBTree(false, false, true, t.TempDir(), "", 1, 0, "", 0, 0, &Options{PageSize: 4096}); err == nil {
+		t.Fatal("NewBTree with one meta version succeeded")
+	}
+}
@@ -0,0 +1,117 @@
+package go_tsmm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// MetricsCollector 以 Prometheus 文本格式（0.0.4）导出 BTree.Stats()，
+// 不依赖 Prometheus 客户端库；可直接作为 /metrics 的 http.Handler，
+// 也可通过 WriteTo 拼接到已有的导出中
+type MetricsCollector struct {
+	tree      *BTree
+	namespace string
+}
+
+// NewMetricsCollector 指标名以 namespace_ 为前缀，namespace 为空时使用 "tsmm"
+func NewMetricsCollector(tree *BTree, namespace string) *MetricsCollector {
+	if namespace == "" {
+		namespace = "tsmm"
+	}
+	return &MetricsCollector{tree: tree, namespace: namespace}
+}
+
+type metricsWriter struct {
+	w         *bufio.Writer
+	namespace string
+}
+
+func (mw *metricsWriter) header(name, typ, help string) {
+	fmt.Fprintf(mw.w, "# HELP %s_%s %s\n# TYPE %s_%s %s\n", mw.namespace, name, help, mw.namespace, name, typ)
+}
+
+func (mw *metricsWriter) sample(name, labels string, v float64) {
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(mw.w, "%s_%s%s %s\n", mw.namespace, name, labels, strconv.FormatFloat(v, 'g', -1, 64))
+}
+
+func (mw *metricsWriter) metric(name, typ, help string, v float64) {
+	mw.header(name, typ, help)
+	mw.sample(name, "", v)
+}
+
+func (mw *metricsWriter) phases(name, typ, help string, cs CommitStats) {
+	mw.header(name, typ, help)
+	mw.sample(name, `phase="update"`, cs.Update.Seconds())
+	mw.sample(name, `phase="write"`, cs.Write.Seconds())
+	mw.sample(name, `phase="sync"`, cs.Sync.Seconds())
+	mw.sample(name, `phase="meta"`, cs.Meta.Seconds())
+	mw.sample(name, `phase="total"`, cs.Total.Seconds())
+}
+
+// WriteTo 写出当前统计，实现 io.WriterTo
+func (c *MetricsCollector) WriteTo(w io.Writer) (int64, error) {
+	s := c.tree.Stats()
+	cw := &countingWriter{w: w}
+	mw := &metricsWriter{w: bufio.NewWriter(cw), namespace: c.namespace}
+
+	mw.metric("txid", "gauge", "Transaction id of the last committed version.", float64(s.Txid))
+	mw.metric("commits_total", "counter", "Number of successful commits.", float64(s.Commits))
+	mw.metric("commit_pages_total", "counter", "Pages written by commits, including overflow pages.", float64(s.TotalCommit.Pages))
+	mw.phases("commit_seconds_total", "counter", "Time spent in commits by phase.", s.TotalCommit)
+	mw.metric("last_commit_pages", "gauge", "Pages written by the last commit.", float64(s.LastCommit.Pages))
+	mw.phases("last_commit_seconds", "gauge", "Duration of the last commit by phase.", s.LastCommit)
+
+	mw.metric("cache_hits_total", "counter", "Page cache hits.", float64(s.Cache.HitCount))
+	mw.metric("cache_misses_total", "counter", "Page cache misses.", float64(s.Cache.MissCount))
+	mw.metric("cache_sets_total", "counter", "Nodes inserted into the page cache.", float64(s.Cache.SetCount))
+	mw.metric("cache_deletes_total", "counter", "Nodes removed from the page cache.", float64(s.Cache.DelCount))
+	mw.metric("cache_grows_total", "counter", "Page cache hash table grows.", float64(s.Cache.GrowCount))
+	mw.metric("cache_shrinks_total", "counter", "Page cache hash table shrinks.", float64(s.Cache.ShrinkCount))
+	mw.metric("cache_nodes", "gauge", "Nodes in the page cache.", float64(s.Cache.Nodes))
+	mw.metric("cache_bytes", "gauge", "Page bytes held by the page cache.", float64(s.Cache.Size))
+	mw.metric("cache_capacity_bytes", "gauge", "Page cache capacity.", float64(s.CacheCapacity))
+
+	mw.metric("freelist_free_pages", "gauge", "Free pages available for reuse.", float64(s.FreeCount))
+	mw.metric("freelist_pending_pages", "gauge", "Freed pages still referenced by retained versions.", float64(s.PendingCount))
+	mw.metric("page_size_bytes", "gauge", "Page size.", float64(s.PageSize))
+	mw.metric("page_file_bytes", "gauge", "Size of the page file.", float64(s.PageFileSize))
+
+	mw.metric("vlog_files", "gauge", "Number of value log files.", float64(s.ValueLog.Files))
+	mw.metric("vlog_bytes", "gauge", "Total size of the value log.", float64(s.ValueLog.Size))
+	mw.metric("vlog_discard_bytes", "gauge", "Bytes of discarded value log records.", float64(s.ValueLog.Discard))
+	mw.metric("vlog_discard_ratio", "gauge", "Fraction of the value log that is discarded.", s.ValueLog.DiscardRatio())
+
+	mw.metric("filter_negatives_total", "counter", "Leaf reads skipped because the filter excluded the key.", float64(s.FilterNegatives))
+	mw.metric("filter_false_positives_total", "counter", "Lookups the filter admitted that were absent from the leaf.", float64(s.FilterFalsePositives))
+
+	if err := mw.w.Flush(); err != nil {
+		return cw.n, err
+	}
+	return cw.n, cw.err
+}
+
+func (c *MetricsCollector) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = c.WriteTo(w)
+}
+
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	if err != nil && cw.err == nil {
+		cw.err = err
+	}
+	return n, err
+}
@@ -2,9 +2,9 @@ package go_tsmm
 
 import (
 	"bytes"
-	"encoding/binary"
 	"fmt"
 	"github.com/breeze-go-rust/go-tsmm/internal/common"
+	"github.com/breeze-go-rust/go-tsmm/util/hasher"
 	"math"
 	"sort"
 	"sync"
@@ -18,7 +18,7 @@ type node struct {
 	overflow    uint32
 	page        *common.Page
 	isLeaf      bool
-	hash        [20]byte
+	hash        []byte
 	parent      *node
 	children    nodes
 	inodes      common.Inodes
@@ -26,51 +26,51 @@ type node struct {
 	nLock       sync.Mutex
 }
 
-func (n *node) update(group *sync.WaitGroup, kvs common.Inodes, from, to int) error {
-	defer group.Done()
-	common.Assert(len(kvs) == 0, "update: kvs is empty")
+// update 自上而下分发 kvs[from:to]（含 to）：叶子节点合并后写出，分支节点按子节点切分，
+// 先摘除待重写子节点在本节点中的元素，子节点写出后再放回新的元素。
+// childrenNum 记录尚未完成的子节点，最后一个完成的子节点提交本节点的哈希任务
+func (n *node) update(pl *pipeline, kvs common.Inodes, from, to int) {
+	if pl.failed() {
+		pl.childDone(n.parent)
+		return
+	}
 	if n.isLeaf {
-		if n.parent == nil {
-			n.parent = &node{isLeaf: false, bTree: n.bTree}
-			atomic.AddInt32(&n.parent.childrenNum, 1)
-		}
-		n.leafNode(kvs[from:to], group)
-		return nil
+		n.leafNode(pl, kvs[from:to+1])
+		return
 	}
-	// 对于 Branch 节点
-	if len(n.inodes) == 0 {
-		child := &node{isLeaf: true, inodes: make(common.Inodes, 0), bTree: n.bTree, parent: n}
-		atomic.AddInt32(&n.childrenNum, 1)
-		group.Add(1)
-		if err := n.bTree.branchNodePool.Invoke(&task{wg: group, n: child, kvs: kvs, from: from, to: to}); err != nil {
-			return fmt.Errorf("invoke failed: %v", err)
+	ranges := n.split(kvs, from, to)
+	type dirtyChild struct {
+		in *common.Inode
+		r  inodeRange
+	}
+	children := make([]dirtyChild, 0, len(ranges))
+	n.nLock.Lock()
+	for idx, r := range ranges {
+		children = append(children, dirtyChild{in: n.inodes[idx], r: r})
+	}
+	for _, c := range children {
+		n.del(c.in.Key())
+	}
+	n.nLock.Unlock()
+	atomic.StoreInt32(&n.childrenNum, int32(len(children)))
+	for _, c := range children {
+		child, err := n.findChild(c.in.Pgid(), c.in.Overflow())
+		if err != nil {
+			pl.fail(err)
+			pl.childDone(n)
+			continue
 		}
-	} else {
-		ranges := n.split(kvs, from, to)
-		group.Add(len(ranges))
-		atomic.AddInt32(&n.childrenNum, int32(len(ranges)))
-		tempInodes := make(common.Inodes, len(n.inodes))
-		copy(tempInodes, n.inodes)
-		for idx, irs := range ranges {
-			in := tempInodes[idx]
-			child, err := n.findChild(in.Pgid(), in.Overflow())
-			if err != nil {
-				return err
-			}
-			if child.isLeaf {
-				n.nLock.Lock()
-				n.del(child.key)
-				n.nLock.Unlock()
-			}
-			if err := n.bTree.branchNodePool.Invoke(&task{wg: group, n: child, kvs: kvs, from: irs.from, to: irs.to}); err != nil {
-				return fmt.Errorf("invoke failed: %v", err)
-			}
+		child.parent = n
+		if child.isLeaf {
+			// 叶子页将被重写，原过滤器页随之失效
+			n.bTree.freeFilterPage(c.in.Filter())
 		}
+		pl.submit(n.bTree.parent().leafNodePool, &task{pl: pl, n: child, kvs: kvs, from: c.r.from, to: c.r.to})
 	}
-	return nil
 }
+
 func (n *node) findChild(pgId common.Pgid, overflow uint32) (*node, error) {
-	child, err := n.bTree.parent().pageNode(pgId, overflow)
+	child, err := n.bTree.pageNode(pgId, overflow)
 	if err != nil {
 		return nil, fmt.Errorf("find child failed with pgid=%d,overflow=%d: %v", pgId, overflow, err)
 	}
@@ -134,73 +134,225 @@ func (n *node) split(kvs common.Inodes, start, end int) map[int]inodeRange {
 	return res
 }
 
-func (n *node) leafNode(kvs common.Inodes, group *sync.WaitGroup) {
-	if len(kvs) == 0 {
-		return
+func (n *node) leafNode(pl *pipeline, kvs common.Inodes) {
+	if !pl.failed() && len(kvs) > 0 {
+		if err := n.leafNodeMergeInodes(kvs); err != nil {
+			pl.fail(err)
+		}
 	}
-
+	n.free()
+	pl.childDone(n.parent)
 }
 
-// 通过 归并的方式 将 kvs 和 n.inodes 的 所有的 inode 全部 合并起来
-func (n *node) leafNodeMergeInodes(kvs common.Inodes) {
+// 通过 归并的方式 将 kvs 和 n.inodes 的 所有的 inode 全部 合并起来，
+// value 为 nil 的 kv 表示删除
+func (n *node) leafNodeMergeInodes(kvs common.Inodes) error {
 	bTree := n.bTree.parent()
-	threshold := bTree.pSize // 默认4K大小
-	if bTree.EnableCompress() {
-		threshold = 4 * bTree.pSize
-	}
-	tempInodes := make(common.Inodes, len(n.inodes)) // 原始
-	tempKvs := make(common.Inodes, len(kvs))         // 新的数据
-	var (
-		aIndex, bIndex int // 采用普通归并的方式
-	)
-	copy(tempInodes, n.inodes)
-	copy(tempKvs, kvs)
-	manager := newLeafSpillManager(bTree, n, int(threshold), bTree.EnableCompress())
+	manager := newLeafSpillManager(bTree, n, bTree.leafPageLimit(), bTree.EnableCompress())
 	defer manager.close()
+	seq := n.bTree.header.InSequence()
 
-	for aIndex < len(tempInodes) && bIndex < len(tempKvs) {
-		compare := bytes.Compare(tempInodes[aIndex].Key(), tempKvs[bIndex].Key())
+	var aIndex, bIndex int // 采用普通归并的方式
+	for aIndex < len(n.inodes) || bIndex < len(kvs) {
+		var compare int
+		switch {
+		case aIndex >= len(n.inodes):
+			compare = 1
+		case bIndex >= len(kvs):
+			compare = -1
+		default:
+			compare = bytes.Compare(n.inodes[aIndex].Key(), kvs[bIndex].Key())
+		}
 		switch compare {
-		case -1: // tmpInodes 数据写入
-			manager.appendInode(tempInodes[aIndex], nil)
+		case -1: // 原有数据保留
+			manager.appendInode(n.inodes[aIndex])
 			aIndex++
 		case 0: // 存在相同的数据
-			oldFid := binary.LittleEndian.Uint64(n.inodes[bIndex].Value()[:8])
-			oldIndex := binary.LittleEndian.Uint64(n.inodes[bIndex].Value()[8:16])
-			if tempKvs[bIndex].Value() != nil {
-				actualValue := manager.genInode(tempKvs[bIndex], oldFid, oldIndex, bTree.header.InSequence())
-				manager.appendInode(tempKvs[bIndex], actualValue)
-			} else {
+			oldFid, oldIndex, ok := valuePointer(n.inodes[aIndex])
+			if kvs[bIndex].Value() != nil {
+				in, err := manager.genInode(kvs[bIndex], oldFid, oldIndex, seq)
+				if err != nil {
+					return err
+				}
+				manager.appendInode(in)
+			} else if ok {
 				manager.del(oldFid, oldIndex)
 			}
-			bIndex++
 			aIndex++
-		case 1: // tempKvs 数据写入
-			if tempKvs[bIndex].Value() != nil {
-				actualValue := manager.genInode(tempKvs[bIndex], math.MaxUint64, math.MaxUint64, bTree.header.InSequence())
-				manager.appendInode(tempKvs[bIndex], actualValue)
+			bIndex++
+		case 1: // 新数据写入，删除不存在的 key 直接忽略
+			if kvs[bIndex].Value() != nil {
+				in, err := manager.genInode(kvs[bIndex], math.MaxUint64, math.MaxUint64, seq)
+				if err != nil {
+					return err
+				}
+				manager.appendInode(in)
 			}
+			bIndex++
+		}
+	}
+	manager.finish()
+	return nil
+}
+
+// updateBranchNode 所有子节点完成后先合并过小的子节点，再计算分支页哈希并写出，
+// 超过一页时先分裂，分裂出的各页都放回父节点，由父节点继续判断是否分裂或合并；
+// 子节点全部被删除时本节点也随之删除
+func (n *node) updateBranchNode(pl *pipeline) {
+	if !pl.failed() {
+		n.free()
+		n.rebalance()
+		for _, part := range n.spillBranch() {
+			n.parent.adopt(part, 0)
+		}
+	}
+	pl.childDone(n.parent)
+}
+
+// adopt 放回本次更新写出的子节点，子节点同时记入 children，供 rebalance 合并
+func (n *node) adopt(child *node, filter common.Pgid) {
+	n.nLock.Lock()
+	// put 的 panic 由任务恢复，锁必须随之释放，否则同一父节点的其他子节点阻塞在这里
+	defer n.nLock.Unlock()
+	n.put(child.key, child.key, nil, child.pgid, child.overflow, filter, common.NormalTreeFlag, child.hash)
+	n.children = append(n.children, child)
+}
+
+// spillBranch 将分支节点按页大小分裂后逐页计算哈希并写出，返回写出的各页
+func (n *node) spillBranch() nodes {
+	parts := n.splitBranch()
+	for _, part := range parts {
+		part.hashChildren()
+		n.bTree.spill(part, false)
+		part.key = part.inodes[0].Key()
+	}
+	return parts
+}
+
+// splitBranch 分支节点超过一页时切分成多个节点，每个节点填充到页大小的 fillPercent，
+// 且至少有 MinKeysPerPage 个元素；不超过一页时返回节点本身
+func (n *node) splitBranch() nodes {
+	if len(n.inodes) == 0 {
+		return nil
+	}
+	pageSize := int(n.bTree.pageSize())
+	if len(n.inodes) <= common.MinKeysPerPage*2 || n.size() <= pageSize {
+		return nodes{n}
+	}
+	threshold := int(float64(pageSize) * n.bTree.parent().fillPercent)
+	var parts nodes
+	start, size := 0, int(common.PageHeaderSize)
+	for i, in := range n.inodes {
+		elemSize := int(common.BranchPageElementSize) + len(in.Key()) + len(in.Value()) + len(in.Hash())
+		if i-start >= common.MinKeysPerPage && len(n.inodes)-i >= common.MinKeysPerPage && size+elemSize > threshold {
+			parts = append(parts, &node{bTree: n.bTree, parent: n.parent, inodes: n.inodes[start:i:i]})
+			start, size = i, int(common.PageHeaderSize)
 		}
+		size += elemSize
+	}
+	return append(parts, &node{bTree: n.bTree, parent: n.parent, inodes: n.inodes[start:]})
+}
+
+// size 节点写成页后（压缩前）的大小
+func (n *node) size() int {
+	elemSize := int(common.BranchPageElementSize)
+	if n.isLeaf {
+		elemSize = int(common.LeafPageElementSize)
 	}
+	size := int(common.PageHeaderSize)
+	for _, in := range n.inodes {
+		size += elemSize + len(in.Key()) + len(in.Value()) + len(in.Hash())
+	}
+	return size
+}
 
-	for ; aIndex < len(tempInodes); aIndex++ {
-		if tempInodes[aIndex].Value() != nil {
-			manager.appendInode(tempInodes[aIndex], nil)
+// rebalance 本次更新写出的子节点小于页大小的 minFillPercent 时，与相邻的兄弟节点合并，
+// 合并后仍然过小的节点继续合并。两个节点的页与叶子页的过滤器页都被释放，
+// 合并后超过一页时放弃合并。只在本节点的子节点全部完成后调用
+func (n *node) rebalance() {
+	tree := n.bTree.parent()
+	minSize := int(float64(tree.pageSize()) * minFillPercent)
+	for i := 0; i < len(n.children) && len(n.inodes) > 1; i++ {
+		child := n.children[i]
+		if child.pgid == 0 || child.size() >= minSize {
+			continue
+		}
+		index := sort.Search(len(n.inodes), func(i int) bool { return bytes.Compare(n.inodes[i].Key(), child.key) != -1 })
+		if index >= len(n.inodes) || n.inodes[index].Pgid() != child.pgid {
+			continue
+		}
+		if index == len(n.inodes)-1 {
+			index--
+		}
+		left, right := n.inodes[index], n.inodes[index+1]
+		l, err := n.childNode(left)
+		if err != nil {
+			continue
+		}
+		r, err := n.childNode(right)
+		if err != nil || l.isLeaf != r.isLeaf {
+			continue
+		}
+		limit := int(tree.pageSize())
+		if l.isLeaf {
+			limit = tree.leafPageLimit() + int(common.PageHeaderSize)
+		}
+		if l.size()+r.size()-int(common.PageHeaderSize) > limit {
+			continue
 		}
+		merged := &node{bTree: n.bTree, parent: n, isLeaf: l.isLeaf}
+		merged.inodes = append(append(make(common.Inodes, 0, len(l.inodes)+len(r.inodes)), l.inodes...), r.inodes...)
+		merged.key = merged.inodes[0].Key()
+		merged.hashChildren()
+		n.bTree.freeFilterPage(left.Filter())
+		n.bTree.freeFilterPage(right.Filter())
+		l.release()
+		r.release()
+		var filter common.Pgid
+		n.bTree.spill(merged, merged.isLeaf && tree.EnableCompress())
+		if merged.isLeaf {
+			filter = n.bTree.writeFilterPage(merged.inodes)
+		}
+		leftKey, rightKey := left.Key(), right.Key()
+		n.del(rightKey)
+		n.del(leftKey)
+		n.put(merged.key, merged.key, nil, merged.pgid, merged.overflow, filter, common.NormalTreeFlag, merged.hash)
+		n.children = append(n.children, merged)
 	}
+}
 
-	for ; bIndex < len(tempKvs); bIndex++ {
-		if tempKvs[bIndex].Value() != nil {
-			manager.appendInode(tempKvs[bIndex], nil)
+// childNode 返回 in 指向的子节点，本次更新写出的子节点尚未落盘，从 children 中查找
+func (n *node) childNode(in *common.Inode) (*node, error) {
+	for _, child := range n.children {
+		if child.pgid == in.Pgid() {
+			return child, nil
 		}
 	}
+	return n.findChild(in.Pgid(), in.Overflow())
+}
 
+// release 释放节点所在的页，节点可以读自页文件，也可以是本次更新写出的
+func (n *node) release() {
+	if n.page != nil {
+		n.free()
+	} else if n.pgid != 0 {
+		n.bTree.parent().freePage(common.NewPage(n.pgid, 0, 0, n.overflow))
+	}
+	n.pgid = 0
 }
 
-func (n *node) updateBranchNode(group *sync.WaitGroup) {
+// hashChildren 分支节点的哈希为子节点哈希依次拼接后的哈希
+func (n *node) hashChildren() {
+	buf := make([]byte, 0, len(n.inodes)*n.bTree.hashSize())
+	for _, in := range n.inodes {
+		buf = append(buf, in.Hash()...)
+	}
+	h := n.bTree.newHash()
+	n.hash, _ = h.Hash(buf)
+	hasher.Return(h)
 }
 
-func (n *node) put(oldKey, newKey []byte, value []byte, pgId common.Pgid, overflow uint32, flags uint32, hash []byte) {
+func (n *node) put(oldKey, newKey []byte, value []byte, pgId common.Pgid, overflow uint32, filter common.Pgid, flags uint32, hash []byte) {
 	if len(oldKey) <= 0 {
 		panic("put: zero-length old key")
 	}
@@ -212,8 +364,10 @@ func (n *node) put(oldKey, newKey []byte, value []byte, pgId common.Pgid, overfl
 	})
 	exact := len(n.inodes) > 0 && index < len(n.inodes) && bytes.Equal(n.inodes[index].Key(), oldKey)
 	if !exact {
-		n.inodes = append(n.inodes, &common.Inode{})
+		n.inodes = append(n.inodes, nil)
 		copy(n.inodes[index+1:], n.inodes[index:])
+		// inodes 是指针切片，移位后 index 与 index+1 指向同一个元素
+		n.inodes[index] = &common.Inode{}
 	}
 	inode := n.inodes[index]
 	inode.SetKey(newKey)
@@ -221,11 +375,31 @@ func (n *node) put(oldKey, newKey []byte, value []byte, pgId common.Pgid, overfl
 	inode.SetPgid(pgId)
 	inode.SetFlags(flags)
 	inode.SetOverflow(overflow)
+	inode.SetFilter(filter)
 	if hash != nil {
 		inode.SetHash(hash)
 	}
 }
 
+// search 在叶子节点中查找 key
+func (n *node) search(key []byte) (*common.Inode, bool) {
+	index := sort.Search(len(n.inodes), func(i int) bool { return bytes.Compare(n.inodes[i].Key(), key) != -1 })
+	if index >= len(n.inodes) || !bytes.Equal(n.inodes[index].Key(), key) {
+		return nil, false
+	}
+	return n.inodes[index], true
+}
+
+// childAt 返回分支节点中 key 所在的子节点元素：最后一个不大于 key 的元素，
+// key 小于所有元素时取第一个
+func (n *node) childAt(key []byte) *common.Inode {
+	index := sort.Search(len(n.inodes), func(i int) bool { return bytes.Compare(n.inodes[i].Key(), key) == 1 })
+	if index > 0 {
+		index--
+	}
+	return n.inodes[index]
+}
+
 func (n *node) del(oldKey []byte) {
 	index := sort.Search(len(n.inodes), func(i int) bool { return bytes.Compare(n.inodes[i].Key(), oldKey) != -1 })
 	if index >= len(n.inodes) || !bytes.Equal(n.inodes[index].Key(), oldKey) {
@@ -238,6 +412,8 @@ func (n *node) read(p *common.Page) {
 	n.pgid = p.Id()
 	n.overflow = p.Overflow()
 	n.isLeaf = p.IsLeafPage()
+	n.page = p
+	n.hash = p.GetHash()
 	n.inodes = common.ReadInodeFromPage(p)
 
 	if len(n.inodes) > 0 {
@@ -257,7 +433,7 @@ func (n *node) write(p *common.Page, data []byte) {
 	if len(n.inodes) >= 0xFFFF {
 		panic(fmt.Sprintf("inode overflow: %d (pgid=%d)", len(n.inodes), p.Id()))
 	}
-	p.SetHash(n.hash[:])
+	p.SetHash(n.hash)
 	p.SetCount(uint16(len(n.inodes)))
 	if p.Count() == 0 {
 		return
@@ -266,9 +442,30 @@ func (n *node) write(p *common.Page, data []byte) {
 }
 
 func (n *node) free() {
-	if n.page.Id() != 0 {
-		n.bTree.freelist.Free(n.bTree.ctx.meta.Txid(), n.page)
+	if n.page != nil && n.page.Id() != 0 {
+		n.bTree.parent().freePage(n.page)
+		n.bTree.evictPage(n.page.Id())
+		n.page = nil
+	}
+}
+
+// clone 复制缓存中的节点，inodes 逐个复制，key、value 仍引用页缓冲区
+func (n *node) clone(bTree *BTree) *node {
+	c := &node{
+		bTree:    bTree,
+		key:      n.key,
+		pgid:     n.pgid,
+		overflow: n.overflow,
+		page:     n.page,
+		isLeaf:   n.isLeaf,
+		hash:     n.hash,
+	}
+	c.inodes = make(common.Inodes, len(n.inodes))
+	for i, in := range n.inodes {
+		cp := *in
+		c.inodes[i] = &cp
 	}
+	return c
 }
 
 func compareKeys(left, right []byte) int {
@@ -287,21 +484,23 @@ func (s nodes) Less(i, j int) bool {
 }
 
 type task struct {
-	wg       *sync.WaitGroup
+	pl       *pipeline
 	n        *node
 	kvs      common.Inodes
 	from, to int
 }
 
 func (t *task) leafNodePoolTask() {
-	if err := t.n.update(t.wg, t.kvs, t.from, t.to); err != nil {
-		return
-	}
+	defer t.pl.wg.Done()
+	defer t.pl.recover()
+	t.n.update(t.pl, t.kvs, t.from, t.to)
 }
 
 func (t *task) branchNodePoolTask() {
+	defer t.pl.wg.Done()
+	defer t.pl.recover()
 	if t.n.isLeaf {
 		panic("branch node is leaf")
 	}
-	t.n.updateBranchNode(t.wg)
+	t.n.updateBranchNode(t.pl)
 }
@@ -0,0 +1,306 @@
+package go_tsmm
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/breeze-go-rust/go-tsmm/internal/common"
+	"github.com/breeze-go-rust/go-tsmm/util"
+)
+
+// treeDepth 自根沿第一个子节点下降到叶子经过的层数，空树为 0
+func treeDepth(t *testing.T, b *BTree) int {
+	t.Helper()
+	id, overflow := b.header.RootPage(), b.header.Overflow()
+	depth := 0
+	for id != 0 {
+		n, err := b.pageNode(id, overflow)
+		if err != nil {
+			t.Fatal(err)
+		}
+		depth++
+		if n.isLeaf {
+			break
+		}
+		id, overflow = n.inodes[0].Pgid(), n.inodes[0].Overflow()
+	}
+	return depth
+}
+
+// propertyModel 账户树与各子树的内容，key 为树内 key
+type propertyModel map[string]map[string][]byte
+
+func (m propertyModel) sortedKeys(tree string) []string {
+	keys := make([]string, 0, len(m[tree]))
+	for key := range m[tree] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// externalKey 树名为空串时为账户 key，否则为子树的 storage key
+func externalKey(tree, key string) []byte {
+	if tree == "" {
+		return append(util.AccountPrefix(), key...)
+	}
+	return append(append(util.StoragePrefix(), tree...), key...)
+}
+
+// checkCursor 正向、反向遍历与随机 Seek 的结果都与模型一致
+func checkCursor(t *testing.T, rnd *rand.Rand, c *Cursor, keys []string, values map[string][]byte) {
+	t.Helper()
+	i := 0
+	for ok := c.First(); ok; ok = c.Next() {
+		if i >= len(keys) || string(c.Key()) != keys[i] || !bytes.Equal(c.Value(), values[keys[i]]) {
+			t.Fatalf("forward entry %d = %x, model has %d keys", i, c.Key(), len(keys))
+		}
+		i++
+	}
+	if i != len(keys) || c.Error() != nil {
+		t.Fatalf("forward visited %d of %d keys, err %v", i, len(keys), c.Error())
+	}
+	i = len(keys) - 1
+	for ok := c.Last(); ok; ok = c.Prev() {
+		if i < 0 || string(c.Key()) != keys[i] {
+			t.Fatalf("backward entry %d = %x", i, c.Key())
+		}
+		i--
+	}
+	if i != -1 {
+		t.Fatalf("backward stopped with %d keys left", i+1)
+	}
+	for n := 0; n < 200; n++ {
+		seek := randomKey(rnd)
+		want := sort.SearchStrings(keys, string(seek))
+		ok := c.Seek(seek)
+		if ok != (want < len(keys)) || (ok && string(c.Key()) != keys[want]) {
+			t.Fatalf("Seek(%x) = %v %x, want index %d of %d", seek, ok, c.Key(), want, len(keys))
+		}
+	}
+}
+
+// randomKey 长度不等的 key，前缀集中在少数几个字节上，使分裂落在页中不同的位置
+func randomKey(rnd *rand.Rand) []byte {
+	key := make([]byte, 1+rnd.Intn(48))
+	rnd.Read(key)
+	key[0] = byte(rnd.Intn(16))
+	return key
+}
+
+// checkModel 逐个 Get 模型中的 key，并用游标遍历每棵树
+func checkModel(t *testing.T, rnd *rand.Rand, b *BTree, model propertyModel, deleted [][2]string) {
+	t.Helper()
+	for tree, values := range model {
+		for key, value := range values {
+			got, err := b.Get(externalKey(tree, key))
+			if err != nil || !bytes.Equal(got, value) {
+				t.Fatalf("Get(%q/%x) = %q, %v; want %q", tree, key, got, err, value)
+			}
+		}
+	}
+	for _, d := range deleted {
+		if _, ok := model[d[0]][d[1]]; ok {
+			continue // 删除后又写入
+		}
+		if got, err := b.Get(externalKey(d[0], d[1])); err != nil || got != nil {
+			t.Fatalf("deleted key %q/%x = %q, %v", d[0], d[1], got, err)
+		}
+	}
+	tx, err := b.Begin(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	for tree, values := range model {
+		c := tx.Cursor()
+		if tree != "" {
+			sub, err := tx.SubTree([]byte(tree))
+			if err != nil {
+				t.Fatal(err)
+			}
+			c = sub.Cursor()
+		}
+		checkCursor(t, rnd, c, model.sortedKeys(tree), values)
+	}
+}
+
+// TestTreeMatchesModel 随机写入、覆盖与删除大量长度不等的 key，推动叶子与分支页分裂、树增高与降低，
+// 每次提交及重新打开后 Get 与游标的结果都与 map 模型一致
+func TestTreeMatchesModel(t *testing.T) {
+	for _, seed := range []int64{1, 2} {
+		t.Run(fmt.Sprintf("seed=%d", seed), func(t *testing.T) {
+			rnd := rand.New(rand.NewSource(seed))
+			dir := t.TempDir()
+			b := openFreelistTree(t, dir)
+			defer func() { _ = b.Close() }()
+			model := propertyModel{"": {}}
+			for s := 0; s < 2; s++ {
+				name := bytes.Repeat([]byte{byte('a' + s)}, util.SubTreeNameLen)
+				model[string(name)] = map[string][]byte{}
+			}
+			trees := make([]string, 0, len(model))
+			for tree := range model {
+				trees = append(trees, tree)
+			}
+			sort.Strings(trees)
+
+			maxDepth := 0
+			for round := 0; round < 8; round++ {
+				var deleted [][2]string
+				ops := 6000
+				if round >= 6 {
+					ops = 3000 // 后几轮以删除为主，树随之降低
+				}
+				for i := 0; i < ops; i++ {
+					tree := trees[rnd.Intn(len(trees))]
+					values := model[tree]
+					var key string
+					if len(values) > 0 && rnd.Intn(3) == 0 {
+						for key = range values {
+							break
+						}
+					} else {
+						key = string(randomKey(rnd))
+					}
+					if round >= 6 || rnd.Intn(5) == 0 {
+						delete(values, key)
+						deleted = append(deleted, [2]string{tree, key})
+						if err := b.Delete(externalKey(tree, key)); err != nil {
+							t.Fatal(err)
+						}
+						continue
+					}
+					value := make([]byte, rnd.Intn(100))
+					rnd.Read(value)
+					values[key] = value
+					if err := b.Put(externalKey(tree, key), value); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if err := b.Commit(); err != nil {
+					t.Fatal(err)
+				}
+				if d := treeDepth(t, b); d > maxDepth {
+					maxDepth = d
+				}
+				checkModel(t, rnd, b, model, deleted)
+				if round%3 == 2 {
+					if err := b.Close(); err != nil {
+						t.Fatal(err)
+					}
+					b = openFreelistTree(t, dir)
+					checkModel(t, rnd, b, model, deleted)
+				}
+			}
+			if maxDepth < 3 {
+				t.Fatalf("tree only reached depth %d, branch pages never split", maxDepth)
+			}
+		})
+	}
+}
+
+// TestAdoptReleasesLockOnPanic adopt 中 put 的 panic 由任务恢复后，父节点的锁已释放
+func TestAdoptReleasesLockOnPanic(t *testing.T) {
+	parent := &node{}
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Fatal("adopt of a child without key did not panic")
+			}
+		}()
+		parent.adopt(&node{isLeaf: true}, 0)
+	}()
+	if !parent.nLock.TryLock() {
+		t.Fatal("adopt left the parent locked after a panic")
+	}
+	parent.nLock.Unlock()
+}
+
+// treePages 主树的叶子页与分支页数
+func treePages(t *testing.T, b *BTree) (leaves, branches int) {
+	t.Helper()
+	var walk func(id common.Pgid, overflow uint32)
+	walk = func(id common.Pgid, overflow uint32) {
+		n, err := b.pageNode(id, overflow)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n.isLeaf {
+			leaves++
+			return
+		}
+		branches++
+		children := append(common.Inodes(nil), n.inodes...)
+		for _, in := range children {
+			walk(in.Pgid(), in.Overflow())
+		}
+	}
+	if id := b.header.RootPage(); id != 0 {
+		walk(id, b.header.Overflow())
+	}
+	return leaves, branches
+}
+
+// TestDeleteShrinksTree 删除大部分 key 后，过小的兄弟叶子页合并，分支层随之减少，
+// 最终根回落为叶子页；每轮减少的页都进入 freelist
+func TestDeleteShrinksTree(t *testing.T) {
+	b := openTxTree(t)
+	defer b.Close()
+	const n = 20000
+	value := func(i int) []byte { return []byte(fmt.Sprintf("value-%d", i)) }
+	for i := 0; i < n; i++ {
+		if err := b.Put(txKey(i), value(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if depth := treeDepth(t, b); depth < 3 {
+		t.Fatalf("tree depth %d before deleting, want at least 3", depth)
+	}
+	leaves, branches := treePages(t, b)
+
+	// 每轮只保留 i%keep == 0 的 key
+	for _, keep := range []int{100, 1000, n} {
+		for i := 0; i < n; i++ {
+			if i%keep != 0 {
+				if err := b.Delete(txKey(i)); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		before := b.Stats()
+		if err := b.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		l, br := treePages(t, b)
+		// 不合并时每个仍有 key 的叶子页都会保留下来，约为 n/keep 个
+		if l >= n/keep && l > 1 {
+			t.Fatalf("keep %d: %d leaves after deleting, siblings were not merged", keep, l)
+		}
+		if br >= branches && branches > 0 {
+			t.Fatalf("keep %d: branch pages did not shrink from %d", keep, br)
+		}
+		s := b.Stats()
+		released := (leaves - l) + (branches - br)
+		if got := (s.FreeCount + s.PendingCount) - (before.FreeCount + before.PendingCount); got < released {
+			t.Fatalf("keep %d: %d pages freed, the tree shrank by %d", keep, got, released)
+		}
+		checkRange(t, b, txKey, n, func(i int) []byte {
+			if i%keep == 0 {
+				return value(i)
+			}
+			return nil
+		})
+		leaves, branches = l, br
+	}
+	// 只剩一个 key，根回落为叶子页
+	if depth := treeDepth(t, b); depth != 1 || branches != 0 || leaves != 1 {
+		t.Fatalf("tree with one key has depth %d, %d leaves, %d branches", depth, leaves, branches)
+	}
+}
@@ -3,22 +3,28 @@ package go_tsmm
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
+	"math"
+	"sync"
+
 	"github.com/breeze-go-rust/go-tsmm/internal/common"
 	"github.com/breeze-go-rust/go-tsmm/util/hasher"
-	"sync"
 )
 
 const (
-	ValueSize = 13
-	HashSize  = 20
+	ValueSize = 16 // fid(8B) + index(8B)
 )
 
+// leafSpillManager 将一个叶子节点合并后的 inode 依次切分成叶子页写出。
+// 两个 dataTemp 交替使用：dts[1] 接收新的 inode，写满后转入 dts[0] 等待刷盘，
+// 结束时若最后一页过小则与前一页合并，避免留下几乎为空的叶子页
 type leafSpillManager struct {
 	bTree          *BTree
 	n              *node
 	dts            []*dataTemp
 	compressEnable bool
 	threshold      int
+	hasher         *hasher.Hasher                                        // 本任务独占，不再逐个 inode 从池中取
 	update         func([]byte, uint64, uint64, uint64) (uint64, uint64) // data+fid+index+seq  fid+index
 	del            func(uint64, uint64)                                  // fid,index
 }
@@ -29,123 +35,134 @@ func newLeafSpillManager(tree *BTree, n *node, threshold int, compress bool) *le
 		n:     n,
 		dts: []*dataTemp{
 			nil,
-			newDataTemp(threshold, tree.dataBufferPool, tree.hashBufferPool),
+			newDataTemp(tree.hashBufferPool),
 		},
-		compressEnable: false,
+		compressEnable: compress,
 		threshold:      threshold,
+		hasher:         tree.newHash(),
 		update:         tree.vlog.Update,
 		del:            tree.vlog.Del,
 	}
 	return lsm
 }
 
-// 重新生成 value
-func (lsm *leafSpillManager) genInode(inode *common.Inode, oldFid uint64, oldIndex uint64, seq uint64) []byte {
-	value := inode.Value()
-	key := inode.Key()
-	h := hasher.NewHash()
-	res, _ := h.Hash(append(key, value...))
-	fid, index := lsm.update(res, oldFid, oldIndex, seq) // 直接拿到下标 就好了
+// 重新生成 value：value 写入 value log，叶子页中保存其 (fid, index)，
+// inode 哈希为 key 与原始 value 的哈希。子树元素的 value 是子树头，原样保存，
+// 哈希覆盖子树根哈希
+func (lsm *leafSpillManager) genInode(kv *common.Inode, oldFid uint64, oldIndex uint64, seq uint64) (*common.Inode, error) {
+	return genLeafInode(lsm.hasher, lsm.update, kv, oldFid, oldIndex, seq)
+}
+
+func genLeafInode(h *hasher.Hasher, update func([]byte, uint64, uint64, uint64) (uint64, uint64),
+	kv *common.Inode, oldFid uint64, oldIndex uint64, seq uint64) (*common.Inode, error) {
+	key, value := kv.Key(), kv.Value()
+	in := &common.Inode{}
+	in.SetKey(key)
+	in.SetFlags(kv.Flags())
+	kvBuf := make([]byte, 0, len(key)+len(value))
+	if kv.Flags()&common.SubTreeFlag != 0 {
+		res, _ := h.Hash(append(append(kvBuf, key...), kv.Hash()...))
+		in.SetValue(value)
+		in.SetHash(res)
+		return in, nil
+	}
+	res, _ := h.Hash(append(append(kvBuf, key...), value...))
+	fid, index := update(value, oldFid, oldIndex, seq)
+	if fid == math.MaxUint64 {
+		return nil, fmt.Errorf("value log update failed")
+	}
 	valueBuf := make([]byte, ValueSize)
 	binary.LittleEndian.PutUint64(valueBuf[:8], fid)     // 8字节写入文件句柄
 	binary.LittleEndian.PutUint64(valueBuf[8:16], index) // 8字节写入 索引号
-	inode.SetHash(res)
-	return nil
+	in.SetValue(valueBuf)
+	in.SetHash(res)
+	return in, nil
 }
 
-func (lsm *leafSpillManager) appendInode(inode *common.Inode, value []byte) {
+// valuePointer 叶子元素在 value log 中的位置，子树元素没有
+func valuePointer(in *common.Inode) (fid uint64, index uint64, ok bool) {
+	if in.Flags()&common.SubTreeFlag != 0 || len(in.Value()) != ValueSize {
+		return math.MaxUint64, math.MaxUint64, false
+	}
+	return binary.LittleEndian.Uint64(in.Value()[:8]), binary.LittleEndian.Uint64(in.Value()[8:16]), true
+}
+
+func (lsm *leafSpillManager) appendInode(inode *common.Inode) {
 	if inode == nil {
 		return
 	}
 	temp := lsm.dts[1] // 取活跃通道 1
-	elementSize := binary.MaxVarintLen64 + len(inode.Key()) + ValueSize + HashSize
-	tempSize := temp.size + elementSize
-	if len(temp.inodes)+1 < common.MinKeysPerPage*2 || tempSize < lsm.threshold {
-		if value != nil {
-			i := &common.Inode{}
-			i.SetKey(inode.Key())
-			i.SetValue(value)
-			i.SetHash(inode.Hash())
-			temp.inodes = append(temp.inodes, i)
+	elementSize := leafElementSize(inode)
+	if len(temp.inodes) >= common.MinKeysPerPage && temp.size+elementSize > lsm.threshold {
+		// 1 到了限制：0 刷盘后复用为新的 1，原来的 1 转入 0 等待
+		next := lsm.dts[0]
+		if next != nil {
+			lsm.flush(next)
 		} else {
-			temp.inodes = append(temp.inodes, inode)
+			next = newDataTemp(lsm.bTree.hashBufferPool)
 		}
-		temp.size = tempSize
+		lsm.dts[0] = temp
+		lsm.dts[1] = next
+		temp = next
 	}
-	// 1 到了限制，开始写 0
-	if lsm.dts[0] == nil {
-		lsm.dts[0] = newDataTemp(lsm.threshold, lsm.bTree.dataBufferPool, lsm.bTree.hashBufferPool)
-		lsm.dts[0], lsm.dts[1] = lsm.dts[1], lsm.dts[0]
-	} else {
-		// 0,1 都满了，将 0 刷盘
+	temp.inodes = append(temp.inodes, inode)
+	temp.hashBuffer.Write(inode.Hash())
+	temp.size += elementSize
+}
 
+// finish 刷出剩余的页。最后一页不足阈值的四分之一时与前一页合并后按大小重新对半分，
+// 前一页已接近阈值，直接并入会产生超出阈值的叶子页
+func (lsm *leafSpillManager) finish() {
+	last := lsm.dts[1]
+	if prev := lsm.dts[0]; prev != nil {
+		if last.size < lsm.threshold/4 {
+			prev.merge(last)
+			last.clear()
+			prev.splitHalf(last)
+		}
+		lsm.flush(prev)
 	}
+	lsm.flush(last)
 }
 
-func (lsm *leafSpillManager) flush() {
-	dt := lsm.dts[0]
+func (lsm *leafSpillManager) flush(dt *dataTemp) {
 	if len(dt.inodes) == 0 {
 		return
 	}
 	hero := &node{bTree: lsm.bTree, isLeaf: lsm.n.isLeaf, parent: lsm.n.parent, inodes: make(common.Inodes, len(dt.inodes))}
 	copy(hero.inodes, dt.inodes)
-	var data []byte
-	if lsm.compressEnable {
-		data = lsm.bTree.compressor.Encode(data, dt.dataBuffer.Bytes())
-	} else {
-		data = dt.dataBuffer.Bytes()
-	}
-	// 申请 Page
-	count := (len(data) + int(common.PageHeaderSize) + int(common.DefaultPageSize) - 1) / common.DefaultPageSize
-	hero.overflow = uint32(count) - 1
-	h := hasher.NewHash()
-	hash, _ := h.Hash(dt.hashBuffer.Bytes())
-	defer hasher.Return(h)
-	copy(hero.hash[:], hash)
-	p := lsm.bTree.allocate(count)
-	hero.pgid = p.Id()
-	p.SetCount(uint16(len(hero.inodes)))
-	p.SetFlags(common.LeafPageFlag)
-	p.SetSize(uint32(len(data)))
-
-	common.WriteInodeToPage(hero.inodes, p)
+	hero.key = hero.inodes[0].Key()
+	hero.hash, _ = lsm.hasher.Hash(dt.hashBuffer.Bytes())
+	// 申请 Page，启用压缩时按压缩后的长度分配
+	lsm.bTree.spill(hero, lsm.compressEnable)
+	filterID := lsm.bTree.writeFilterPage(hero.inodes)
 
 	if hero.parent != nil {
-		hero.parent.nLock.Lock()
-		hero.parent.put(hero.key, hero.key, nil, hero.pgid, hero.overflow, common.NormalTreeFlag, hero.hash[:])
-		hero.parent.nLock.Unlock()
+		hero.parent.adopt(hero, filterID)
 	}
 	dt.clear()
-	lsm.dts[0], lsm.dts[1] = lsm.dts[1], lsm.dts[0]
 }
 
 func (lsm *leafSpillManager) close() {
 	for _, dt := range lsm.dts {
 		if dt != nil {
-			dt.clear()
+			dt.release(lsm.bTree.hashBufferPool)
 		}
 	}
+	hasher.Return(lsm.hasher)
 }
 
 type dataTemp struct {
 	size       int
-	hasher     *hasher.Hasher
 	inodes     common.Inodes
-	dataBuffer *bytes.Buffer
-	hashBuffer *bytes.Buffer
-	lenBuf     [8]byte
+	hashBuffer *bytes.Buffer // 各 inode 哈希依次拼接，叶子页哈希为其哈希
 }
 
-func newDataTemp(size int, dataPool, hashPool *sync.Pool) *dataTemp {
+func newDataTemp(hashPool *sync.Pool) *dataTemp {
 	dt := &dataTemp{
-		size:       size,
-		lenBuf:     [8]byte{},
 		inodes:     make(common.Inodes, 0),
-		hasher:     hasher.NewHash(),
-		dataBuffer: dataPool.Get().(*bytes.Buffer),
 		hashBuffer: hashPool.Get().(*bytes.Buffer),
 	}
-	dt.dataBuffer.Reset()
 	dt.hashBuffer.Reset()
 	return dt
 }
@@ -157,14 +174,50 @@ func (dt *dataTemp) Size() int {
 func (dt *dataTemp) merge(src *dataTemp) {
 	dt.size += src.size
 	dt.inodes = append(dt.inodes, src.inodes...)
-	dt.dataBuffer.Write(src.dataBuffer.Bytes())
 	dt.hashBuffer.Write(src.hashBuffer.Bytes())
 }
 
-func (dt *dataTemp) clear() {
-	hasher.Return(dt.hasher)
+// splitHalf 把后一半（按大小）的 inode 移入空的 dst，两边各至少保留 MinKeysPerPage 个
+func (dt *dataTemp) splitHalf(dst *dataTemp) {
+	n := len(dt.inodes)
+	if n < 2*common.MinKeysPerPage {
+		return
+	}
+	i, size := 0, 0
+	for ; i < n-common.MinKeysPerPage; i++ {
+		elementSize := leafElementSize(dt.inodes[i])
+		if i >= common.MinKeysPerPage && size+elementSize > dt.size/2 {
+			break
+		}
+		size += elementSize
+	}
+	inodes := dt.inodes
+	dt.clear()
+	for _, in := range inodes[:i] {
+		dt.inodes = append(dt.inodes, in)
+		dt.hashBuffer.Write(in.Hash())
+	}
+	dt.size = size
+	for _, in := range inodes[i:] {
+		dst.inodes = append(dst.inodes, in)
+		dst.hashBuffer.Write(in.Hash())
+		dst.size += leafElementSize(in)
+	}
+}
+
+// leafElementSize inode 写入叶子页占用的字节数
+func leafElementSize(in *common.Inode) int {
+	return int(common.LeafPageElementSize) + len(in.Key()) + len(in.Value()) + len(in.Hash())
+}
 
-	dt.dataBuffer.Reset()
+func (dt *dataTemp) clear() {
+	dt.size = 0
 	dt.hashBuffer.Reset()
 	dt.inodes = common.Inodes{}
 }
+
+func (dt *dataTemp) release(hashPool *sync.Pool) {
+	dt.clear()
+	hashPool.Put(dt.hashBuffer)
+	dt.hashBuffer = nil
+}
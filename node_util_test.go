@@ -0,0 +1,57 @@
+package go_tsmm
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/breeze-go-rust/go-tsmm/internal/common"
+)
+
+func testDataTemp(pool *sync.Pool, from, n, valueLen int) *dataTemp {
+	dt := newDataTemp(pool)
+	for i := from; i < from+n; i++ {
+		in := &common.Inode{}
+		in.SetKey([]byte(fmt.Sprintf("key-%05d", i)))
+		in.SetValue(bytes.Repeat([]byte{'v'}, valueLen))
+		in.SetHash(bytes.Repeat([]byte{byte(i)}, 32))
+		dt.inodes = append(dt.inodes, in)
+		dt.hashBuffer.Write(in.Hash())
+		dt.size += leafElementSize(in)
+	}
+	return dt
+}
+
+// TestSplitHalfAfterMerge 接近阈值的前一页并入很小的尾页后按大小对半分，两页都不超过阈值且哈希顺序不变
+func TestSplitHalfAfterMerge(t *testing.T) {
+	pool := &sync.Pool{New: func() any { return new(bytes.Buffer) }}
+	const threshold = 4096
+	prev := testDataTemp(pool, 0, 32, 64)
+	last := testDataTemp(pool, 32, 3, 64)
+	if prev.size > threshold || last.size >= threshold/4 || prev.size+last.size <= threshold {
+		t.Fatalf("bad fixture: prev %d last %d", prev.size, last.size)
+	}
+	want := testDataTemp(pool, 0, 35, 64)
+
+	prev.merge(last)
+	last.clear()
+	prev.splitHalf(last)
+
+	if prev.size > threshold || last.size > threshold {
+		t.Fatalf("oversized page after split: %d/%d, threshold %d", prev.size, last.size, threshold)
+	}
+	if d := prev.size - last.size; d > want.size/10 || d < -want.size/10 {
+		t.Fatalf("uneven split: %d/%d", prev.size, last.size)
+	}
+	if len(prev.inodes)+len(last.inodes) != 35 || prev.size+last.size != want.size {
+		t.Fatalf("split lost inodes: %d+%d", len(prev.inodes), len(last.inodes))
+	}
+	hashes := append(append([]byte{}, prev.hashBuffer.Bytes()...), last.hashBuffer.Bytes()...)
+	if !bytes.Equal(hashes, want.hashBuffer.Bytes()) {
+		t.Fatal("hash buffers do not match the split inodes")
+	}
+	if !bytes.Equal(last.inodes[0].Key(), want.inodes[len(prev.inodes)].Key()) {
+		t.Fatal("inodes reordered by split")
+	}
+}
@@ -0,0 +1,64 @@
+package go_tsmm
+
+import (
+	"time"
+
+	"github.com/breeze-go-rust/go-tsmm/file"
+	"github.com/breeze-go-rust/go-tsmm/filter"
+	"github.com/breeze-go-rust/go-tsmm/util/hasher"
+)
+
+// Options BTree 打开参数
+type Options struct {
+	// DirectIO 页文件与 value log 以 O_DIRECT 打开，绕过操作系统页缓存，
+	// 避免与 cache.Cache 重复缓存。文件系统不支持时自动回退为普通 I/O
+	DirectIO bool
+
+	// Timeout 等待目录锁的超时时间，超时返回 errors.ErrTimeout。
+	// 为 0 时一直等待
+	Timeout time.Duration
+
+	// PageSize 新建时使用的页大小，必须是 4K 到 64K 之间的 2 的幂，
+	// 写入 meta 后不可更改。为 0 时新建使用操作系统页大小，打开时沿用 meta 中的值
+	PageSize int
+
+	// FillPercent 分支页分裂时每页的填充比例，取值在 0.1 到 1.0 之间，超出时取边界值。
+	// 为 0 时使用 DefaultFillPercent；顺序写入为主时调大可以减少分支页数量
+	FillPercent float64
+
+	// BulkFillPercent BulkLoad 装填叶子页与分支页的比例，取值范围同 FillPercent。
+	// 为 0 时使用 DefaultBulkFillPercent；导入后仍有大量随机写入时调小可以推迟分裂
+	BulkFillPercent float64
+
+	// CacheSize 页缓存容量（字节），主树与所有子树共享。
+	// 为 0 时使用 DefaultCacheSize，小于 0 时不缓存
+	CacheSize int
+
+	// CachePolicy 页缓存的淘汰策略，默认 CacheLRU。
+	// 需要全量遍历（如状态导出）时使用 CacheARC，避免冲掉热点分支页
+	CachePolicy CachePolicy
+
+	// Filter 为每个叶子页生成的过滤器，如 filter.NewBloomFilter(10)
+	// 或 filter.NewBinaryFuseFilter()，为 nil 时不生成。
+	// 更换过滤器后，已有的过滤器页按其记录的名称继续使用，直到叶子页被重写
+	Filter filter.Filter
+
+	// HashType 默克尔哈希使用的哈希函数，写入 meta 后不可更改，哈希长度随之确定。
+	// 为 0 时新建使用 hasher.DefaultHashType，打开时沿用 meta 中的值；
+	// 需要与 EVM 兼容时使用 hasher.Keccak256
+	HashType hasher.HashType
+
+	// BatchMemory 所有未提交批次（主树与全部子树）的内存预算（字节）。
+	// 超出时各批次排序后写入 spill 目录下的临时文件并清空，提交时与内存中的批次归并。
+	// 为 0 时使用 DefaultBatchMemory，小于 0 时不限制
+	BatchMemory int64
+
+	// FS 访问页文件、meta 文件与 value log 使用的文件系统，
+	// 为 nil 时使用 file.OS。测试可替换为 file.NewMemFS() 或 file.FaultFS
+	FS file.FS
+}
+
+// DefaultOptions 未传入 Options 时使用的默认参数
+var DefaultOptions = &Options{
+	DirectIO: false,
+}
@@ -4,7 +4,10 @@ import (
 	"fmt"
 	"github.com/breeze-go-rust/go-tsmm/file"
 	"github.com/breeze-go-rust/go-tsmm/internal/common"
+	"github.com/breeze-go-rust/go-tsmm/internal/compress"
 	"io"
+	"sync"
+	"sync/atomic"
 	"unsafe"
 )
 
@@ -13,21 +16,28 @@ type PageMgr struct {
 	noSync       bool
 	pageSize     uint64
 	pageFilePath string
+	bufPool      sync.Pool                                   // 单页缓冲区，页缓存淘汰时归还
+	dict         atomic.Pointer[compress.ZSTDDictCompressor] // 解压字典压缩的页，未加载字典时为 nil
 }
 
-func NewPageMgr(pageFilePath string, noSync bool) (*PageMgr, error) {
-	pFile, err := file.OpenFile(pageFilePath, file.NewFLocker())
+func NewPageMgr(fs file.FS, pageFilePath string, pageSize uint32, noSync bool, fileOpts *file.Options) (*PageMgr, error) {
+	pFile, err := file.OpenFile(fs, pageFilePath, fileOpts)
 	if err != nil {
 		return nil, fmt.Errorf("error opening page file %s: %w", pageFilePath, err)
 	}
-	return &PageMgr{pFile: pFile, pageFilePath: pageFilePath, noSync: noSync}, nil
+	return &PageMgr{pFile: pFile, pageFilePath: pageFilePath, pageSize: uint64(pageSize), noSync: noSync}, nil
 }
 
+func (pm *PageMgr) PageSize() uint32 {
+	return uint32(pm.pageSize)
+}
+
+// Write 写入页，不刷盘；提交时所有页写完后统一调用 Sync
 func (pm *PageMgr) Write(page *common.Page) error {
 	// 计算索引位
 	offset := uint64(page.Id()) * pm.pageSize
-	bufSize := (uint64(page.Id()) + uint64(page.Overflow())) * pm.pageSize
-	data := common.UnsafeByteSlice(unsafe.Pointer(page), 0, 0, 0)
+	bufSize := (uint64(page.Overflow()) + 1) * pm.pageSize
+	data := common.UnsafeByteSlice(unsafe.Pointer(page), 0, 0, int(bufSize))
 	n, err := pm.pFile.WriteAt(int64(offset), data)
 	if err != nil {
 		return fmt.Errorf("error writing to page file %s: %w", pm.pageFilePath, err)
@@ -35,13 +45,51 @@ func (pm *PageMgr) Write(page *common.Page) error {
 	if uint64(n) != bufSize {
 		return fmt.Errorf("error writing to page file %s: %w", pm.pageFilePath, io.ErrShortWrite)
 	}
-	return Sync(!pm.noSync, pm.pFile.Sync)
+	return nil
+}
+
+// Sync 刷盘页文件
+func (pm *PageMgr) Sync() error {
+	return Sync(pm.noSync, pm.pFile.Sync)
 }
 
 func (pm *PageMgr) ReadAt(pid common.Pgid, overflow uint32) (*common.Page, error) {
+	buf, err := pm.read(pid, overflow)
+	if err != nil {
+		return nil, err
+	}
+	return (*common.Page)(unsafe.Pointer(&buf[0])), nil
+}
+
+// read 读取页并返回其缓冲区。压缩的页按页头记录的编码方式解压，
+// 与当前配置的压缩方式无关，返回的页体总是未压缩的
+func (pm *PageMgr) read(pid common.Pgid, overflow uint32) ([]byte, error) {
+	buf, err := pm.readRaw(pid, overflow)
+	if err != nil {
+		return nil, err
+	}
+	return pm.decode(pid, buf)
+}
+
+// readFull 读取页号 pid 处的完整页，overflow 取自页头
+func (pm *PageMgr) readFull(pid common.Pgid) ([]byte, error) {
+	buf, err := pm.readRaw(pid, 0)
+	if err != nil {
+		return nil, err
+	}
+	overflow := (*common.Page)(unsafe.Pointer(&buf[0])).Overflow()
+	if overflow == 0 {
+		return pm.decode(pid, buf)
+	}
+	pm.free(buf)
+	return pm.read(pid, overflow)
+}
+
+// readRaw 读取页的原始内容，不解压
+func (pm *PageMgr) readRaw(pid common.Pgid, overflow uint32) ([]byte, error) {
 	offset := uint64(pid) * pm.pageSize
-	bufSize := (uint64(pid) + uint64(overflow)) * pm.pageSize
-	buf := make([]byte, bufSize)
+	bufSize := (uint64(overflow) + 1) * pm.pageSize
+	buf := pm.alloc(int(bufSize))
 	n, err := pm.pFile.ReadAt(int64(offset), buf)
 	if err != nil {
 		return nil, fmt.Errorf("error reading from page file %s: %w", pm.pageFilePath, err)
@@ -49,11 +97,78 @@ func (pm *PageMgr) ReadAt(pid common.Pgid, overflow uint32) (*common.Page, error
 	if n != int(bufSize) {
 		return nil, fmt.Errorf("error reading from page file %s: %w", pm.pageFilePath, io.ErrShortWrite)
 	}
-	return (*common.Page)(unsafe.Pointer(&buf[0])), nil
+	return buf, nil
+}
+
+func (pm *PageMgr) decode(pid common.Pgid, buf []byte) ([]byte, error) {
+	p := (*common.Page)(unsafe.Pointer(&buf[0]))
+	if p.Codec() == uint8(compress.CodecNone) {
+		return buf, nil
+	}
+	hdr := int(common.PageHeaderSize)
+	if hdr+int(p.Size()) > len(buf) {
+		return nil, fmt.Errorf("page %d: compressed size %d exceeds page", pid, p.Size())
+	}
+	var (
+		body []byte
+		err  error
+		src  = buf[hdr : hdr+int(p.Size())]
+	)
+	if codec := compress.Codec(p.Codec()); codec == compress.CodecZSTDDict {
+		d := pm.dict.Load()
+		if d == nil {
+			return nil, fmt.Errorf("page %d: decode %v: no dictionary loaded", pid, codec)
+		}
+		body, err = d.Decode(nil, src)
+	} else {
+		body, err = compress.Decode(codec, nil, src)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("page %d: decode %v: %w", pid, compress.Codec(p.Codec()), err)
+	}
+	raw := make([]byte, hdr+len(body))
+	copy(raw, buf[:hdr])
+	copy(raw[hdr:], body)
+	pm.free(buf)
+	p = (*common.Page)(unsafe.Pointer(&raw[0]))
+	p.SetCodec(uint8(compress.CodecNone))
+	p.SetSize(uint32(len(body)))
+	return raw, nil
+}
+
+// Size 页文件大小
+func (pm *PageMgr) Size() (int64, error) {
+	return pm.pFile.Size()
+}
+
+func (pm *PageMgr) Close() error {
+	return pm.pFile.Close()
+}
+
+// alloc 分配页缓冲区，直接 I/O 模式下按块对齐，写入时无需再拷贝
+func (pm *PageMgr) alloc(size int) []byte {
+	if uint64(size) == pm.pageSize {
+		if buf, ok := pm.bufPool.Get().([]byte); ok {
+			clear(buf)
+			return buf
+		}
+	}
+	if pm.pFile.DirectIO() {
+		return file.AlignedBlock(size)
+	}
+	return make([]byte, size)
+}
+
+// free 归还 alloc 分配的缓冲区，调用方之后不能再引用 buf
+func (pm *PageMgr) free(buf []byte) {
+	if uint64(len(buf)) == pm.pageSize {
+		pm.bufPool.Put(buf)
+	}
 }
 
-func Sync(condition bool, f func() error) error {
-	if !condition {
+// Sync noSync 为 false 时执行 f
+func Sync(noSync bool, f func() error) error {
+	if !noSync {
 		return f()
 	}
 	return nil
@@ -0,0 +1,141 @@
+package go_tsmm
+
+import (
+	"fmt"
+	"hash/fnv"
+
+	"github.com/breeze-go-rust/go-tsmm/cache"
+	"github.com/breeze-go-rust/go-tsmm/internal/common"
+)
+
+// DefaultCacheSize 页缓存默认容量（字节）
+const DefaultCacheSize = 32 << 20
+
+// cachedNode 页缓存中的干净节点，由页解码得到，inodes 直接引用页缓冲区。
+// 节点被淘汰且不再被引用时，Release 将页缓冲区归还给 PageMgr
+type cachedNode struct {
+	n   *node
+	buf []byte
+	pm  *PageMgr
+}
+
+// CachePriority 分支页被所有经过它的查找共享，优先于叶子页保留
+func (c *cachedNode) CachePriority() cache.Priority {
+	if c.n != nil && !c.n.isLeaf {
+		return cache.PriorityHigh
+	}
+	return cache.PriorityLow
+}
+
+func (c *cachedNode) Release() {
+	c.pm.free(c.buf)
+	c.n, c.buf = nil, nil
+}
+
+// CachePolicy 页缓存的淘汰策略
+type CachePolicy int
+
+const (
+	// CacheLRU 最近最少使用，一次全量遍历会冲掉所有热点页
+	CacheLRU CachePolicy = iota
+	// CacheARC 自适应替换缓存，抗扫描，分支页直接进入高频队列
+	CacheARC
+)
+
+// newPageCache 创建主树与所有子树共享的页缓存，size < 0 时不缓存
+func newPageCache(size int, policy CachePolicy) (*cache.Cache, error) {
+	if size < 0 {
+		return nil, nil
+	}
+	if size == 0 {
+		size = DefaultCacheSize
+	}
+	switch policy {
+	case CacheLRU:
+		return cache.NewCache(cache.NewLRU(size)), nil
+	case CacheARC:
+		return cache.NewCache(cache.NewARC(size)), nil
+	}
+	return nil, fmt.Errorf("unknown cache policy %d", policy)
+}
+
+// namespace 返回子树在页缓存中的命名空间，主树使用 0。命名空间由子树名决定，
+// 同一子树每次打开（包括 Get 临时构建的子树）都命中同一批缓存页，页被释放时也能从中删除。
+// 不同子树的命名空间偶然相同时只是共用一段空间：页号在整个页文件中唯一，不会读到别的页
+func (b *BTree) namespace(name string) *cache.NamespaceGetter {
+	tree := b.parent()
+	if tree.nodeCache == nil {
+		return nil
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(name))
+	return &cache.NamespaceGetter{Cache: tree.nodeCache, NS: h.Sum64() | 1<<63}
+}
+
+// lookupNode 从页缓存中读取节点，未命中时读页、解码后放入缓存。
+// 返回的节点只读，使用完后释放句柄；未启用缓存时句柄为 nil
+func (b *BTree) lookupNode(id common.Pgid, overflow uint32) (*node, *cache.Handle, error) {
+	if b.pageCache == nil {
+		n, err := b.readPageNode(id, overflow)
+		return n, nil, err
+	}
+	tree := b.parent()
+	var rerr error
+	h := b.pageCache.Get(uint64(id), func() (int, cache.Value) {
+		p, buf, err := b.page(id, overflow)
+		if err != nil {
+			rerr = err
+			return 0, nil
+		}
+		n := &node{bTree: b}
+		n.read(p)
+		return len(buf), &cachedNode{n: n, buf: buf, pm: tree.pageMgr}
+	})
+	if h == nil {
+		if rerr != nil {
+			return nil, nil, rerr
+		}
+		// 缓存已关闭
+		n, err := b.readPageNode(id, overflow)
+		return n, nil, err
+	}
+	cn, ok := h.Value().(*cachedNode)
+	if !ok || cn.n.overflow != overflow {
+		h.Release()
+		n, err := b.readPageNode(id, overflow)
+		return n, nil, err
+	}
+	return cn.n, h, nil
+}
+
+// cachedPageNode 返回缓存节点的副本，可以直接修改；副本引用的页缓冲区
+// 由缓存句柄固定，句柄在提交或回滚时统一释放
+func (b *BTree) cachedPageNode(id common.Pgid, overflow uint32) (*node, error) {
+	n, h, err := b.lookupNode(id, overflow)
+	if err != nil || h == nil {
+		return n, err
+	}
+	tree := b.parent()
+	tree.handleLock.Lock()
+	tree.handles = append(tree.handles, h)
+	tree.handleLock.Unlock()
+	return n.clone(b), nil
+}
+
+// evictPage 页被释放后从缓存中删除，避免页号被复用后读到旧节点
+func (b *BTree) evictPage(id common.Pgid) {
+	if b.pageCache != nil {
+		b.pageCache.Cache.Delete(b.pageCache.NS, uint64(id), nil)
+	}
+}
+
+// releaseHandles 释放本次提交期间固定的缓存句柄
+func (b *BTree) releaseHandles() {
+	b.handleLock.Lock()
+	handles := b.handles
+	b.handles = nil
+	b.handleLock.Unlock()
+	for _, h := range handles {
+		h.Release()
+	}
+}
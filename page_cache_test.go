@@ -0,0 +1,68 @@
+package go_tsmm
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/breeze-go-rust/go-tsmm/cache"
+	"github.com/breeze-go-rust/go-tsmm/util"
+)
+
+// TestSubTreeCacheNamespaceStable 重新打开后反复读取未打开的子树，缓存的页数不再增长
+func TestSubTreeCacheNamespaceStable(t *testing.T) {
+	dir := t.TempDir()
+	name := bytes.Repeat([]byte{0xab}, util.SubTreeNameLen)
+	key := func(i int) []byte {
+		return append(append(util.StoragePrefix(), name...), []byte(fmt.Sprintf("slot-%05d", i))...)
+	}
+	b := openFreelistTree(t, dir)
+	for i := 0; i < 2000; i++ {
+		if err := b.Put(key(i), []byte(fmt.Sprintf("value-%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b = openFreelistTree(t, dir)
+	defer b.Close()
+	read := func() {
+		for i := 0; i < 2000; i += 7 {
+			got, err := b.Get(key(i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := fmt.Sprintf("value-%d", i); string(got) != want {
+				t.Fatalf("slot %d = %q, want %q", i, got, want)
+			}
+		}
+	}
+	read()
+	nodes := b.nodeCache.Nodes()
+	for round := 0; round < 5; round++ {
+		read()
+	}
+	if got := b.nodeCache.Nodes(); got != nodes {
+		t.Fatalf("cached nodes grew from %d to %d on repeated reads", nodes, got)
+	}
+}
+
+// TestCachedNodePriority 分支页以高优先级进入页缓存，叶子页不带提示
+func TestCachedNodePriority(t *testing.T) {
+	for _, tc := range []struct {
+		n    *node
+		want cache.Priority
+	}{
+		{&node{isLeaf: false}, cache.PriorityHigh},
+		{&node{isLeaf: true}, cache.PriorityLow},
+	} {
+		if got := (&cachedNode{n: tc.n}).CachePriority(); got != tc.want {
+			t.Fatalf("leaf %v: priority = %v, want %v", tc.n.isLeaf, got, tc.want)
+		}
+	}
+}
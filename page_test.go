@@ -0,0 +1,123 @@
+package go_tsmm
+
+import (
+	"fmt"
+	"testing"
+	"unsafe"
+
+	"github.com/breeze-go-rust/go-tsmm/internal/common"
+	"github.com/breeze-go-rust/go-tsmm/internal/compress"
+)
+
+func openCodecTree(t *testing.T, dir, compressType string) *BTree {
+	t.Helper()
+	b, err := NewBTree(false, false, true, dir, compressType, 3, 0, "", 0, 0, &Options{PageSize: 4096})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func codecValue(round, i int) []byte {
+	return []byte(fmt.Sprintf("value-%d-%d-%s", round, i, "abcdefghijklmnopqrstuvwxyz"))
+}
+
+func putCodecRound(t *testing.T, b *BTree, round, from, to int) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		if err := b.Put(txKey(i), codecValue(round, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkCodecValues key i 的 value 为 codecValue(round(i), i)
+func checkCodecValues(t *testing.T, b *BTree, n int, round func(int) int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		got, err := b.Get(txKey(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := codecValue(round(i), i); string(got) != string(want) {
+			t.Fatalf("key %d = %q, want %q", i, got, want)
+		}
+	}
+}
+
+// leafCodecs 统计已提交版本中可达的叶子页按编码方式的页数
+func leafCodecs(t *testing.T, b *BTree) map[compress.Codec]int {
+	t.Helper()
+	codecs := make(map[compress.Codec]int)
+	var walk func(id common.Pgid, overflow uint32)
+	walk = func(id common.Pgid, overflow uint32) {
+		raw, err := b.pageMgr.readRaw(id, overflow)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := (*common.Page)(unsafe.Pointer(&raw[0]))
+		isLeaf, codec := p.IsLeafPage(), compress.Codec(p.Codec())
+		b.pageMgr.free(raw)
+		if isLeaf {
+			codecs[codec]++
+			return
+		}
+		n, err := b.pageNode(id, overflow)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, in := range n.inodes {
+			walk(in.Pgid(), in.Overflow())
+		}
+	}
+	walk(b.header.RootPage(), b.header.Overflow())
+	return codecs
+}
+
+// TestReopenWithDifferentCodec 每页记录自己的编码方式，换用其他 compressType 重新打开后旧页仍可读取，
+// 新写出的页使用新的编码方式
+func TestReopenWithDifferentCodec(t *testing.T) {
+	dir := t.TempDir()
+	b := openCodecTree(t, dir, "zstd")
+	putCodecRound(t, b, 0, 0, 2000)
+	if got := leafCodecs(t, b); got[compress.CodecZSTD] == 0 {
+		t.Fatalf("leaf codecs after writing with zstd = %v", got)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b = openCodecTree(t, dir, "lz4")
+	checkCodecValues(t, b, 2000, func(int) int { return 0 })
+	putCodecRound(t, b, 1, 0, 500)
+	got := leafCodecs(t, b)
+	if got[compress.CodecLZ4] == 0 || got[compress.CodecZSTD] == 0 {
+		t.Fatalf("leaf codecs after rewriting part of the keys with lz4 = %v", got)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b = openCodecTree(t, dir, "direct")
+	defer b.Close()
+	round := func(i int) int {
+		if i < 500 {
+			return 1
+		}
+		return 0
+	}
+	checkCodecValues(t, b, 2000, round)
+	putCodecRound(t, b, 2, 1500, 2000)
+	if got := leafCodecs(t, b); got[compress.CodecNone] == 0 {
+		t.Fatalf("leaf codecs after rewriting part of the keys uncompressed = %v", got)
+	}
+	checkCodecValues(t, b, 2000, func(i int) int {
+		if i >= 1500 {
+			return 2
+		}
+		return round(i)
+	})
+}
@@ -0,0 +1,232 @@
+package go_tsmm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/breeze-go-rust/go-tsmm/internal/common"
+	"github.com/breeze-go-rust/go-tsmm/util"
+	"github.com/panjf2000/ants/v2"
+)
+
+// pipeline 一棵树一次提交的更新流水线。
+// 自上而下：分支节点按 key 切分批次，把子节点分发到 leafNodePool；
+// 自下而上：叶子节点合并、哈希、写出后把新元素放回父节点并递减父节点的 childrenNum，
+// 计数归零的分支节点由 branchNodePool 计算哈希并写出，依次向上直到虚拟根 top。
+// 各层之间没有屏障，一个分支节点的子节点全部完成即可开始，不必等待同层其他节点
+type pipeline struct {
+	tree *BTree
+	top  *node // 虚拟根，只收集根节点写出的元素，不写页
+	wg   sync.WaitGroup
+	err  atomic.Pointer[error]
+}
+
+func newPipeline(tree *BTree) *pipeline {
+	return &pipeline{tree: tree, top: &node{bTree: tree}}
+}
+
+func (pl *pipeline) fail(err error) {
+	pl.err.CompareAndSwap(nil, &err)
+}
+
+func (pl *pipeline) failed() bool {
+	return pl.err.Load() != nil
+}
+
+func (pl *pipeline) error() error {
+	if err := pl.err.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
+// recover 由任务 defer 调用，任务中的 panic 使本次更新失败。
+// 工作池默认只记录 panic，任务的子树结果缺失后提交仍会成功
+func (pl *pipeline) recover() {
+	if r := recover(); r != nil {
+		pl.fail(fmt.Errorf("update task panic: %v", r))
+	}
+}
+
+// submit 提交任务，任务结束时 wg.Done；提交失败时视同 t.n 已完成
+func (pl *pipeline) submit(pool *ants.MultiPoolWithFunc, t *task) {
+	pl.wg.Add(1)
+	if err := pool.Invoke(t); err != nil {
+		pl.wg.Done()
+		pl.fail(err)
+		pl.childDone(t.n.parent)
+	}
+}
+
+// childDone 子节点完成，父节点的子节点全部完成时提交父节点的哈希任务
+func (pl *pipeline) childDone(parent *node) {
+	if parent == nil || atomic.AddInt32(&parent.childrenNum, -1) != 0 || parent == pl.top {
+		return
+	}
+	pl.submit(pl.tree.parent().branchNodePool, &task{pl: pl, n: parent})
+}
+
+// update 将有序的 kvs 写入本树，完成后更新树头与 rootHash
+func (b *BTree) update(kvs common.Inodes) error {
+	if len(kvs) == 0 {
+		return nil
+	}
+	var (
+		root *node
+		err  error
+	)
+	if b.header.RootPage() == 0 {
+		root = &node{isLeaf: true, bTree: b}
+	} else if root, err = b.pageNode(b.header.RootPage(), b.header.Overflow()); err != nil {
+		return err
+	}
+	pl := newPipeline(b)
+	root.parent = pl.top
+	pl.top.childrenNum = 1
+	pl.submit(b.parent().leafNodePool, &task{pl: pl, n: root, kvs: kvs, from: 0, to: len(kvs) - 1})
+	pl.wg.Wait()
+	if err := pl.error(); err != nil {
+		return err
+	}
+	return b.setRoot(pl.top)
+}
+
+// setRoot 以 top 收集到的元素更新树头与 rootHash。
+// 根节点分裂时 top 收集到多个元素，top 成为新的根，
+// 新根超过一页时继续分裂，直到只剩一个元素，树随之增高
+func (b *BTree) setRoot(top *node) error {
+	top.rebalance()
+	for len(top.inodes) > 1 {
+		next := &node{bTree: b}
+		for _, part := range top.spillBranch() {
+			next.adopt(part, 0)
+		}
+		top = next
+	}
+	// 删除后根只剩一个子节点时，子节点成为新的根，树随之降低
+	for len(top.inodes) == 1 {
+		root, err := top.childNode(top.inodes[0])
+		if err != nil {
+			return err
+		}
+		if root.isLeaf || len(root.inodes) != 1 {
+			break
+		}
+		root.release()
+		top = root
+	}
+	if len(top.inodes) == 0 { // 全部删除
+		b.header.SetRootPage(0)
+		b.header.SetOverflow(0)
+		b.rootHash = nil
+		return nil
+	}
+	in := top.inodes[0]
+	// 根页没有父节点记录过滤器页，查找时也不经过它
+	b.freeFilterPage(in.Filter())
+	b.header.SetRootPage(in.Pgid())
+	b.header.SetOverflow(in.Overflow())
+	b.rootHash = in.Hash()
+	return nil
+}
+
+type subTreeTask struct {
+	wg   *sync.WaitGroup
+	tree *BTree
+	err  error
+}
+
+func (t *subTreeTask) subBTreePoolTask() {
+	defer t.wg.Done()
+	defer func() {
+		if r := recover(); r != nil {
+			t.err = fmt.Errorf("update sub tree %x panic: %v", t.tree.header.Name(), r)
+		}
+	}()
+	t.err = t.tree.update(t.tree.batch.Dump())
+}
+
+// updateBatch 将本树的批次写入树中：先执行范围删除，批次有 spill run 时归并后分段写入
+func (b *BTree) updateBatch(kvs common.Inodes) error {
+	if len(b.ranges) > 0 {
+		if err := b.deleteRanges(); err != nil {
+			return err
+		}
+	}
+	if len(b.runs) == 0 {
+		return b.update(kvs)
+	}
+	return b.updateRuns(kvs)
+}
+
+// Update 先在 subBTreePool 中并行更新所有脏子树，有 spill run 或范围删除的子树随后逐个更新，
+// 这些更新中途会把已写好的页落盘，不能与其他更新并发；再把子树根折叠进主树：
+// 每棵子树在主树中对应一个带 SubTreeFlag 的元素，key 为 subTreeKey，value 为子树头，
+// 哈希覆盖子树根哈希，子树被清空时删除该元素；最后更新主树
+func (b *BTree) Update() error {
+	kvs := b.batch.Dump()
+	if dirty := b.dirtySubTrees(); len(dirty) != 0 {
+		var wg sync.WaitGroup
+		tasks := make([]*subTreeTask, 0, len(dirty))
+		var spilled []*subTreeTask
+		for _, tree := range dirty {
+			t := &subTreeTask{wg: &wg, tree: tree}
+			tasks = append(tasks, t)
+			if len(tree.runs) > 0 || len(tree.ranges) > 0 {
+				spilled = append(spilled, t)
+				continue
+			}
+			wg.Add(1)
+			if err := b.subBTreePool.Invoke(t); err != nil {
+				wg.Done()
+				t.err = err
+			}
+		}
+		wg.Wait()
+		for _, t := range spilled {
+			t.err = t.tree.updateBatch(t.tree.batch.Dump())
+		}
+		for _, t := range tasks {
+			if t.err != nil {
+				return t.err
+			}
+			in := &common.Inode{}
+			in.SetKey(subTreeKey(t.tree.header.Name()))
+			in.SetFlags(common.SubTreeFlag)
+			if t.tree.header.RootPage() != 0 {
+				in.SetValue(encodeSubTree(t.tree.header))
+				in.SetHash(t.tree.rootHash)
+			}
+			kvs = append(kvs, in)
+		}
+		sort.SliceStable(kvs, func(i, j int) bool { return compareKeys(kvs[i].Key(), kvs[j].Key()) < 0 })
+	}
+	return b.updateBatch(kvs)
+}
+
+// subTreeKey 子树在主树中元素的 key：-storage 加子树名。splitKey 拒绝以 -storage 开头的账户 key，
+// 子树元素不会与账户共用 key
+func subTreeKey(name string) []byte {
+	return append(util.StoragePrefix(), name...)
+}
+
+// subTreeValueSize 子树头：根页号(8B) | overflow(4B) | sequence(8B)
+const subTreeValueSize = 20
+
+func encodeSubTree(h *common.InBTree) []byte {
+	v := make([]byte, subTreeValueSize)
+	binary.LittleEndian.PutUint64(v[0:8], uint64(h.RootPage()))
+	binary.LittleEndian.PutUint32(v[8:12], h.Overflow())
+	binary.LittleEndian.PutUint64(v[12:20], h.InSequence())
+	return v
+}
+
+func decodeSubTree(name string, v []byte) *common.InBTree {
+	if len(v) != subTreeValueSize {
+		return nil
+	}
+	return common.NewInBTree(common.Pgid(binary.LittleEndian.Uint64(v[0:8])), binary.LittleEndian.Uint32(v[8:12]), name, binary.LittleEndian.Uint64(v[12:20]))
+}
@@ -0,0 +1,280 @@
+package go_tsmm
+
+import (
+	"bytes"
+
+	"github.com/breeze-go-rust/go-tsmm/errors"
+	"github.com/breeze-go-rust/go-tsmm/internal/common"
+	"github.com/breeze-go-rust/go-tsmm/util"
+)
+
+// rangeTombstone 一次范围删除，key 为树内 key，limit 为 nil 时不设上界。
+// 删除作用于已提交的树与记录删除之前的批次：内存中的批次在记录时直接删除，
+// 已写出的 spill run 中只有前 runs 个在删除之前
+type rangeTombstone struct {
+	start []byte
+	limit []byte
+	runs  int
+}
+
+func (t *rangeTombstone) contains(key []byte) bool {
+	return bytes.Compare(key, t.start) >= 0 && (t.limit == nil || bytes.Compare(key, t.limit) < 0)
+}
+
+// covers 判断 [lo, hi) 是否整个落在范围内，lo 为 nil 时不设下界，hi 为 nil 时不设上界
+func (t *rangeTombstone) covers(lo, hi []byte) bool {
+	if len(t.start) > 0 && (lo == nil || bytes.Compare(lo, t.start) < 0) {
+		return false
+	}
+	return t.limit == nil || (hi != nil && bytes.Compare(hi, t.limit) <= 0)
+}
+
+// DeleteRange 删除 [r.Start, r.Limit) 内的所有 key，提交后生效。范围之前写入批次的 key 一并删除，
+// 之后写入的不受影响。范围只能落在账户 key 或单个子树的 storage key 之内，否则返回 errors.ErrInvalidRange；
+// 覆盖整个子树时子树随之删除
+func (b *BTree) DeleteRange(r util.Range) error {
+	tree := b.parent()
+	if tree.isReadOnly {
+		return errors.ErrDatabaseReadOnly
+	}
+	name, start, limit, err := splitRange(r)
+	if err != nil {
+		return err
+	}
+	if limit != nil && bytes.Compare(start, limit) >= 0 {
+		return nil
+	}
+	// 持写锁，批次的删除与 spill run 的计数不会与 Put、spill 或提交交错
+	tree.spillLock.Lock()
+	target := tree
+	if name != nil {
+		if target, err = tree.createIfNotExists(string(name)); err != nil {
+			tree.spillLock.Unlock()
+			return err
+		}
+		tree.markDirty(target)
+	}
+	delta := target.batch.deleteRange(start, limit)
+	target.ranges = append(target.ranges, rangeTombstone{start: start, limit: limit, runs: len(target.runs)})
+	tree.spillLock.Unlock()
+	return tree.addBatchBytes(delta)
+}
+
+// DeletePrefix 删除以 prefix 开头的所有 key，prefix 为 -storage 加子树名时删除整个子树
+func (b *BTree) DeletePrefix(prefix []byte) error {
+	return b.DeleteRange(*util.BytesPrefix(prefix))
+}
+
+// splitRange 将外部 key 的范围换算为单棵树内的范围，账户 key 的子树名为 nil。
+// r.Limit 可以是账户 key 或子树 key 前缀的上界，此时树内范围不设上界
+func splitRange(r util.Range) (name, start, limit []byte, err error) {
+	if r.Start == nil || r.Limit == nil {
+		return nil, nil, nil, errors.ErrInvalidRange
+	}
+	var prefix []byte
+	switch {
+	case bytes.HasPrefix(r.Start, util.AccountPrefix()):
+		prefix = util.AccountPrefix()
+	case bytes.HasPrefix(r.Start, util.StoragePrefix()) && len(r.Start) >= len(util.StoragePrefix())+util.SubTreeNameLen:
+		prefix = r.Start[:len(util.StoragePrefix())+util.SubTreeNameLen]
+		name = prefix[len(util.StoragePrefix()):]
+	default:
+		return nil, nil, nil, errors.ErrInvalidRange
+	}
+	start = append([]byte(nil), r.Start[len(prefix):]...)
+	switch {
+	case bytes.HasPrefix(r.Limit, prefix):
+		limit = append([]byte(nil), r.Limit[len(prefix):]...)
+	case bytes.Equal(r.Limit, util.BytesPrefix(prefix).Limit):
+	default:
+		return nil, nil, nil, errors.ErrInvalidRange
+	}
+	return append([]byte(nil), name...), start, limit, nil
+}
+
+// covered 判断第 run 个 spill run 中的 key 是否被之后记录的范围删除
+func (b *BTree) covered(key []byte, run int) bool {
+	for i := range b.ranges {
+		if b.ranges[i].runs > run && b.ranges[i].contains(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// rangePruner 在已提交的树上执行一次范围删除。整个落在范围内的子树直接释放各页，
+// 叶子页中被删除元素的 value log 记录统一在最后释放
+type rangePruner struct {
+	tree    *BTree
+	r       *rangeTombstone
+	keepSub bool // 主树中的子树元素不属于账户 key，不被删除
+	ptrs    [][2]uint64
+}
+
+// deleteRanges 依次执行本树记录的范围删除。之后的范围删除与批次更新会读取写出的页，
+// 每次删除后这些页先落到页文件，因此不能与其他树的更新并发
+func (b *BTree) deleteRanges() error {
+	p := &rangePruner{tree: b, keepSub: !b.isSubBTree}
+	for i := range b.ranges {
+		if b.header.RootPage() == 0 {
+			break
+		}
+		root, err := b.pageNode(b.header.RootPage(), b.header.Overflow())
+		if err != nil {
+			return err
+		}
+		p.r = &b.ranges[i]
+		top := &node{bTree: b}
+		if p.r.covers(nil, nil) && !p.keepSub {
+			if err := p.free(root); err != nil {
+				return err
+			}
+		} else {
+			parts, filter, changed, err := p.prune(root)
+			if err != nil {
+				return err
+			}
+			if !changed {
+				continue
+			}
+			for _, part := range parts {
+				top.adopt(part, filter)
+			}
+		}
+		if err := b.setRoot(top); err != nil {
+			return err
+		}
+		// 下一个范围删除与批次更新会读取本次写出的页
+		tree := b.parent()
+		tree.releaseHandles()
+		if err := tree.writeDirtyPages(); err != nil {
+			return err
+		}
+	}
+	if len(p.ptrs) > 0 {
+		b.parent().vlog.DelBatch(p.ptrs)
+	}
+	return nil
+}
+
+// prune 删除 n 中落在范围内的元素。n 改变时释放原页并写出新页，返回新页（全部删除时为空）
+// 与叶子页的过滤器页
+func (p *rangePruner) prune(n *node) (nodes, common.Pgid, bool, error) {
+	if n.isLeaf {
+		return p.pruneLeaf(n)
+	}
+	changed, err := p.pruneBranch(n)
+	if err != nil || !changed {
+		return nil, 0, false, err
+	}
+	n.free()
+	n.rebalance()
+	return n.spillBranch(), 0, true, nil
+}
+
+func (p *rangePruner) pruneLeaf(n *node) (nodes, common.Pgid, bool, error) {
+	kept := make(common.Inodes, 0, len(n.inodes))
+	for _, in := range n.inodes {
+		if !p.r.contains(in.Key()) || (p.keepSub && in.Flags()&common.SubTreeFlag != 0) {
+			kept = append(kept, in)
+			continue
+		}
+		if fid, index, ok := valuePointer(in); ok {
+			p.ptrs = append(p.ptrs, [2]uint64{fid, index})
+		}
+	}
+	if len(kept) == len(n.inodes) {
+		return nil, 0, false, nil
+	}
+	n.free()
+	if len(kept) == 0 {
+		return nil, 0, true, nil
+	}
+	tree := p.tree.parent()
+	leaf := &node{bTree: p.tree, isLeaf: true, inodes: kept, key: kept[0].Key()}
+	leaf.hashChildren()
+	p.tree.spill(leaf, tree.EnableCompress())
+	return nodes{leaf}, p.tree.writeFilterPage(kept), true, nil
+}
+
+// pruneBranch 处理与范围相交的子节点，改写的子节点放回 n 并记入 children
+func (p *rangePruner) pruneBranch(n *node) (bool, error) {
+	type target struct {
+		in      *common.Inode
+		covered bool
+	}
+	var targets []target
+	for i, in := range n.inodes {
+		// 子节点覆盖 [in.Key, 下一个元素的 Key)，第一个子节点不设下界
+		var lo, hi []byte
+		if i > 0 {
+			lo = in.Key()
+		}
+		if i+1 < len(n.inodes) {
+			hi = n.inodes[i+1].Key()
+		}
+		if hi != nil && bytes.Compare(hi, p.r.start) <= 0 {
+			continue
+		}
+		if lo != nil && p.r.limit != nil && bytes.Compare(lo, p.r.limit) >= 0 {
+			break
+		}
+		targets = append(targets, target{in: in, covered: !p.keepSub && p.r.covers(lo, hi)})
+	}
+	changed := false
+	for _, t := range targets {
+		child, err := n.findChild(t.in.Pgid(), t.in.Overflow())
+		if err != nil {
+			return changed, err
+		}
+		var (
+			parts  nodes
+			filter common.Pgid
+		)
+		if t.covered {
+			if err := p.free(child); err != nil {
+				return changed, err
+			}
+		} else {
+			var ok bool
+			if parts, filter, ok, err = p.prune(child); err != nil {
+				return changed, err
+			} else if !ok {
+				continue
+			}
+		}
+		changed = true
+		n.bTree.freeFilterPage(t.in.Filter())
+		n.del(t.in.Key())
+		for _, part := range parts {
+			part.parent = n
+			n.adopt(part, filter)
+		}
+	}
+	return changed, nil
+}
+
+// free 释放整个子树的页与叶子元素的 value log 记录，不改写任何页
+func (p *rangePruner) free(n *node) error {
+	if n.isLeaf {
+		for _, in := range n.inodes {
+			if fid, index, ok := valuePointer(in); ok {
+				p.ptrs = append(p.ptrs, [2]uint64{fid, index})
+			}
+		}
+		n.free()
+		return nil
+	}
+	for _, in := range n.inodes {
+		child, err := n.findChild(in.Pgid(), in.Overflow())
+		if err != nil {
+			return err
+		}
+		if err := p.free(child); err != nil {
+			return err
+		}
+		n.bTree.freeFilterPage(in.Filter())
+	}
+	n.free()
+	return nil
+}
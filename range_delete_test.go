@@ -0,0 +1,215 @@
+package go_tsmm
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/breeze-go-rust/go-tsmm/util"
+)
+
+func openRangeTree(t *testing.T, dir string, batchMemory int64) *BTree {
+	t.Helper()
+	b, err := NewBTree(false, false, true, dir, "", 3, 0, "", 0, 0, &Options{PageSize: 4096, BatchMemory: batchMemory})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func rangeValue(round, i int) []byte {
+	return []byte(fmt.Sprintf("value-%d-%d", round, i))
+}
+
+// checkRange 检查 key(i) 在 [0, n) 内的取值，want 返回 nil 表示 key 不存在
+func checkRange(t *testing.T, b *BTree, key func(int) []byte, n int, want func(int) []byte) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		got, err := b.Get(key(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w := want(i); string(got) != string(w) || (got == nil) != (w == nil) {
+			t.Fatalf("key %d = %q, want %q", i, got, w)
+		}
+	}
+}
+
+// TestDeleteRangeOrdersWithBatchWrites 范围删除覆盖记录之前写入批次的 key，之后写入的 key 不受影响，
+// 结果在重新打开后保持不变
+func TestDeleteRangeOrdersWithBatchWrites(t *testing.T) {
+	dir := t.TempDir()
+	b := openRangeTree(t, dir, -1)
+	for i := 0; i < 200; i++ {
+		if err := b.Put(txKey(i), rangeValue(0, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	// 50..59 在删除之前改写，60..69 在删除之后改写
+	for i := 50; i < 60; i++ {
+		if err := b.Put(txKey(i), rangeValue(1, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.DeleteRange(util.Range{Start: txKey(40), Limit: txKey(120)}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 60; i < 70; i++ {
+		if err := b.Put(txKey(i), rangeValue(1, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := func(i int) []byte {
+		switch {
+		case i >= 60 && i < 70:
+			return rangeValue(1, i)
+		case i >= 40 && i < 120:
+			return nil
+		}
+		return rangeValue(0, i)
+	}
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	checkRange(t, b, txKey, 200, want)
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b = openRangeTree(t, dir, -1)
+	defer b.Close()
+	checkRange(t, b, txKey, 200, want)
+}
+
+// TestDeleteRangeCoversSpilledRuns 批次超出内存预算写入 spill run 后，范围删除只覆盖记录之前的 run
+func TestDeleteRangeCoversSpilledRuns(t *testing.T) {
+	dir := t.TempDir()
+	b := openRangeTree(t, dir, 16<<10)
+	for i := 0; i < 2000; i++ {
+		if err := b.Put(txKey(i), rangeValue(0, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(b.runs) == 0 {
+		t.Fatal("batch was not spilled")
+	}
+	if err := b.DeleteRange(util.Range{Start: txKey(500), Limit: txKey(1500)}); err != nil {
+		t.Fatal(err)
+	}
+	before := len(b.runs)
+	if !b.covered(txKey(700)[len(util.AccountPrefix()):], 0) {
+		t.Fatal("key in a run written before the tombstone is not covered")
+	}
+	if b.covered(txKey(1700)[len(util.AccountPrefix()):], 0) {
+		t.Fatal("key outside the tombstone is covered")
+	}
+	for i := 1000; i < 2000; i++ {
+		if err := b.Put(txKey(i), rangeValue(1, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(b.runs) == before {
+		t.Fatal("writes after the tombstone were not spilled")
+	}
+	if b.covered(txKey(1200)[len(util.AccountPrefix()):], len(b.runs)-1) {
+		t.Fatal("key in a run written after the tombstone is covered")
+	}
+	want := func(i int) []byte {
+		switch {
+		case i >= 1000:
+			return rangeValue(1, i)
+		case i >= 500:
+			return nil
+		}
+		return rangeValue(0, i)
+	}
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	checkRange(t, b, txKey, 2000, want)
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b = openRangeTree(t, dir, 16<<10)
+	defer b.Close()
+	checkRange(t, b, txKey, 2000, want)
+}
+
+// TestDeletePrefixRemovesSubTree 按子树前缀删除整个子树：主树中的子树元素随之删除，
+// 子树的页回到 freelist，账户 key 不受影响
+func TestDeletePrefixRemovesSubTree(t *testing.T) {
+	dir := t.TempDir()
+	b := openRangeTree(t, dir, -1)
+	storageKey := func(i int) []byte { return benchStorageKey(7, uint64(i)) }
+	for i := 0; i < 3000; i++ {
+		if err := b.Put(storageKey(i), rangeValue(0, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		if err := b.Put(txKey(i), rangeValue(0, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	name := string(storageKey(0)[len(util.StoragePrefix()) : len(util.StoragePrefix())+util.SubTreeNameLen])
+	in, err := b.subTreeInode(name)
+	if err != nil || in == nil {
+		t.Fatalf("sub tree entry before delete = %v, %v", in, err)
+	}
+	freed := b.Stats().FreeCount + b.Stats().PendingCount
+
+	if err := b.DeletePrefix(append(util.StoragePrefix(), name...)); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if in, err := b.subTreeInode(name); err != nil || in != nil {
+		t.Fatalf("sub tree entry after delete = %v, %v", in, err)
+	}
+	stats := b.Stats()
+	// 子树至少占用数十个叶子页，全部释放
+	if got := stats.FreeCount + stats.PendingCount; got < freed+20 {
+		t.Fatalf("free+pending pages after deleting the sub tree = %d, was %d", got, freed)
+	}
+	checkRange(t, b, storageKey, 3000, func(int) []byte { return nil })
+	checkRange(t, b, txKey, 100, func(i int) []byte { return rangeValue(0, i) })
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b = openRangeTree(t, dir, -1)
+	defer b.Close()
+	checkRange(t, b, storageKey, 3000, func(int) []byte { return nil })
+	checkRange(t, b, txKey, 100, func(i int) []byte { return rangeValue(0, i) })
+	// meta 环中的旧版本移出后释放的页转为空闲，重新写入同样的子树复用这些页
+	for round := 1; round <= 3; round++ {
+		if err := b.Put(txKey(0), rangeValue(round, 0)); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := b.Stats().FreeCount; got < freed+20 {
+		t.Fatalf("free pages after the old versions left the meta ring = %d, was %d", got, freed)
+	}
+	hwm := b.ctx.meta.Pgid()
+	for i := 0; i < 3000; i++ {
+		if err := b.Put(storageKey(i), rangeValue(1, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.ctx.meta.Pgid(); got > hwm {
+		t.Fatalf("page file grew to %d pages rewriting a deleted sub tree, was %d", got, hwm)
+	}
+}
@@ -2,9 +2,12 @@ package go_tsmm
 
 import (
 	"bytes"
-	"github.com/breeze-go-rust/go-tsmm/internal/common"
 	"math/rand"
+	"sync"
 	"time"
+	"unsafe"
+
+	"github.com/breeze-go-rust/go-tsmm/internal/common"
 )
 
 const (
@@ -12,10 +15,14 @@ const (
 	probability = 0.25 // 节点出现在更高层的概率 (1/4)
 )
 
+// SkipList 以指针相连的跳表，可被多个 goroutine 并发读写。
+// 树的批次已改用 ArenaSkipList，保留它作为 arena_skiplist_test.go 中基准测试的对照
 type SkipList struct {
+	lock    sync.RWMutex  // 保护以下所有字段，randSrc 只在写锁下使用
 	head    *skipListNode // 头节点
 	level   int           // 当前最大层数
 	length  int           // 节点数量
+	bytes   int64         // key、value 与节点本身占用的内存估算
 	randSrc *rand.Rand    // 随机数生成器
 }
 
@@ -33,6 +40,14 @@ func NewSkipList() *SkipList {
 }
 
 func (s *SkipList) Put(key []byte, value []byte) error {
+	s.put(key, value)
+	return nil
+}
+
+// put 写入 key 并返回占用内存的变化量
+func (s *SkipList) put(key []byte, value []byte) int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	update := make([]*skipListNode, maxLevel) // 更新路径
 	current := s.head
 
@@ -48,8 +63,10 @@ func (s *SkipList) Put(key []byte, value []byte) error {
 	// 检查最底层是否已存在相同key
 	current = current.forward[0]
 	if current != nil && bytes.Equal(current.key, key) {
+		delta := int64(len(value) - len(current.value))
 		current.value = value // 更新现有值
-		return nil
+		s.bytes += delta
+		return delta
 	}
 
 	// 为新节点随机生成层数
@@ -72,10 +89,14 @@ func (s *SkipList) Put(key []byte, value []byte) error {
 	}
 
 	s.length++ // 增加节点计数
-	return nil
+	delta := nodeBytes(newNode)
+	s.bytes += delta
+	return delta
 }
 
 func (s *SkipList) Get(key []byte) ([]byte, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
 	current := s.head
 
 	// 从最高层开始搜索
@@ -95,6 +116,8 @@ func (s *SkipList) Get(key []byte) ([]byte, error) {
 }
 
 func (s *SkipList) Delete(key []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	update := make([]*skipListNode, maxLevel) // 更新路径
 	current := s.head
 
@@ -127,14 +150,26 @@ func (s *SkipList) Delete(key []byte) error {
 	}
 
 	s.length-- // 减少节点计数
+	s.bytes -= nodeBytes(current)
 	return nil
 }
 
 func (s *SkipList) Size() int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
 	return s.length
 }
 
+// Bytes 批次占用内存的估算，包含 key、value 与跳表节点
+func (s *SkipList) Bytes() int64 {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.bytes
+}
+
 func (s *SkipList) Dump() common.Inodes {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
 	kvs := make(common.Inodes, 0, s.length)
 	current := s.head.forward[0] // 从最底层第一个节点开始
 
@@ -167,6 +202,11 @@ type skipListNode struct {
 	forward []*skipListNode // 每层的后继指针数组
 }
 
+// nodeBytes 节点占用的内存：节点结构、各层指针、key 与 value
+func nodeBytes(n *skipListNode) int64 {
+	return int64(unsafe.Sizeof(*n)) + int64(len(n.forward))*int64(unsafe.Sizeof(n)) + int64(len(n.key)+len(n.value))
+}
+
 // 创建新节点
 func newNode(key, value []byte, level int) *skipListNode {
 	return &skipListNode{
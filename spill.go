@@ -0,0 +1,297 @@
+package go_tsmm
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/breeze-go-rust/go-tsmm/file"
+	"github.com/breeze-go-rust/go-tsmm/internal/common"
+	"github.com/breeze-go-rust/go-tsmm/util"
+)
+
+const (
+	BTreeSpillDir = "spill"
+
+	// DefaultBatchMemory Options.BatchMemory 为 0 时使用的批次内存预算
+	DefaultBatchMemory = 256 << 20
+
+	spillFilePrefix = "spill-"
+	spillBufferSize = 1 << 20
+)
+
+// spillFile 一次 spill 写出的临时文件，所有树的 run 依次存放，提交或关闭后删除
+type spillFile struct {
+	name string
+	h    file.Handle
+}
+
+// spillRun 一棵树在 spill 文件中的一段有序数据，格式与 WriteBatch.MarshalBinary 相同
+type spillRun struct {
+	file *spillFile
+	off  int64
+	size int64
+}
+
+// spillWriter 顺序写 file.Handle
+type spillWriter struct {
+	h   file.Handle
+	off int64
+}
+
+func (w *spillWriter) Write(p []byte) (int, error) {
+	n, err := w.h.WriteAt(p, w.off)
+	w.off += int64(n)
+	return n, err
+}
+
+// resolveBatchMemory 将 Options.BatchMemory 换算为预算，返回 0 表示不限制
+func resolveBatchMemory(want int64) int64 {
+	switch {
+	case want == 0:
+		return DefaultBatchMemory
+	case want < 0:
+		return 0
+	}
+	return want
+}
+
+// removeSpillFiles 删除上次运行残留的 spill 文件，这些文件只在提交前有用
+func (b *BTree) removeSpillFiles() error {
+	if err := b.fs.MkdirAll(b.spillDir); err != nil {
+		return fmt.Errorf("create spill dir %s: %w", b.spillDir, err)
+	}
+	names, err := b.fs.List(b.spillDir)
+	if err != nil {
+		return fmt.Errorf("list spill dir %s: %w", b.spillDir, err)
+	}
+	for _, name := range names {
+		if strings.HasPrefix(name, spillFilePrefix) {
+			if err := b.fs.Remove(filepath.Join(b.spillDir, name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// addBatchBytes 记录批次内存的变化，超出预算时把所有批次写入临时文件
+func (b *BTree) addBatchBytes(delta int64) error {
+	if b.batchBudget <= 0 || b.batchBytes.Add(delta) <= b.batchBudget {
+		return nil
+	}
+	b.spillLock.Lock()
+	defer b.spillLock.Unlock()
+	// 等锁期间可能已由其他 goroutine 完成 spill
+	if b.batchBytes.Load() <= b.batchBudget {
+		return nil
+	}
+	return b.spillBatches()
+}
+
+// newSpillFile 在 spill 目录下创建一个新的临时文件，调用方负责登记到 spillFiles 或删除
+func (b *BTree) newSpillFile() (*spillFile, error) {
+	name := filepath.Join(b.spillDir, fmt.Sprintf("%s%06d", spillFilePrefix, b.spillSeq))
+	b.spillSeq++
+	h, err := b.fs.Open(name, &file.Options{})
+	if err != nil {
+		return nil, fmt.Errorf("create spill file %s: %w", name, err)
+	}
+	return &spillFile{name: name, h: h}, nil
+}
+
+// spillBatches 将主树与各子树的批次依次编码写入一个新的临时文件并清空批次，
+// 调用方持有 spillLock 的写锁。写入失败时批次保持不变
+func (b *BTree) spillBatches() error {
+	sf, err := b.newSpillFile()
+	if err != nil {
+		return err
+	}
+	name, h := sf.name, sf.h
+	trees := append([]*BTree{b}, b.dirtySubTrees()...)
+	runs := make([]spillRun, len(trees))
+	w := &spillWriter{h: h}
+	for i, tree := range trees {
+		if tree.batch.Size() == 0 {
+			continue
+		}
+		off := w.off
+		bw := bufio.NewWriterSize(w, spillBufferSize)
+		if err := tree.batch.encode(bw, tree.keyPrefix()); err == nil {
+			err = bw.Flush()
+		}
+		if err != nil {
+			_ = h.Close()
+			_ = b.fs.Remove(name)
+			return fmt.Errorf("write spill file %s: %w", name, err)
+		}
+		runs[i] = spillRun{file: sf, off: off, size: w.off - off}
+	}
+	b.spillFiles = append(b.spillFiles, sf)
+	for i, tree := range trees {
+		if runs[i].file != nil {
+			tree.runs = append(tree.runs, runs[i])
+			tree.batch = NewArenaSkipList()
+		}
+	}
+	b.batchBytes.Store(0)
+	return nil
+}
+
+// keyPrefix 本树的 key 在外部 key 中的前缀
+func (b *BTree) keyPrefix() []byte {
+	if !b.isSubBTree {
+		return util.AccountPrefix()
+	}
+	return append(util.StoragePrefix(), b.header.Name()...)
+}
+
+// releaseSpill 提交成功或关闭时删除所有 spill 文件
+func (b *BTree) releaseSpill() error {
+	var first error
+	for _, sf := range b.spillFiles {
+		if err := sf.h.Close(); err != nil && first == nil {
+			first = err
+		}
+		if err := b.fs.Remove(sf.name); err != nil && first == nil {
+			first = err
+		}
+	}
+	b.spillFiles = nil
+	b.runs = nil
+	b.treeLock.RLock()
+	for _, tree := range b.bTrees {
+		tree.runs = nil
+	}
+	b.treeLock.RUnlock()
+	b.batchBytes.Store(0)
+	return first
+}
+
+// spillSource 归并的一路输入：一个 spill run 或内存中的批次
+type spillSource struct {
+	dec    *BatchDecoder
+	inodes common.Inodes
+	in     *common.Inode // 来自 inodes 时为当前元素，保留其 flags 与哈希
+	run    int           // 在 runs 中的下标，内存中的批次为 len(runs)
+	prefix int
+	key    []byte
+	value  []byte
+	ok     bool
+}
+
+func (s *spillSource) next() error {
+	if s.dec == nil {
+		s.ok = len(s.inodes) > 0
+		if s.ok {
+			s.in = s.inodes[0]
+			s.key, s.value = s.in.Key(), s.in.Value()
+			s.inodes = s.inodes[1:]
+		}
+		return nil
+	}
+	key, value, err := s.dec.Next()
+	if err == io.EOF {
+		s.ok = false
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.key, s.value, s.ok = key[s.prefix:], value, true
+	return nil
+}
+
+// updateRuns 将 spill run 与内存中的有序元素 kvs 归并后分段写入本树，同一个 key 以最新的为准，
+// 范围删除之前写出的 run 中落在范围内的 key 被丢弃。
+// 每段不超过批次内存预算，段之间把已写好的页落到页文件，只在内存中保留一段
+func (b *BTree) updateRuns(kvs common.Inodes) error {
+	tree := b.parent()
+	prefix := len(b.keyPrefix())
+	sources := make([]*spillSource, 0, len(b.runs)+1)
+	for i, run := range b.runs {
+		r := bufio.NewReaderSize(io.NewSectionReader(run.file.h, run.off, run.size), spillBufferSize)
+		sources = append(sources, &spillSource{dec: NewBatchDecoder(r), run: i, prefix: prefix})
+	}
+	sources = append(sources, &spillSource{inodes: kvs, run: len(b.runs)})
+	for _, s := range sources {
+		if err := s.next(); err != nil {
+			return err
+		}
+	}
+	var (
+		chunk common.Inodes
+		size  int64
+	)
+	for {
+		// 取最小的 key，相同的 key 以后面（更新）的输入为准
+		var min *spillSource
+		for _, s := range sources {
+			if s.ok && (min == nil || bytes.Compare(s.key, min.key) <= 0) {
+				min = s
+			}
+		}
+		if min == nil {
+			break
+		}
+		// run 中被之后记录的范围删除覆盖的写入直接丢弃
+		if min.dec == nil || !b.covered(min.key, min.run) {
+			in := min.in
+			if min.dec != nil {
+				in = &common.Inode{}
+				in.SetKey(min.key)
+				in.SetValue(min.value)
+			}
+			chunk = append(chunk, in)
+			size += int64(len(min.key) + len(min.value))
+		}
+		key := min.key
+		for _, s := range sources {
+			for s.ok && bytes.Equal(s.key, key) {
+				if err := s.next(); err != nil {
+					return err
+				}
+			}
+		}
+		if size >= tree.batchBudget {
+			if err := b.updateChunk(chunk); err != nil {
+				return err
+			}
+			chunk, size = nil, 0
+		}
+	}
+	if len(chunk) == 0 {
+		return nil
+	}
+	return b.updateChunk(chunk)
+}
+
+func (b *BTree) updateChunk(kvs common.Inodes) error {
+	if err := b.update(kvs); err != nil {
+		return err
+	}
+	tree := b.parent()
+	// 缓存中被删除的节点在句柄释放前仍可被查到，后面的段可能改写并重新读取
+	// 本段释放的页，段之间必须释放句柄
+	tree.releaseHandles()
+	return tree.writeDirtyPages()
+}
+
+// writeDirtyPages 在提交前把已写好的页写入页文件并移出 dirtyPages。
+// 这些页只被尚未提交的 meta 引用，提交失败时是无用数据，不影响已提交的版本
+func (b *BTree) writeDirtyPages() error {
+	b.allocLock.Lock()
+	pages := b.dirtyPages
+	b.dirtyPages = make(map[common.Pgid]*common.Page)
+	b.allocLock.Unlock()
+	for _, p := range pages {
+		if err := b.pageMgr.Write(p); err != nil {
+			return err
+		}
+		b.writtenPages += int(p.Overflow()) + 1
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package go_tsmm
+
+import (
+	"expvar"
+	"sync/atomic"
+	"time"
+
+	"github.com/breeze-go-rust/go-tsmm/cache"
+	"github.com/breeze-go-rust/go-tsmm/internal/common"
+	"github.com/breeze-go-rust/go-tsmm/vexodb"
+)
+
+// CommitStats 一次提交各阶段的耗时与写入的页数；累计值为各次提交之和
+type CommitStats struct {
+	Update time.Duration // 应用批次、计算哈希
+	Write  time.Duration // 写入数据页
+	Sync   time.Duration // 页文件与 value log 刷盘
+	Meta   time.Duration // 写入并刷盘 meta
+	Total  time.Duration
+	Pages  int // 写入的页数，包含 overflow 页
+}
+
+func (s *CommitStats) add(o CommitStats) {
+	s.Update += o.Update
+	s.Write += o.Write
+	s.Sync += o.Sync
+	s.Meta += o.Meta
+	s.Total += o.Total
+	s.Pages += o.Pages
+}
+
+// Stats BTree 的运行统计快照
+type Stats struct {
+	Txid        common.TxID
+	Commits     int
+	LastCommit  CommitStats
+	TotalCommit CommitStats
+
+	Cache         cache.Stats // 未启用页缓存时为零值
+	CacheCapacity int
+
+	FreeCount    int // 可复用的空闲页
+	PendingCount int // 已释放但仍被保留版本引用的页
+
+	PageSize     uint32
+	PageFileSize int64
+
+	ValueLog vexodb.Stats
+
+	FilterNegatives      uint64 // Get 因过滤器判定不存在而跳过的叶子页读取
+	FilterFalsePositives uint64 // 过滤器判定可能存在、但叶子页中没有的查找
+}
+
+// statsState 由提交更新、由 Stats 读取，freelist 本身不是并发安全的，
+// 因此在提交结束时记录计数而不是在 Stats 中直接读取
+type statsState struct {
+	txid         common.TxID
+	commits      int
+	last         CommitStats
+	total        CommitStats
+	freeCount    int
+	pendingCount int
+}
+
+// updateStats 打开或成功提交后记录当前版本，cs 为 nil 表示打开
+func (b *BTree) updateStats(cs *CommitStats) {
+	b.statsLock.Lock()
+	defer b.statsLock.Unlock()
+	if cs != nil {
+		b.stats.commits++
+		b.stats.last = *cs
+		b.stats.total.add(*cs)
+	}
+	b.stats.txid = b.ctx.meta.Txid()
+	b.allocLock.Lock()
+	b.stats.freeCount = b.freelist.FreeCount()
+	b.stats.pendingCount = b.freelist.PendingCount()
+	b.allocLock.Unlock()
+}
+
+// Stats 返回整棵树（主树与所有子树共享）的统计快照，可与提交并发调用
+func (b *BTree) Stats() Stats {
+	tree := b.parent()
+	tree.statsLock.Lock()
+	s := Stats{
+		Txid:         tree.stats.txid,
+		Commits:      tree.stats.commits,
+		LastCommit:   tree.stats.last,
+		TotalCommit:  tree.stats.total,
+		FreeCount:    tree.stats.freeCount,
+		PendingCount: tree.stats.pendingCount,
+		PageSize:     tree.pSize,
+	}
+	tree.statsLock.Unlock()
+	if tree.nodeCache != nil {
+		s.Cache = tree.nodeCache.GetStats()
+		s.CacheCapacity = tree.nodeCache.Capacity()
+	}
+	if size, err := tree.pageMgr.Size(); err == nil {
+		s.PageFileSize = size
+	}
+	s.ValueLog = tree.vlog.Stats()
+	s.FilterNegatives = atomic.LoadUint64(&tree.filterNegatives)
+	s.FilterFalsePositives = atomic.LoadUint64(&tree.filterFalsePositives)
+	return s
+}
+
+// PublishExpvar 将 Stats() 以 name 发布到 expvar（/debug/vars）。
+// 与 expvar.Publish 一样，name 重复时 panic
+func (b *BTree) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() any {
+		return b.Stats()
+	}))
+}
@@ -0,0 +1,204 @@
+package go_tsmm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/breeze-go-rust/go-tsmm/util"
+)
+
+// openStatsTree 写入两轮后返回，第二轮改写第一轮的一部分 key
+func openStatsTree(t *testing.T) *BTree {
+	t.Helper()
+	b := openFreelistTree(t, t.TempDir())
+	putRound(t, b, 0, 2000)
+	putRound(t, b, 1, 500)
+	return b
+}
+
+// TestStats 提交计数、各阶段耗时之和与页文件大小和提交的结果一致
+func TestStats(t *testing.T) {
+	b := openFreelistTree(t, t.TempDir())
+	defer b.Close()
+	s := b.Stats()
+	if s.Commits != 0 || s.Txid != b.Txid() || s.PageSize != 4096 {
+		t.Fatalf("stats of a new store = %+v", s)
+	}
+	if s.CacheCapacity != DefaultCacheSize {
+		t.Fatalf("cache capacity = %d, want %d", s.CacheCapacity, DefaultCacheSize)
+	}
+
+	putRound(t, b, 0, 2000)
+	first := b.Stats()
+	putRound(t, b, 1, 500)
+	s = b.Stats()
+	if s.Commits != 2 || s.Txid != b.Txid() {
+		t.Fatalf("commits %d txid %d, want 2 and %d", s.Commits, s.Txid, b.Txid())
+	}
+	if s.LastCommit.Pages == 0 || s.TotalCommit.Pages != first.LastCommit.Pages+s.LastCommit.Pages {
+		t.Fatalf("total pages %d, want %d + %d", s.TotalCommit.Pages, first.LastCommit.Pages, s.LastCommit.Pages)
+	}
+	if s.TotalCommit.Total != first.LastCommit.Total+s.LastCommit.Total {
+		t.Fatalf("total commit time %v, want %v + %v", s.TotalCommit.Total, first.LastCommit.Total, s.LastCommit.Total)
+	}
+	if phases := s.LastCommit.Update + s.LastCommit.Write + s.LastCommit.Sync + s.LastCommit.Meta; phases > s.LastCommit.Total {
+		t.Fatalf("commit phases take %v, longer than the commit %v", phases, s.LastCommit.Total)
+	}
+	// 第二轮改写了第一轮写出的叶子页，旧页进入 pending
+	if s.PendingCount == 0 {
+		t.Fatal("no pending pages after rewriting committed keys")
+	}
+	if s.PageFileSize < int64(s.TotalCommit.Pages)*int64(s.PageSize) {
+		t.Fatalf("page file is %d bytes, less than the %d pages written", s.PageFileSize, s.TotalCommit.Pages)
+	}
+
+	// 提交写出的页不进入缓存，读取时才缓存，第二遍读取命中
+	key := func(i int) []byte { return append(util.AccountPrefix(), []byte(fmt.Sprintf("key-%06d", i))...) }
+	for pass := 0; pass < 2; pass++ {
+		for i := 0; i < 2000; i += 100 {
+			if _, err := b.Get(key(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	c := b.Stats().Cache
+	if c.Nodes == 0 || c.Size == 0 || c.HitCount <= s.Cache.HitCount {
+		t.Fatalf("page cache after reading twice: %+v", c)
+	}
+}
+
+// parseMetrics 按 Prometheus 文本格式解析输出：每个指标先有 HELP 与 TYPE，
+// 之后的样本名与其一致，返回样本名（含标签）到取值的映射
+func parseMetrics(t *testing.T, out []byte, namespace string) map[string]float64 {
+	t.Helper()
+	samples := make(map[string]float64)
+	var family string
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		line := s.Text()
+		switch {
+		case strings.HasPrefix(line, "# HELP "):
+			fields := strings.SplitN(line, " ", 4)
+			if len(fields) != 4 || fields[3] == "" {
+				t.Fatalf("HELP line without text: %q", line)
+			}
+			family = fields[2]
+		case strings.HasPrefix(line, "# TYPE "):
+			fields := strings.Fields(line)
+			if len(fields) != 4 || fields[2] != family {
+				t.Fatalf("TYPE line %q does not follow HELP of %s", line, family)
+			}
+			if fields[3] != "gauge" && fields[3] != "counter" {
+				t.Fatalf("unknown metric type in %q", line)
+			}
+		default:
+			i := strings.LastIndexByte(line, ' ')
+			if i < 0 {
+				t.Fatalf("malformed sample %q", line)
+			}
+			name := line[:i]
+			if base, _, _ := strings.Cut(name, "{"); base != family {
+				t.Fatalf("sample %q outside its family %s", line, family)
+			}
+			if !strings.HasPrefix(name, namespace+"_") {
+				t.Fatalf("sample %q without the %s_ prefix", line, namespace)
+			}
+			v, err := strconv.ParseFloat(line[i+1:], 64)
+			if err != nil {
+				t.Fatalf("sample %q: %v", line, err)
+			}
+			if _, ok := samples[name]; ok {
+				t.Fatalf("duplicate sample %s", name)
+			}
+			samples[name] = v
+		}
+	}
+	return samples
+}
+
+func TestMetricsCollector(t *testing.T) {
+	b := openStatsTree(t)
+	defer b.Close()
+	s := b.Stats()
+
+	var buf bytes.Buffer
+	n, err := NewMetricsCollector(b, "").WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) {
+		t.Fatalf("WriteTo returned %d, wrote %d bytes", n, buf.Len())
+	}
+	samples := parseMetrics(t, buf.Bytes(), "tsmm")
+	for name, want := range map[string]float64{
+		"tsmm_txid":                                float64(s.Txid),
+		"tsmm_commits_total":                       2,
+		"tsmm_commit_pages_total":                  float64(s.TotalCommit.Pages),
+		"tsmm_last_commit_pages":                   float64(s.LastCommit.Pages),
+		`tsmm_commit_seconds_total{phase="total"}`: s.TotalCommit.Total.Seconds(),
+		"tsmm_freelist_pending_pages":              float64(s.PendingCount),
+		"tsmm_page_size_bytes":                     4096,
+		"tsmm_cache_capacity_bytes":                DefaultCacheSize,
+	} {
+		got, ok := samples[name]
+		if !ok {
+			t.Fatalf("missing sample %s", name)
+		}
+		if got != want {
+			t.Fatalf("%s = %v, want %v", name, got, want)
+		}
+	}
+	for _, phase := range []string{"update", "write", "sync", "meta", "total"} {
+		if _, ok := samples[`tsmm_last_commit_seconds{phase="`+phase+`"}`]; !ok {
+			t.Fatalf("missing last commit phase %s", phase)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	NewMetricsCollector(b, "store").ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatalf("content type = %q", ct)
+	}
+	if got := parseMetrics(t, rec.Body.Bytes(), "store"); len(got) != len(samples) {
+		t.Fatalf("handler exported %d samples, WriteTo %d", len(got), len(samples))
+	}
+}
+
+func TestPublishExpvar(t *testing.T) {
+	b := openStatsTree(t)
+	defer b.Close()
+	b.PublishExpvar("tsmm_test_stats")
+	v := expvar.Get("tsmm_test_stats")
+	if v == nil {
+		t.Fatal("stats were not published")
+	}
+	var s Stats
+	if err := json.Unmarshal([]byte(v.String()), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Commits != 2 || s.Txid != b.Txid() || s.PageSize != 4096 {
+		t.Fatalf("published stats = %+v", s)
+	}
+	// 发布的是函数，之后的提交反映在下一次读取中
+	putRound(t, b, 2, 100)
+	if err := json.Unmarshal([]byte(v.String()), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Commits != 3 {
+		t.Fatalf("published commits after another commit = %d, want 3", s.Commits)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("publishing a duplicate name did not panic")
+		}
+	}()
+	b.PublishExpvar("tsmm_test_stats")
+}
@@ -0,0 +1,236 @@
+package go_tsmm
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/breeze-go-rust/go-tsmm/errors"
+	"github.com/breeze-go-rust/go-tsmm/internal/common"
+	"github.com/breeze-go-rust/go-tsmm/util"
+)
+
+// Tx 绑定到一个已提交版本的事务，只能在一个 goroutine 中使用。
+// 写事务同一时刻只有一个，写入先暂存在事务自己的批次中，事务的 Get 与游标都能读到；
+// Commit 时并入树的批次一起提交，Rollback 只丢弃事务自己的写入。
+// 其他 goroutine 经 BTree.Put 等写入的批次不属于任何事务，事务也读不到。
+// 只读事务可以有多个，读取开始时最后一个已提交的版本，之后的提交对其不可见
+type Tx struct {
+	db       *BTree
+	meta     *common.Meta
+	view     *BTree      // 只读事务读取主树使用的快照视图
+	batch    *WriteBatch // 写事务尚未提交的写入，key 为外部 key
+	writable bool
+	closed   bool
+}
+
+// Begin 开始一个事务。Begin(true) 等待前一个写事务、Commit 或 BulkLoad 结束，
+// 只读模式打开时返回 ErrDatabaseReadOnly。只读事务在 freelist 中登记其版本，
+// 事务结束前该版本引用的页即使被之后的提交释放也不会复用。
+// 事务用完后必须调用 Commit 或 Rollback
+func (b *BTree) Begin(writable bool) (*Tx, error) {
+	tree := b.parent()
+	if writable {
+		if tree.isReadOnly {
+			return nil, errors.ErrDatabaseReadOnly
+		}
+		tree.writerLock.Lock()
+		return &Tx{db: tree, meta: tree.ctx.meta, batch: NewBatch(), writable: true}, nil
+	}
+	tree.metaLock.RLock()
+	meta := tree.ctx.meta
+	tree.allocLock.Lock()
+	tree.freelist.AddReadonlyTXID(meta.Txid())
+	tree.allocLock.Unlock()
+	tree.metaLock.RUnlock()
+	root := *meta.RootBucket()
+	return &Tx{
+		db:   tree,
+		meta: meta,
+		view: &BTree{header: &root, parentBTree: tree, txView: true},
+	}, nil
+}
+
+// Txid 返回事务读取的版本的事务号
+func (tx *Tx) Txid() common.TxID {
+	return tx.meta.Txid()
+}
+
+// Writable 是否为写事务
+func (tx *Tx) Writable() bool {
+	return tx.writable
+}
+
+// Get 读取事务版本中 key 的 value，不存在时返回 nil；写事务先读事务自己的写入
+func (tx *Tx) Get(key []byte) ([]byte, error) {
+	if tx.closed {
+		return nil, errors.ErrTxClosed
+	}
+	if tx.writable {
+		if v, err := tx.batch.Get(key); err == nil {
+			return v, nil
+		}
+		return tx.db.Get(key)
+	}
+	name, realKey, err := splitKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if name == nil {
+		return tx.view.get(realKey)
+	}
+	sub, err := tx.subTree(string(name))
+	if err != nil || sub == nil {
+		return nil, err
+	}
+	return sub.get(realKey)
+}
+
+// Put 将 key 写入事务的批次，value 为 nil 表示删除，Commit 后生效
+func (tx *Tx) Put(key, value []byte) error {
+	if tx.closed {
+		return errors.ErrTxClosed
+	}
+	if !tx.writable {
+		return errors.ErrTxNotWritable
+	}
+	return tx.batch.Put(key, value)
+}
+
+func (tx *Tx) Delete(key []byte) error {
+	return tx.Put(key, nil)
+}
+
+// Cursor 返回遍历主树中账户的游标，key 不带账户前缀
+func (tx *Tx) Cursor() *Cursor {
+	tree := tx.view
+	if tx.writable {
+		tree = tx.db
+	}
+	return newCursor(tx, tree, util.AccountPrefix(), true)
+}
+
+// SubTree 返回名为 name 的子树，事务版本中没有该子树时为一棵空子树，写事务可以向其写入
+func (tx *Tx) SubTree(name []byte) (*SubTree, error) {
+	if tx.closed {
+		return nil, errors.ErrTxClosed
+	}
+	if len(name) != util.SubTreeNameLen {
+		return nil, fmt.Errorf("invalid sub tree name length %d", len(name))
+	}
+	tree, err := tx.subTree(string(name))
+	if err != nil {
+		return nil, err
+	}
+	return &SubTree{tx: tx, prefix: append(util.StoragePrefix(), name...), tree: tree}, nil
+}
+
+// subTree 返回读取事务版本中子树使用的树，子树不存在时返回 nil
+func (tx *Tx) subTree(name string) (*BTree, error) {
+	if tx.writable {
+		return tx.db.committedSubTree(name)
+	}
+	in, err := tx.view.subTreeInode(name)
+	if err != nil || in == nil {
+		return nil, err
+	}
+	return &BTree{header: decodeSubTree(name, in.Value()), isSubBTree: true, parentBTree: tx.db, txView: true}, nil
+}
+
+// Commit 将事务的写入并入树的批次后提交并结束事务。与 BTree.Commit 一样，
+// 提交失败时批次（包括事务的写入）保留在树中，随下一次 Commit 重试。只读事务返回 ErrTxNotWritable
+func (tx *Tx) Commit() error {
+	if tx.closed {
+		return errors.ErrTxClosed
+	}
+	if !tx.writable {
+		return errors.ErrTxNotWritable
+	}
+	err := tx.db.Apply(tx.batch)
+	if err == nil {
+		err = tx.db.commitBatch()
+	}
+	tx.close()
+	return err
+}
+
+// Rollback 结束事务。写事务丢弃事务自己的写入，树的批次不受影响；只读事务撤销其版本的登记，
+// 不再被任何版本引用的 pending 页转为空闲
+func (tx *Tx) Rollback() error {
+	if tx.closed {
+		return errors.ErrTxClosed
+	}
+	tx.close()
+	return nil
+}
+
+func (tx *Tx) close() {
+	tree := tx.db
+	if tx.writable {
+		tree.writerLock.Unlock()
+	} else {
+		tree.allocLock.Lock()
+		tree.freelist.RemoveReadonlyTXID(tx.meta.Txid())
+		tree.freelist.ReleasePendingPages()
+		tree.allocLock.Unlock()
+	}
+	tx.batch = nil
+	tx.closed = true
+}
+
+// overlay 返回写事务中 key 以 prefix 开头、尚未提交的写入，key 去掉前缀，value 为 nil 表示删除。
+// 只读事务或没有这样的写入时返回 nil
+func (tx *Tx) overlay(prefix []byte) common.Inodes {
+	if tx.closed || !tx.writable || tx.batch.Size() == 0 {
+		return nil
+	}
+	kvs := tx.batch.Dump()
+	i := sort.Search(len(kvs), func(i int) bool { return bytes.Compare(kvs[i].Key(), prefix) >= 0 })
+	var res common.Inodes
+	for ; i < len(kvs) && bytes.HasPrefix(kvs[i].Key(), prefix); i++ {
+		in := &common.Inode{}
+		in.SetKey(kvs[i].Key()[len(prefix):])
+		in.SetValue(kvs[i].Value())
+		res = append(res, in)
+	}
+	return res
+}
+
+// SubTree 事务中的一棵子树，key 为子树内的 slot，不带子树前缀
+type SubTree struct {
+	tx     *Tx
+	prefix []byte // 外部 key 的前缀：storage 前缀与子树名
+	tree   *BTree // 读取使用的树，事务版本中没有该子树时为 nil
+}
+
+func (s *SubTree) key(slot []byte) []byte {
+	return append(append(make([]byte, 0, len(s.prefix)+len(slot)), s.prefix...), slot...)
+}
+
+// Get 读取事务版本中 slot 的 value，不存在时返回 nil；写事务先读事务自己的写入
+func (s *SubTree) Get(slot []byte) ([]byte, error) {
+	if s.tx.closed {
+		return nil, errors.ErrTxClosed
+	}
+	if s.tx.writable {
+		return s.tx.Get(s.key(slot))
+	}
+	if s.tree == nil {
+		return nil, nil
+	}
+	return s.tree.get(slot)
+}
+
+// Put 将 slot 写入批次，value 为 nil 表示删除，Commit 后生效
+func (s *SubTree) Put(slot, value []byte) error {
+	return s.tx.Put(s.key(slot), value)
+}
+
+func (s *SubTree) Delete(slot []byte) error {
+	return s.tx.Put(s.key(slot), nil)
+}
+
+// Cursor 返回遍历子树的游标，key 为 slot
+func (s *SubTree) Cursor() *Cursor {
+	return newCursor(s.tx, s.tree, s.prefix, false)
+}
@@ -0,0 +1,322 @@
+package go_tsmm
+
+import (
+	"bytes"
+	stderrors "errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/breeze-go-rust/go-tsmm/errors"
+	"github.com/breeze-go-rust/go-tsmm/util"
+)
+
+func openTxTree(t *testing.T) *BTree {
+	t.Helper()
+	b, err := NewBTree(false, false, true, t.TempDir(), "", 3, 0, "", 0, 0, &Options{PageSize: 4096})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func txKey(i int) []byte {
+	return append(util.AccountPrefix(), fmt.Sprintf("key-%05d", i)...)
+}
+
+// checkSteps 随机交替 Next 与 Prev，游标始终与有序的 keys 一致
+func checkSteps(t *testing.T, rnd *rand.Rand, c *Cursor, keys []string) {
+	t.Helper()
+	if !c.First() {
+		t.Fatal("First on a non-empty tree returned false")
+	}
+	pos := 0
+	for n := 0; n < 2000; n++ {
+		var ok bool
+		if rnd.Intn(2) == 0 {
+			ok, pos = c.Next(), pos+1
+		} else {
+			ok, pos = c.Prev(), pos-1
+		}
+		if ok != (pos >= 0 && pos < len(keys)) {
+			t.Fatalf("step %d: valid %v at position %d of %d", n, ok, pos, len(keys))
+		}
+		if !ok {
+			// 越界后游标失效，重新定位到一端
+			if pos < 0 {
+				pos = 0
+				c.First()
+			} else {
+				pos = len(keys) - 1
+				c.Last()
+			}
+		}
+		if string(c.Key()) != keys[pos] {
+			t.Fatalf("step %d: key %x, want %x at position %d", n, c.Key(), keys[pos], pos)
+		}
+	}
+}
+
+// TestTxReadYourWrites 写事务的 Get、子树与游标都能读到事务自己的写入，提交前树的读取看不到
+func TestTxReadYourWrites(t *testing.T) {
+	b := openTxTree(t)
+	defer b.Close()
+	name := bytes.Repeat([]byte{7}, util.SubTreeNameLen)
+	model := propertyModel{"": {}, string(name): {}}
+	for i := 0; i < 1000; i += 2 {
+		if err := b.Put(txKey(i), []byte("base")); err != nil {
+			t.Fatal(err)
+		}
+		model[""][string(txKey(i)[len(util.AccountPrefix()):])] = []byte("base")
+	}
+	if err := b.Put(externalKey(string(name), "slot-0"), []byte("base")); err != nil {
+		t.Fatal(err)
+	}
+	model[string(name)]["slot-0"] = []byte("base")
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := b.Begin(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rnd := rand.New(rand.NewSource(1))
+	var deleted [][2]string
+	for n := 0; n < 600; n++ {
+		i := rnd.Intn(1100)
+		key := string(txKey(i)[len(util.AccountPrefix()):])
+		if rnd.Intn(3) == 0 {
+			if err := tx.Delete(txKey(i)); err != nil {
+				t.Fatal(err)
+			}
+			delete(model[""], key)
+			deleted = append(deleted, [2]string{"", key})
+			continue
+		}
+		value := []byte(fmt.Sprintf("tx-%d", n))
+		if err := tx.Put(txKey(i), value); err != nil {
+			t.Fatal(err)
+		}
+		model[""][key] = value
+	}
+	sub, err := tx.SubTree(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sub.Put([]byte("slot-1"), []byte("tx")); err != nil {
+		t.Fatal(err)
+	}
+	if err := sub.Delete([]byte("slot-0")); err != nil {
+		t.Fatal(err)
+	}
+	model[string(name)]["slot-1"] = []byte("tx")
+	delete(model[string(name)], "slot-0")
+
+	for key, value := range model[""] {
+		if got, err := tx.Get(externalKey("", key)); err != nil || !bytes.Equal(got, value) {
+			t.Fatalf("tx.Get(%s) = %q, %v; want %q", key, got, err, value)
+		}
+	}
+	for _, d := range deleted {
+		if _, ok := model[d[0]][d[1]]; ok {
+			continue
+		}
+		if got, err := tx.Get(externalKey(d[0], d[1])); err != nil || got != nil {
+			t.Fatalf("deleted %s = %q, %v", d[1], got, err)
+		}
+	}
+	if got, _ := sub.Get([]byte("slot-1")); string(got) != "tx" {
+		t.Fatalf("sub.Get(slot-1) = %q", got)
+	}
+	if got, _ := sub.Get([]byte("slot-0")); got != nil {
+		t.Fatalf("deleted sub slot = %q", got)
+	}
+	checkCursor(t, rnd, tx.Cursor(), model.sortedKeys(""), model[""])
+	checkCursor(t, rnd, sub.Cursor(), model.sortedKeys(string(name)), model[string(name)])
+	checkSteps(t, rnd, tx.Cursor(), model.sortedKeys(""))
+	if got, err := tx.Cursor().Get([]byte("key-01099")); err != nil || !bytes.Equal(got, model[""]["key-01099"]) {
+		t.Fatalf("cursor Get = %q, %v", got, err)
+	}
+
+	// 提交前树的读取只看到已提交的版本
+	if got, _ := b.Get(externalKey(string(name), "slot-0")); string(got) != "base" {
+		t.Fatalf("uncommitted tx write visible outside the tx: %q", got)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	checkModel(t, rnd, b, model, deleted)
+}
+
+// TestTxRollbackKeepsOtherWrites 写事务回滚只丢弃事务自己的写入，其他 goroutine 经 BTree.Put 的写入随下一次提交生效
+func TestTxRollbackKeepsOtherWrites(t *testing.T) {
+	b := openTxTree(t)
+	defer b.Close()
+	tx, err := b.Begin(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Put(txKey(1), []byte("tx")); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- b.Put(txKey(2), []byte("other")) }()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := tx.Get(txKey(2)); got != nil {
+		t.Fatalf("tx sees an uncommitted write from outside: %q", got)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := b.Get(txKey(1)); got != nil {
+		t.Fatalf("rolled back write committed: %q", got)
+	}
+	if got, _ := b.Get(txKey(2)); string(got) != "other" {
+		t.Fatalf("write outside the tx = %q, want it kept across rollback", got)
+	}
+}
+
+// TestTxConcurrentReaders 写事务写入并提交期间，只读事务始终读到开始时的版本
+func TestTxConcurrentReaders(t *testing.T) {
+	b := openTxTree(t)
+	defer b.Close()
+	const keys = 300
+	value := func(round, i int) []byte { return []byte(fmt.Sprintf("v-%d-%d", round, i)) }
+	for i := 0; i < keys; i++ {
+		if err := b.Put(txKey(i), value(1, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	// 第 txid 次提交后所有 key 的 value 都是 value(txid, i)
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	stop := make(chan struct{})
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				tx, err := b.Begin(false)
+				if err != nil {
+					errs <- err
+					return
+				}
+				round := int(tx.Txid())
+				c := tx.Cursor()
+				n := 0
+				for ok := c.First(); ok; ok = c.Next() {
+					i := n
+					if want := value(round, i); !bytes.Equal(c.Value(), want) {
+						errs <- fmt.Errorf("txid %d key %d = %q, want %q", round, i, c.Value(), want)
+						_ = tx.Rollback()
+						return
+					}
+					n++
+				}
+				_ = tx.Rollback()
+				if n != keys {
+					errs <- fmt.Errorf("txid %d: cursor saw %d keys, want %d", round, n, keys)
+					return
+				}
+			}
+		}()
+	}
+	for round := 2; round <= 6; round++ {
+		tx, err := b.Begin(true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < keys; i++ {
+			if err := tx.Put(txKey(i), value(round, i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
+
+// TestOpenReadOnly 只读打开可以并存并读取已提交的数据，Begin(true) 返回 ErrDatabaseReadOnly；
+// 只读打开期间读写打开在 Timeout 后返回 ErrTimeout
+func TestOpenReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	open := func(readOnly bool) (*BTree, error) {
+		return NewBTree(readOnly, false, true, dir, "", 3, 0, "", 0, 0,
+			&Options{PageSize: 4096, Timeout: 20 * time.Millisecond})
+	}
+	b, err := open(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := b.Put(txKey(i), []byte(fmt.Sprintf("value-%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := open(true); !stderrors.Is(err, errors.ErrTimeout) {
+		t.Fatalf("read-only open while open for writing returned %v, want ErrTimeout", err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var readers []*BTree
+	for i := 0; i < 2; i++ {
+		r, err := open(true)
+		if err != nil {
+			t.Fatalf("read-only open %d: %v", i, err)
+		}
+		defer r.Close()
+		readers = append(readers, r)
+	}
+	for _, r := range readers {
+		if got, err := r.Get(txKey(42)); err != nil || string(got) != "value-42" {
+			t.Fatalf("read-only Get = %q, %v", got, err)
+		}
+		if _, err := r.Begin(true); !stderrors.Is(err, errors.ErrDatabaseReadOnly) {
+			t.Fatalf("Begin(true) on a read-only store returned %v, want ErrDatabaseReadOnly", err)
+		}
+		if err := r.Put(txKey(0), []byte("v")); !stderrors.Is(err, errors.ErrDatabaseReadOnly) {
+			t.Fatalf("Put on a read-only store returned %v, want ErrDatabaseReadOnly", err)
+		}
+		tx, err := r.Begin(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := tx.Get(txKey(7)); err != nil || string(got) != "value-7" {
+			t.Fatalf("read-only tx Get = %q, %v", got, err)
+		}
+		if err := tx.Rollback(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := open(false); !stderrors.Is(err, errors.ErrTimeout) {
+		t.Fatalf("read-write open while open read-only returned %v, want ErrTimeout", err)
+	}
+}
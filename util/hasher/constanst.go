@@ -1,7 +1,49 @@
 package hasher
 
+import "fmt"
+
+// HashType selects the hash function of a store. It is recorded in the
+// meta, so existing values must never be renumbered.
 type HashType uint32
 
 const (
-	SHA1 HashType = 0x10
+	SHA1      HashType = 0x10
+	SHA256    HashType = 0x20
+	Keccak256 HashType = 0x30 // legacy Keccak as used by the EVM, not NIST SHA3-256
+	BLAKE3    HashType = 0x40
 )
+
+// DefaultHashType is used for new stores when no hash type is given.
+const DefaultHashType = SHA256
+
+// MaxSize is the largest digest size of the supported hash types.
+const MaxSize = 32
+
+// Size returns the digest size in bytes, or 0 for an unknown type.
+func (ht HashType) Size() int {
+	switch ht & 0xf0 {
+	case SHA1:
+		return 20
+	case SHA256, Keccak256, BLAKE3:
+		return 32
+	}
+	return 0
+}
+
+func (ht HashType) Valid() bool {
+	return ht.Size() != 0
+}
+
+func (ht HashType) String() string {
+	switch ht & 0xf0 {
+	case SHA1:
+		return "sha1"
+	case SHA256:
+		return "sha256"
+	case Keccak256:
+		return "keccak256"
+	case BLAKE3:
+		return "blake3"
+	}
+	return fmt.Sprintf("unknown<%#x>", uint32(ht))
+}
@@ -2,24 +2,45 @@ package hasher
 
 import (
 	"crypto/sha1"
+	"crypto/sha256"
 	"hash"
+
+	"github.com/zeebo/blake3"
+	"golang.org/x/crypto/sha3"
 )
 
 type Hasher struct {
+	typ   HashType
 	inner hash.Hash
 	dirty bool
 }
 
+// NewHasher unknown types fall back to SHA-1; check HashType.Valid first.
 func NewHasher(ht HashType) *Hasher {
 	ht = ht & 0xf0
 	switch ht {
 	case SHA1:
-		return &Hasher{inner: sha1.New()}
+		return &Hasher{typ: ht, inner: sha1.New()}
+	case SHA256:
+		return &Hasher{typ: ht, inner: sha256.New()}
+	case Keccak256:
+		return &Hasher{typ: ht, inner: sha3.NewLegacyKeccak256()}
+	case BLAKE3:
+		return &Hasher{typ: ht, inner: blake3.New()}
 	default:
-		return &Hasher{inner: sha1.New()}
+		return &Hasher{typ: SHA1, inner: sha1.New()}
 	}
 }
 
+func (h *Hasher) Type() HashType {
+	return h.typ
+}
+
+// Size returns the digest size in bytes.
+func (h *Hasher) Size() int {
+	return h.inner.Size()
+}
+
 func (h *Hasher) Hash(msg []byte) (hash []byte, err error) {
 	h.cleanIfDirty()
 	h.dirty = true
@@ -32,5 +53,6 @@ func (h *Hasher) Hash(msg []byte) (hash []byte, err error) {
 func (h *Hasher) cleanIfDirty() {
 	if h.dirty {
 		h.inner.Reset()
+		h.dirty = false
 	}
 }
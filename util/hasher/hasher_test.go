@@ -0,0 +1,53 @@
+package hasher
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestHasherKnownDigests(t *testing.T) {
+	for _, tc := range []struct {
+		ht   HashType
+		msg  string
+		want string
+	}{
+		{SHA1, "abc", "a9993e364706816aba3e25717850c26c9cd0d89d"},
+		{SHA256, "abc", "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{Keccak256, "", "c5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470"},
+		{BLAKE3, "", "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262"},
+	} {
+		h := NewHasher(tc.ht)
+		if h.Type() != tc.ht || h.Size() != tc.ht.Size() {
+			t.Fatalf("%v: hasher type %v size %d, want size %d", tc.ht, h.Type(), h.Size(), tc.ht.Size())
+		}
+		// A reused hasher starts from a clean state.
+		for i := 0; i < 2; i++ {
+			got, err := h.Hash([]byte(tc.msg))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hex.EncodeToString(got) != tc.want {
+				t.Fatalf("%v(%q) = %x, want %s", tc.ht, tc.msg, got, tc.want)
+			}
+			if len(got) != tc.ht.Size() {
+				t.Fatalf("%v: digest size %d, want %d", tc.ht, len(got), tc.ht.Size())
+			}
+		}
+	}
+}
+
+func TestHashTypeValid(t *testing.T) {
+	for _, ht := range []HashType{SHA1, SHA256, Keccak256, BLAKE3} {
+		if !ht.Valid() {
+			t.Fatalf("%v is not valid", ht)
+		}
+	}
+	for _, ht := range []HashType{0, 0x50, 0xf0} {
+		if ht.Valid() || ht.Size() != 0 {
+			t.Fatalf("%v is valid", ht)
+		}
+	}
+	if SHA1.Size() != 20 || MaxSize < BLAKE3.Size() {
+		t.Fatalf("sizes: sha1 %d, max %d", SHA1.Size(), MaxSize)
+	}
+}
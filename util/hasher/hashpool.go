@@ -2,17 +2,31 @@ package hasher
 
 import "sync"
 
-var hashPool = sync.Pool{
-	New: func() interface{} {
-		return NewHasher(SHA1)
-	},
+var hashPools = map[HashType]*sync.Pool{
+	SHA1:      newPool(SHA1),
+	SHA256:    newPool(SHA256),
+	Keccak256: newPool(Keccak256),
+	BLAKE3:    newPool(BLAKE3),
 }
 
-func NewHash() *Hasher {
-	return hashPool.Get().(*Hasher)
+func newPool(ht HashType) *sync.Pool {
+	return &sync.Pool{
+		New: func() interface{} {
+			return NewHasher(ht)
+		},
+	}
+}
+
+// NewHash takes a hasher of type ht from its pool; unknown types get SHA-1.
+func NewHash(ht HashType) *Hasher {
+	pool, ok := hashPools[ht&0xf0]
+	if !ok {
+		pool = hashPools[SHA1]
+	}
+	return pool.Get().(*Hasher)
 }
 
 func Return(h *Hasher) {
 	h.cleanIfDirty()
-	hashPool.Put(h)
+	hashPools[h.typ].Put(h)
 }
@@ -11,7 +11,8 @@ const (
 )
 
 const (
-	subTreeNameLen = 20
+	// SubTreeNameLen storage key 中子树名的长度
+	SubTreeNameLen = 20
 )
 
 // ParseKey parse key
@@ -21,7 +22,7 @@ func ParseKey(key []byte) (prefix []byte, subName []byte, realKey []byte) {
 	if bytes.HasPrefix(key, []byte(accountPrefix)) {
 		return []byte(accountPrefix), nil, key[len(accountPrefix):]
 	} else if bytes.HasPrefix(key, []byte(storagePrefix)) {
-		return []byte(storagePrefix), key[len(storagePrefix) : len(storagePrefix)+subTreeNameLen], key[len(storagePrefix)+subTreeNameLen:]
+		return []byte(storagePrefix), key[len(storagePrefix) : len(storagePrefix)+SubTreeNameLen], key[len(storagePrefix)+SubTreeNameLen:]
 	} else if bytes.HasPrefix(key, []byte(codePrefix)) {
 
 	}
@@ -31,3 +32,7 @@ func ParseKey(key []byte) (prefix []byte, subName []byte, realKey []byte) {
 func AccountPrefix() []byte {
 	return []byte(accountPrefix)
 }
+
+func StoragePrefix() []byte {
+	return []byte(storagePrefix)
+}
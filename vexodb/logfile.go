@@ -0,0 +1,213 @@
+package vexodb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/breeze-go-rust/go-tsmm/file"
+	"github.com/breeze-go-rust/go-tsmm/util"
+)
+
+// 记录格式: crc(4B) | length(4B) | seq(8B) | data
+// crc 覆盖 length、seq 与 data；length 为 0 且 crc 不符表示日志结束（直接 I/O 的补零区域），
+// crc 相符时为空 value 的记录
+const recordHeaderSize = 16
+
+var errCorruptRecord = errors.New("vexodb: corrupt record")
+
+type logFile struct {
+	fid      uint32
+	f        *file.File
+	align    int64
+	readOnly bool
+
+	size int64  // 逻辑写入位置
+	base int64  // wbuf[0] 对应的文件偏移，直接 I/O 下按块对齐
+	wbuf []byte // 尚未落盘的数据，直接 I/O 下包含 base 起始的不完整块
+}
+
+func openLogFile(fs file.FS, path string, fid uint32, opts *file.Options) (*logFile, error) {
+	f, err := file.OpenFile(fs, path, opts)
+	if err != nil {
+		return nil, err
+	}
+	lf := &logFile{fid: fid, f: f, align: 1, readOnly: opts != nil && opts.ReadOnly}
+	if f.DirectIO() {
+		lf.align = file.BlockSize
+	}
+	return lf, nil
+}
+
+func encodeRecord(dst []byte, data []byte, seq uint64) {
+	binary.LittleEndian.PutUint32(dst[4:8], uint32(len(data)))
+	binary.LittleEndian.PutUint64(dst[8:16], seq)
+	copy(dst[recordHeaderSize:], data)
+	binary.LittleEndian.PutUint32(dst[0:4], util.NewCRC(dst[4:recordHeaderSize+len(data)]).Value())
+}
+
+// decodeRecord 解析 buf 起始处的记录，返回数据与记录总长度；
+// 遇到结束标记返回 io.EOF，数据不完整返回 io.ErrUnexpectedEOF
+func decodeRecord(buf []byte) ([]byte, int, error) {
+	if len(buf) < recordHeaderSize {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	length := int(binary.LittleEndian.Uint32(buf[4:8]))
+	if len(buf) < recordHeaderSize+length {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	crc := binary.LittleEndian.Uint32(buf[0:4])
+	if util.NewCRC(buf[4:recordHeaderSize+length]).Value() != crc {
+		if length == 0 {
+			return nil, 0, io.EOF
+		}
+		return nil, 0, errCorruptRecord
+	}
+	return buf[recordHeaderSize : recordHeaderSize+length], recordHeaderSize + length, nil
+}
+
+// recover 扫描文件找到最后一条完整记录的位置，作为后续追加的起点
+func (lf *logFile) recover() error {
+	const chunk = 1 << 20
+	var (
+		offset  int64 // data[0] 对应的文件偏移
+		readPos int64 // 下一次读取的位置，始终按 chunk 对齐
+		data    []byte
+	)
+	buf := file.AlignedBlock(chunk)
+	for {
+		n, err := lf.f.ReadAt(readPos, buf)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("error reading value log %d: %w", lf.fid, err)
+		}
+		readPos += int64(n)
+		data = append(data, buf[:n]...)
+		pos := 0
+		for {
+			_, sz, derr := decodeRecord(data[pos:])
+			if derr != nil {
+				if derr == io.ErrUnexpectedEOF && n == len(buf) {
+					break
+				}
+				lf.setSize(offset + int64(pos))
+				return nil
+			}
+			pos += sz
+		}
+		offset += int64(pos)
+		data = append(data[:0], data[pos:]...)
+	}
+}
+
+// setSize 设置逻辑写入位置，并把 base 所在不完整块的内容载入 wbuf
+func (lf *logFile) setSize(size int64) {
+	lf.size = size
+	lf.base = size - size%lf.align
+	lf.wbuf = lf.wbuf[:0]
+	if tail := int(size - lf.base); tail > 0 {
+		block := file.AlignedBlock(int(lf.align))
+		_, _ = lf.f.ReadAt(lf.base, block)
+		lf.wbuf = append(lf.wbuf, block[:tail]...)
+	}
+}
+
+func (lf *logFile) append(data []byte, seq uint64) int64 {
+	offset := lf.size
+	sz := recordHeaderSize + len(data)
+	pos := len(lf.wbuf)
+	lf.wbuf = append(lf.wbuf, make([]byte, sz)...)
+	encodeRecord(lf.wbuf[pos:], data, seq)
+	lf.size += int64(sz)
+	return offset
+}
+
+// flush 把 wbuf 写入文件。直接 I/O 下按块补零写入，
+// 最后一个不完整块保留在 wbuf 中，下次 flush 时整块重写
+func (lf *logFile) flush() error {
+	if len(lf.wbuf) == 0 || lf.readOnly {
+		return nil
+	}
+	var out []byte
+	if lf.align == 1 {
+		out = lf.wbuf
+	} else {
+		out = file.AlignedBlock(int(file.AlignUp(int64(len(lf.wbuf)))))
+		copy(out, lf.wbuf)
+	}
+	if _, err := lf.f.WriteAt(lf.base, out); err != nil {
+		return fmt.Errorf("error writing value log %d: %w", lf.fid, err)
+	}
+	full := len(lf.wbuf) - len(lf.wbuf)%int(lf.align)
+	lf.base += int64(full)
+	lf.wbuf = append(lf.wbuf[:0], lf.wbuf[full:]...)
+	return nil
+}
+
+func (lf *logFile) read(offset int64) ([]byte, error) {
+	if offset >= lf.base && offset < lf.size {
+		data, _, err := decodeRecord(lf.wbuf[offset-lf.base:])
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{}, data...), nil
+	}
+	header, err := lf.readRange(offset, recordHeaderSize)
+	if err != nil {
+		return nil, err
+	}
+	length := int(binary.LittleEndian.Uint32(header[4:8]))
+	buf, err := lf.readRange(offset, recordHeaderSize+length)
+	if err != nil {
+		return nil, err
+	}
+	data, _, err := decodeRecord(buf)
+	return data, err
+}
+
+// readRange 读取 [offset, offset+size)，直接 I/O 下扩展为对齐的块读取
+func (lf *logFile) readRange(offset int64, size int) ([]byte, error) {
+	start := offset - offset%lf.align
+	end := offset + int64(size)
+	if lf.align != 1 {
+		end = file.AlignUp(end)
+	}
+	buf := file.AlignedBlock(int(end - start))
+	n, err := lf.f.ReadAt(start, buf)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("error reading value log %d: %w", lf.fid, err)
+	}
+	lo := int(offset - start)
+	if n < lo+size {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return buf[lo : lo+size], nil
+}
+
+// recordSize 返回 offset 处记录的总长度
+func (lf *logFile) recordSize(offset int64) (int64, error) {
+	var header []byte
+	if offset >= lf.base && offset < lf.size {
+		header = lf.wbuf[offset-lf.base:]
+	} else {
+		var err error
+		if header, err = lf.readRange(offset, recordHeaderSize); err != nil {
+			return 0, err
+		}
+	}
+	return recordHeaderSize + int64(binary.LittleEndian.Uint32(header[4:8])), nil
+}
+
+func (lf *logFile) sync() error {
+	if err := lf.flush(); err != nil {
+		return err
+	}
+	return lf.f.Sync()
+}
+
+func (lf *logFile) close() error {
+	if err := lf.flush(); err != nil {
+		return err
+	}
+	return lf.f.Close()
+}
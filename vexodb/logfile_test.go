@@ -0,0 +1,49 @@
+package vexodb
+
+import (
+	"io"
+	"math"
+	"testing"
+
+	"github.com/breeze-go-rust/go-tsmm/file"
+)
+
+func TestZeroedHeaderIsEnd(t *testing.T) {
+	if _, _, err := decodeRecord(make([]byte, recordHeaderSize*2)); err != io.EOF {
+		t.Fatalf("zeroed header decoded as %v, want io.EOF", err)
+	}
+}
+
+// TestEmptyValue 空 value 与其后的记录在落盘前后、重新打开后都能读出，空 value 不是 nil
+func TestEmptyValue(t *testing.T) {
+	fs := file.NewMemFS()
+	vlog := openTestLog(t, fs)
+	fid, empty := vlog.Update([]byte{}, math.MaxUint64, math.MaxUint64, 1)
+	_, next := vlog.Update([]byte("next"), math.MaxUint64, math.MaxUint64, 2)
+	check := func(when string) {
+		t.Helper()
+		got, err := vlog.Get(fid, empty)
+		if err != nil || got == nil || len(got) != 0 {
+			t.Fatalf("%s: empty value = %q (nil %v), %v", when, got, got == nil, err)
+		}
+		if got, err := vlog.Get(fid, next); err != nil || string(got) != "next" {
+			t.Fatalf("%s: next value = %q, %v", when, got, err)
+		}
+	}
+	check("buffered")
+	if err := vlog.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	check("synced")
+	if err := vlog.Close(); err != nil {
+		t.Fatal(err)
+	}
+	vlog = openTestLog(t, fs)
+	defer vlog.Close()
+	check("reopened")
+	// 恢复时没有把空记录当作日志末尾截断
+	_, last := vlog.Update([]byte("last"), math.MaxUint64, math.MaxUint64, 3)
+	if last <= next {
+		t.Fatalf("append after reopen at %d, overwrote record at %d", last, next)
+	}
+}
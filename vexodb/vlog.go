@@ -1,11 +1,312 @@
 package vexodb
 
-type ValueLog struct{}
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 
+	"github.com/breeze-go-rust/go-tsmm/file"
+)
+
+const (
+	logFileSuffix = ".vlog"
+
+	// DefaultMaxFileSize 单个 value log 文件的默认大小上限
+	DefaultMaxFileSize = 512 << 20
+	// writeBufferSize 写缓冲超过该大小时触发落盘
+	writeBufferSize = 4 << 20
+)
+
+// ValueLog 追加写的 value 日志，叶子页只保存 (fid, index) 指针。
+// Update 不返回错误，写入失败会被记录下来并由 Sync/Close 返回。
+// 提交中失效的记录先记为待定，Commit 后才计入统计，Rollback 时撤销
+type ValueLog struct {
+	mu          sync.RWMutex
+	fs          file.FS
+	dir         string
+	noSync      bool
+	fileOpts    *file.Options
+	maxFileSize int64
+	files       map[uint32]*logFile
+	active      *logFile
+	discard     map[uint32]int64 // 每个文件中已失效的字节数，只含已提交的
+	pending     map[uint32]int64 // 本次提交中失效的字节数
+	appended    map[uint32]int64 // 上次提交以来追加的字节数
+	err         error
+}
+
+func Open(fs file.FS, dir string, noSync bool, fileOpts *file.Options) (*ValueLog, error) {
+	if fs == nil {
+		fs = file.OS
+	}
+	readOnly := fileOpts != nil && fileOpts.ReadOnly
+	if !readOnly {
+		if err := fs.MkdirAll(dir); err != nil {
+			return nil, fmt.Errorf("error creating value log dir %s: %w", dir, err)
+		}
+	}
+	vlog := &ValueLog{
+		fs:          fs,
+		dir:         dir,
+		noSync:      noSync,
+		fileOpts:    fileOpts,
+		maxFileSize: DefaultMaxFileSize,
+		files:       make(map[uint32]*logFile),
+		discard:     make(map[uint32]int64),
+		pending:     make(map[uint32]int64),
+		appended:    make(map[uint32]int64),
+	}
+	fids, err := vlog.listFids()
+	if err != nil {
+		if readOnly && os.IsNotExist(err) {
+			return vlog, nil
+		}
+		return nil, fmt.Errorf("error reading value log dir %s: %w", dir, err)
+	}
+	for _, fid := range fids {
+		lf, err := openLogFile(fs, vlog.path(fid), fid, fileOpts)
+		if err != nil {
+			_ = vlog.Close()
+			return nil, fmt.Errorf("error opening value log %d: %w", fid, err)
+		}
+		vlog.files[fid] = lf
+		vlog.active = lf
+	}
+	if vlog.active == nil {
+		if readOnly {
+			return vlog, nil
+		}
+		return vlog, vlog.rotate()
+	}
+	if err := vlog.active.recover(); err != nil {
+		_ = vlog.Close()
+		return nil, err
+	}
+	return vlog, nil
+}
+
+func (vlog *ValueLog) path(fid uint32) string {
+	return filepath.Join(vlog.dir, fmt.Sprintf("%06d%s", fid, logFileSuffix))
+}
+
+func (vlog *ValueLog) listFids() ([]uint32, error) {
+	names, err := vlog.fs.List(vlog.dir)
+	if err != nil {
+		return nil, err
+	}
+	var fids []uint32
+	for _, name := range names {
+		if !strings.HasSuffix(name, logFileSuffix) {
+			continue
+		}
+		fid, err := strconv.ParseUint(strings.TrimSuffix(name, logFileSuffix), 10, 32)
+		if err != nil {
+			continue
+		}
+		fids = append(fids, uint32(fid))
+	}
+	sort.Slice(fids, func(i, j int) bool { return fids[i] < fids[j] })
+	return fids, nil
+}
+
+// rotate 关闭当前活跃文件的写入，新建下一个文件
+func (vlog *ValueLog) rotate() error {
+	var fid uint32
+	if vlog.active != nil {
+		if err := vlog.active.sync(); err != nil {
+			return err
+		}
+		fid = vlog.active.fid + 1
+	}
+	lf, err := openLogFile(vlog.fs, vlog.path(fid), fid, vlog.fileOpts)
+	if err != nil {
+		return fmt.Errorf("error creating value log %d: %w", fid, err)
+	}
+	if err := vlog.fs.SyncDir(vlog.dir); err != nil {
+		_ = lf.close()
+		return fmt.Errorf("error syncing value log dir %s: %w", vlog.dir, err)
+	}
+	vlog.files[fid] = lf
+	vlog.active = lf
+	return nil
+}
+
+// Update 追加写入 data，返回新记录的 (fid, index)；
+// 旧记录 (fid, index) 有效时（不为 math.MaxUint64）将其标记为失效
 func (vlog *ValueLog) Update(data []byte, fid uint64, index uint64, seq uint64) (uint64, uint64) {
-	return 0, 0
+	vlog.mu.Lock()
+	defer vlog.mu.Unlock()
+	if fid != math.MaxUint64 && index != math.MaxUint64 {
+		vlog.del(fid, index)
+	}
+	if vlog.err != nil {
+		return math.MaxUint64, math.MaxUint64
+	}
+	if vlog.active.size+recordHeaderSize+int64(len(data)) > vlog.maxFileSize && vlog.active.size > 0 {
+		if vlog.err = vlog.rotate(); vlog.err != nil {
+			return math.MaxUint64, math.MaxUint64
+		}
+	}
+	lf := vlog.active
+	offset := lf.append(data, seq)
+	vlog.appended[lf.fid] += lf.size - offset
+	if len(lf.wbuf) >= writeBufferSize {
+		vlog.err = lf.flush()
+	}
+	return uint64(lf.fid), uint64(offset)
+}
+
+// Del 将 (fid, index) 处的记录标记为失效
+func (vlog *ValueLog) Del(fid uint64, index uint64) {
+	vlog.mu.Lock()
+	defer vlog.mu.Unlock()
+	vlog.del(fid, index)
+}
+
+// DelBatch 将 ptrs 中的 (fid, index) 全部标记为失效，整批只加一次锁
+func (vlog *ValueLog) DelBatch(ptrs [][2]uint64) {
+	vlog.mu.Lock()
+	defer vlog.mu.Unlock()
+	for _, ptr := range ptrs {
+		vlog.del(ptr[0], ptr[1])
+	}
+}
+
+func (vlog *ValueLog) del(fid uint64, index uint64) {
+	lf, ok := vlog.files[uint32(fid)]
+	if !ok {
+		return
+	}
+	size, err := lf.recordSize(int64(index))
+	if err != nil {
+		return
+	}
+	vlog.pending[lf.fid] += size
+}
+
+// Commit 本次提交已落盘，提交中失效的记录计入统计
+func (vlog *ValueLog) Commit() {
+	vlog.mu.Lock()
+	defer vlog.mu.Unlock()
+	for fid, size := range vlog.pending {
+		vlog.discard[fid] += size
+	}
+	clear(vlog.pending)
+	clear(vlog.appended)
+}
+
+// Rollback 本次提交失败，撤销提交中失效的标记；期间追加的记录不会被任何版本引用，计为失效
+func (vlog *ValueLog) Rollback() {
+	vlog.mu.Lock()
+	defer vlog.mu.Unlock()
+	for fid, size := range vlog.appended {
+		vlog.discard[fid] += size
+	}
+	clear(vlog.pending)
+	clear(vlog.appended)
+}
+
+// Discards 返回本次提交成功后各文件的失效字节数，随提交一起持久化
+func (vlog *ValueLog) Discards() map[uint32]int64 {
+	vlog.mu.RLock()
+	defer vlog.mu.RUnlock()
+	res := make(map[uint32]int64, len(vlog.discard)+len(vlog.pending))
+	for fid, size := range vlog.discard {
+		res[fid] += size
+	}
+	for fid, size := range vlog.pending {
+		res[fid] += size
+	}
+	return res
+}
+
+// SetDiscards 打开后按持久化的统计恢复各文件的失效字节数，忽略已不存在的文件
+func (vlog *ValueLog) SetDiscards(discards map[uint32]int64) {
+	vlog.mu.Lock()
+	defer vlog.mu.Unlock()
+	clear(vlog.discard)
+	for fid, size := range discards {
+		if _, ok := vlog.files[fid]; ok {
+			vlog.discard[fid] = size
+		}
+	}
+}
+
+// Get 读取 (fid, index) 处的记录
+func (vlog *ValueLog) Get(fid uint64, index uint64) ([]byte, error) {
+	vlog.mu.RLock()
+	defer vlog.mu.RUnlock()
+	lf, ok := vlog.files[uint32(fid)]
+	if !ok {
+		return nil, fmt.Errorf("value log %d not found", fid)
+	}
+	return lf.read(int64(index))
+}
+
+// Sync 将缓冲区写入文件并刷盘，返回此前累积的写入错误
+func (vlog *ValueLog) Sync() error {
+	vlog.mu.Lock()
+	defer vlog.mu.Unlock()
+	if vlog.err != nil {
+		return vlog.err
+	}
+	if vlog.active == nil {
+		return nil
+	}
+	if err := vlog.active.flush(); err != nil {
+		vlog.err = err
+		return err
+	}
+	if vlog.noSync {
+		return nil
+	}
+	return vlog.active.f.Sync()
+}
+
+// Stats value log 的空间统计
+type Stats struct {
+	Files   int
+	Size    int64 // 所有文件的逻辑大小
+	Discard int64 // 已失效记录占用的字节数
+}
+
+// DiscardRatio 已失效字节占总大小的比例，可据此决定是否回收
+func (s Stats) DiscardRatio() float64 {
+	if s.Size == 0 {
+		return 0
+	}
+	return float64(s.Discard) / float64(s.Size)
+}
+
+func (vlog *ValueLog) Stats() Stats {
+	vlog.mu.RLock()
+	defer vlog.mu.RUnlock()
+	s := Stats{Files: len(vlog.files)}
+	for fid, lf := range vlog.files {
+		s.Size += lf.size
+		s.Discard += vlog.discard[fid]
+	}
+	return s
 }
 
-func (vlog *ValueLog) Del(index uint64, fid uint64) {
-	return
+func (vlog *ValueLog) Close() error {
+	vlog.mu.Lock()
+	defer vlog.mu.Unlock()
+	var err error
+	for _, lf := range vlog.files {
+		if cerr := lf.close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	vlog.files = nil
+	vlog.active = nil
+	if vlog.err != nil {
+		return vlog.err
+	}
+	return err
 }
@@ -0,0 +1,81 @@
+package vexodb
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/breeze-go-rust/go-tsmm/file"
+)
+
+func openTestLog(t *testing.T, fs file.FS) *ValueLog {
+	t.Helper()
+	vlog, err := Open(fs, "/vlog", true, &file.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return vlog
+}
+
+func TestDiscardCountedOnCommit(t *testing.T) {
+	vlog := openTestLog(t, file.NewMemFS())
+	defer vlog.Close()
+	fid, index := vlog.Update([]byte("old value"), math.MaxUint64, math.MaxUint64, 1)
+	vlog.Commit()
+	size := vlog.Stats().Size
+
+	vlog.Update([]byte("new value"), fid, index, 2)
+	if got := vlog.Stats().Discard; got != 0 {
+		t.Fatalf("discard before commit = %d, want 0", got)
+	}
+	if got := vlog.Discards()[uint32(fid)]; got != size {
+		t.Fatalf("discard to persist = %d, want %d", got, size)
+	}
+	vlog.Commit()
+	if got := vlog.Stats().Discard; got != size {
+		t.Fatalf("discard after commit = %d, want %d", got, size)
+	}
+}
+
+func TestDiscardUndoneOnRollback(t *testing.T) {
+	vlog := openTestLog(t, file.NewMemFS())
+	defer vlog.Close()
+	fid, index := vlog.Update([]byte("old value"), math.MaxUint64, math.MaxUint64, 1)
+	vlog.Commit()
+	before := vlog.Stats().Size
+
+	vlog.Update([]byte("new value"), fid, index, 2)
+	vlog.Del(fid, index)
+	vlog.Rollback()
+	// 旧记录仍被已提交的版本引用；新记录不再被引用，计为失效
+	s := vlog.Stats()
+	if want := s.Size - before; s.Discard != want {
+		t.Fatalf("discard after rollback = %d, want %d", s.Discard, want)
+	}
+	vlog.Commit()
+	if got := vlog.Stats().Discard; got != s.Discard {
+		t.Fatalf("rolled back discard counted on next commit: %d, want %d", got, s.Discard)
+	}
+}
+
+func TestSetDiscards(t *testing.T) {
+	fs := file.NewMemFS()
+	vlog := openTestLog(t, fs)
+	fid, index := vlog.Update([]byte("value"), math.MaxUint64, math.MaxUint64, 1)
+	vlog.Update([]byte("value"), fid, index, 2)
+	vlog.Commit()
+	want := vlog.Discards()
+	if err := vlog.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	vlog = openTestLog(t, fs)
+	defer vlog.Close()
+	if got := vlog.Stats().Discard; got != 0 {
+		t.Fatalf("discard before restore = %d", got)
+	}
+	vlog.SetDiscards(map[uint32]int64{uint32(fid): want[uint32(fid)], 99: 1})
+	if got := vlog.Discards(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("restored discards = %v, want %v", got, want)
+	}
+}